@@ -1,15 +1,14 @@
 package asset3d
 
 import (
+	"image"
 	"path/filepath"
 
 	tds "github.com/flywave/go-3ds"
 	mst "github.com/flywave/go-mst"
 
 	mat4d "github.com/flywave/go3d/float64/mat4"
-	quatd "github.com/flywave/go3d/float64/quaternion"
 	vec3d "github.com/flywave/go3d/float64/vec3"
-	vec4d "github.com/flywave/go3d/float64/vec4"
 
 	"github.com/flywave/go3d/vec2"
 	"github.com/flywave/go3d/vec3"
@@ -19,6 +18,9 @@ type ThreeDsToMst struct {
 	texId        int
 	backup_texId int
 	baseDir      string
+	texCache     map[*mst.Mesh]map[string]*mst.Texture
+	mtlCache     map[*mst.Mesh]map[tdsMtlKey]int32
+	imgCache     map[string]image.Image
 }
 
 func (cv *ThreeDsToMst) Convert(path string) (*mst.Mesh, *[6]float64, error) {
@@ -28,123 +30,172 @@ func (cv *ThreeDsToMst) Convert(path string) (*mst.Mesh, *[6]float64, error) {
 	mhs := f.GetMeshs()
 	mtls := f.GetMaterials()
 
-	nds := f.GetMeshInstanceNode()
-	ndMap := make(map[string]*tds.MeshInstanceNode)
+	nds, err := read3dsNodes(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	meshIdx := make(map[string]int)
+	for i := range mhs {
+		meshIdx[tdsString([]byte(mhs[i].Name))] = i
+	}
+	// 每个网格被哪些关键帧节点引用
+	refs := make([][]*tdsNode, len(mhs))
 	for _, nd := range nds {
-		ndMap[nd.InstanceName] = nd
+		if i, ok := nd.meshIndex(meshIdx); ok {
+			refs[i] = append(refs[i], nd)
+		}
 	}
+
 	cv.baseDir = filepath.Dir(path)
+	cv.texCache = make(map[*mst.Mesh]map[string]*mst.Texture)
+	cv.mtlCache = make(map[*mst.Mesh]map[tdsMtlKey]int32)
+	cv.imgCache = make(map[string]image.Image)
 	ext := vec3d.MinBox
-	instMp := make(map[string]*mst.InstanceMesh)
 
-	for _, m := range mhs {
-		instsNd, ok := ndMap[m.Name]
-		if !ok {
-			bx := cv.convert3dsMesh(&m, mesh, mtls)
+	for i := range mhs {
+		m := &mhs[i]
+		switch len(refs[i]) {
+		case 0:
+			// 没有关键帧节点时顶点已在世界坐标系中
+			bx := cv.convert3dsMesh(m, mesh, mtls, &mat4d.Ident)
+			ext.Join(bx)
+		case 1:
+			mat := refs[i][0].pivotMatrix()
+			inv := tdsMeshInverse(m)
+			mat = mulMat4(mat, inv)
+			bx := cv.convert3dsMesh(m, mesh, mtls, &mat)
 			ext.Join(bx)
-		} else {
-			var inst *mst.InstanceMesh
-			var ok bool
-			if inst, ok = instMp[m.Name]; !ok {
-				cv.backup_texId = cv.texId
-				cv.texId = 0
-				ins_mesh := mst.NewMesh()
-				bx := cv.convert3dsMesh(&m, ins_mesh, mtls)
-				inst = &mst.InstanceMesh{BBox: bx.Array(), Mesh: &ins_mesh.BaseMesh}
-				instMp[m.Name] = inst
-				cv.texId = cv.backup_texId
+		default:
+			// 多次实例化的网格在局部坐标系中只保存一份，每个节点一个变换
+			cv.backup_texId = cv.texId
+			cv.texId = 0
+			ins_mesh := mst.NewMesh()
+			inv := tdsMeshInverse(m)
+			bx := cv.convert3dsMesh(m, ins_mesh, mtls, &inv)
+			cv.texId = cv.backup_texId
+			inst := &mst.InstanceMesh{BBox: bx.Array(), Mesh: &ins_mesh.BaseMesh}
+			for _, nd := range refs[i] {
+				mat := nd.pivotMatrix()
+				inst.Transfors = append(inst.Transfors, &mat)
+				ext.Join(transformBox(bx, &mat))
 			}
-			inst.Transfors = append(inst.Transfors, cv.toMat(instsNd))
+			mesh.Instances = append(mesh.Instances, inst)
 		}
 	}
-	for _, ins := range instMp {
-		mesh.Instances = append(mesh.Instances, ins)
-	}
 
 	return mesh, ext.Array(), nil
 }
 
-func (cv *ThreeDsToMst) convert3dsMesh(m *tds.Mesh, mstMesh *mst.Mesh, mtls []tds.Material) *vec3d.Box {
+// convert3dsMesh 将网格顶点经 mat 变换后写入 mstMesh，按平滑组生成法线，
+// 不同平滑组交界处的顶点会被拆分
+func (cv *ThreeDsToMst) convert3dsMesh(m *tds.Mesh, mstMesh *mst.Mesh, mtls []tds.Material, mat *mat4d.T) *vec3d.Box {
 	ext := vec3d.MinBox
 	nd := &mst.MeshNode{}
-	mat := mat4d.Ident
-	for i, m := range m.Matrix {
-		mat[i] = vec4d.T{float64(m[0]), float64(m[1]), float64(m[2]), float64(m[3])}
-	}
 
-	for _, v := range m.Vertices {
+	pos := make([]vec3d.T, len(m.Vertices))
+	for i, v := range m.Vertices {
 		vt := &vec3d.T{float64(v[0]), float64(v[1]), float64(v[2])}
-		*vt = mat.MulVec3(vt)
-		ext.Extend(vt)
-		nd.Vertices = append(nd.Vertices, vec3.T{float32(vt[0]), float32(vt[1]), float32(vt[2])})
+		pos[i] = mat.MulVec3(vt)
+		ext.Extend(&pos[i])
 	}
+	// 镜像变换会翻转三角形的朝向
+	flip := mat.Determinant3x3() < 0
 
 	repete := false
 	for _, v := range m.Texcos {
-		nd.TexCoords = append(nd.TexCoords, vec2.T{v[0], v[1]})
 		repete = repete || v[0] > 1.1 || v[1] > 1.1
 	}
 
+	normals := tdsVertexNormals(m.Faces, pos, flip)
+	type vertexKey struct {
+		index  uint16
+		normal vec3.T
+	}
+	vertMap := make(map[vertexKey]uint32)
+
 	tgMap := make(map[int32]*mst.MeshTriangle)
-	for _, f := range m.Faces {
+	for fi, f := range m.Faces {
 		tg, ok := tgMap[f.Material]
 		if !ok {
-			tg = &mst.MeshTriangle{Batchid: int32(len(mstMesh.Materials))}
+			tg = &mst.MeshTriangle{Batchid: cv.convertMaterial(mstMesh, mtls, f.Material, repete)}
 			tgMap[f.Material] = tg
 			nd.FaceGroup = append(nd.FaceGroup, tg)
-			cv.convert3dsMtl(mstMesh, &mtls[f.Material], repete)
 		}
-		tg.Faces = append(tg.Faces, &mst.Face{Vertex: [3]uint32{uint32(f.Index[0]), uint32(f.Index[1]), uint32(f.Index[2])}})
+		var face mst.Face
+		for k, idx := range f.Index {
+			if int(idx) >= len(pos) {
+				face.Vertex[k] = 0
+				continue
+			}
+			key := vertexKey{idx, normals[fi][k]}
+			vi, ok := vertMap[key]
+			if !ok {
+				vi = uint32(len(nd.Vertices))
+				vertMap[key] = vi
+				p := pos[idx]
+				nd.Vertices = append(nd.Vertices, vec3.T{float32(p[0]), float32(p[1]), float32(p[2])})
+				nd.Normals = append(nd.Normals, key.normal)
+				if int(idx) < len(m.Texcos) {
+					nd.TexCoords = append(nd.TexCoords, vec2.T{m.Texcos[idx][0], m.Texcos[idx][1]})
+				}
+			}
+			face.Vertex[k] = vi
+		}
+		if flip {
+			face.Vertex[1], face.Vertex[2] = face.Vertex[2], face.Vertex[1]
+		}
+		tg.Faces = append(tg.Faces, &face)
+	}
+	if len(nd.TexCoords) != len(nd.Vertices) {
+		nd.TexCoords = nil
 	}
 	mstMesh.Nodes = append(mstMesh.Nodes, nd)
 	return &ext
 }
 
-func (cv *ThreeDsToMst) convert3dsMtl(mesh *mst.Mesh, m *tds.Material, repete bool) {
-	texMtl := &mst.PhongMaterial{}
-	mesh.Materials = append(mesh.Materials, texMtl)
-	texMtl.Color[0] = byte(m.Diffuse[0] * 255)
-	texMtl.Color[1] = byte(m.Diffuse[1] * 255)
-	texMtl.Color[2] = byte(m.Diffuse[2] * 255)
-	texMtl.Transparency = m.Transparency
-
-	texMtl.Ambient[0] = byte(m.Ambient[0] * 255)
-	texMtl.Ambient[1] = byte(m.Ambient[1] * 255)
-	texMtl.Ambient[2] = byte(m.Ambient[2] * 255)
-
-	texMtl.Specular[0] = byte(m.Specular[0] * 255)
-	texMtl.Specular[1] = byte(m.Specular[1] * 255)
-	texMtl.Specular[2] = byte(m.Specular[2] * 255)
-
-	texMtl.Shininess = m.Shininess
-	texPath := ""
-	for i := range m.Texture1Map.Name {
-		if m.Texture1Map.Name[i] == 0 {
-			texPath = string(m.Texture1Map.Name[:i])
-			break
+// tdsVertexNormals 计算每个面三个角点的法线: 与 lib3ds 相同，累加共享顶点且平滑组有交集的
+// 相邻面的法线(按面积加权)，平滑组为 0 的面使用自身的面法线
+func tdsVertexNormals(faces []tds.Face, pos []vec3d.T, flip bool) [][3]vec3.T {
+	faceNormals := make([]vec3d.T, len(faces))
+	adj := make([][]int, len(pos))
+	for fi, f := range faces {
+		if int(f.Index[0]) >= len(pos) || int(f.Index[1]) >= len(pos) || int(f.Index[2]) >= len(pos) {
+			continue
 		}
-	}
-	if texPath != "" {
-		texPath = filepath.Join(cv.baseDir, texPath)
-		t, err := convertTex(texPath, cv.texId)
-		if err != nil {
-			return
+		a, b, c := pos[f.Index[0]], pos[f.Index[1]], pos[f.Index[2]]
+		e1 := vec3d.Sub(&b, &a)
+		e2 := vec3d.Sub(&c, &a)
+		n := vec3d.Cross(&e1, &e2)
+		if flip {
+			n.Invert()
+		}
+		faceNormals[fi] = n
+		for _, idx := range f.Index {
+			adj[idx] = append(adj[idx], fi)
 		}
-		t.Repeated = repete
-		cv.texId++
-		texMtl.Texture = t
 	}
-}
 
-func (cv *ThreeDsToMst) toMat(nd *tds.MeshInstanceNode) *mat4d.T {
-	m := &mat4d.T{}
-	q := quatd.FromVec4(&vec4d.T{float64(nd.Rot[0]), float64(nd.Rot[1]), float64(nd.Rot[2]), float64(nd.Rot[3])})
-	t := &vec3d.T{float64(nd.Pos[0]), float64(nd.Pos[1]), float64(nd.Pos[2])}
-	s := &vec3d.T{float64(nd.Scl[0]), float64(nd.Scl[1]), float64(nd.Scl[2])}
-	m.AssignQuaternion(&q)
-	m.ScaleVec3(s)
-	m.Translate(t)
-	return m
+	res := make([][3]vec3.T, len(faces))
+	for fi, f := range faces {
+		for k, idx := range f.Index {
+			n := faceNormals[fi]
+			if f.SmoothingGroup != 0 && int(idx) < len(pos) {
+				n = vec3d.T{}
+				for _, g := range adj[idx] {
+					if g == fi || faces[g].SmoothingGroup&f.SmoothingGroup != 0 {
+						n.Add(&faceNormals[g])
+					}
+				}
+			}
+			if n.LengthSqr() > 0 {
+				n.Normalize()
+			} else {
+				n = vec3d.T{0, 0, 1}
+			}
+			res[fi][k] = vec3.T{float32(n[0]), float32(n[1]), float32(n[2])}
+		}
+	}
+	return res
 }
 
 // Ensure ThreeDsToMst implements FormatConvert interface
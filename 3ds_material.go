@@ -0,0 +1,218 @@
+package asset3d
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	tds "github.com/flywave/go-3ds"
+	mst "github.com/flywave/go-mst"
+)
+
+// tdsBumpNormalScale 凹凸贴图转换为法线贴图时的强度，再乘以贴图的百分比
+const tdsBumpNormalScale = 2.0
+
+type tdsMtlKey struct {
+	index  int32
+	repete bool
+}
+
+// convertMaterial 返回 3DS 材质在 mstMh 中的索引，同一网格内相同的材质只转换一次，
+// 没有材质的面使用白色默认材质
+func (cv *ThreeDsToMst) convertMaterial(mstMh *mst.Mesh, mtls []tds.Material, index int32, repete bool) int32 {
+	if index < 0 || int(index) >= len(mtls) {
+		index = -1
+	}
+	key := tdsMtlKey{index: index, repete: repete}
+	cache, ok := cv.mtlCache[mstMh]
+	if !ok {
+		cache = make(map[tdsMtlKey]int32)
+		cv.mtlCache[mstMh] = cache
+	}
+	if idx, ok := cache[key]; ok {
+		return idx
+	}
+
+	idx := int32(len(mstMh.Materials))
+	var mtl mst.MeshMaterial
+	if index < 0 {
+		def := &mst.PhongMaterial{}
+		def.Color = [3]byte{255, 255, 255}
+		def.Diffuse = def.Color
+		mtl = def
+	} else {
+		mtl = cv.convert3dsMtl(mstMh, &mtls[index], repete)
+	}
+	mstMh.Materials = append(mstMh.Materials, mtl)
+	cache[key] = idx
+	return idx
+}
+
+// convert3dsMtl 转换 3DS 材质。MST 只有基础色和法线两个纹理槽: 透明度贴图合并到基础色纹理的
+// alpha 通道，凹凸贴图转换为法线贴图，高光贴图和反射贴图按平均亮度折算为高光颜色和高光强度
+func (cv *ThreeDsToMst) convert3dsMtl(mstMh *mst.Mesh, m *tds.Material, repete bool) mst.MeshMaterial {
+	diffuse := colorToBytes(tdsColor(m.Diffuse), 1)
+
+	specular := 1.0
+	if amount, ok := cv.mapAmount(&m.SpecularMap); ok {
+		specular = amount
+	}
+	specularity := float64(m.ShinStrength)
+	if amount, ok := cv.mapAmount(&m.ReflectionMap); ok {
+		specularity += amount
+	}
+	if specularity > 1 {
+		specularity = 1
+	}
+
+	mtl := &mst.PhongMaterial{
+		Specular:    colorToBytes(tdsColor(m.Specular), specular),
+		Shininess:   m.Shininess,
+		Specularity: float32(specularity),
+	}
+	mtl.Color = diffuse
+	mtl.Diffuse = diffuse
+	mtl.Ambient = colorToBytes(tdsColor(m.Ambient), 1)
+	if m.SelfIllumFlag != 0 {
+		mtl.Emissive = colorToBytes(tdsColor(m.Diffuse), float64(m.SelfIllum))
+	}
+	mtl.Transparency = m.Transparency
+	mtl.Texture = cv.diffuseTexture(mstMh, &m.Texture1Map, &m.OpacityMap, diffuse, repete)
+	mtl.Normal = cv.normalTexture(mstMh, &m.BumpMap, repete)
+	return mtl
+}
+
+// diffuseTexture 基础色纹理，存在透明度贴图时合并到 alpha 通道
+func (cv *ThreeDsToMst) diffuseTexture(mstMh *mst.Mesh, base, opacity *tds.TextureMap, cl [3]byte, repete bool) *mst.Texture {
+	baseName, opacityName := tdsString(base.Name[:]), tdsString(opacity.Name[:])
+	if opacityName == "" {
+		if baseName == "" {
+			return nil
+		}
+		return cv.cachedTexture(mstMh, baseName, repete, func() image.Image {
+			return cv.textureImage(baseName)
+		})
+	}
+	key := "alpha:" + opacityName
+	if baseName != "" {
+		key = baseName + "|" + key
+	}
+	return cv.cachedTexture(mstMh, key, repete, func() image.Image {
+		mask := cv.textureImage(opacityName)
+		var img image.Image
+		if baseName != "" {
+			img = cv.textureImage(baseName)
+		}
+		if mask == nil {
+			return img
+		}
+		return imageWithAlpha(img, cl, mask)
+	})
+}
+
+// normalTexture 由凹凸贴图生成法线贴图，强度随贴图百分比变化
+func (cv *ThreeDsToMst) normalTexture(mstMh *mst.Mesh, bump *tds.TextureMap, repete bool) *mst.Texture {
+	name := tdsString(bump.Name[:])
+	if name == "" {
+		return nil
+	}
+	strength := tdsBumpNormalScale * tdsMapPercent(bump)
+	key := "bump:" + strconv.FormatFloat(strength, 'g', -1, 64) + ":" + name
+	return cv.cachedTexture(mstMh, key, repete, func() image.Image {
+		img := cv.textureImage(name)
+		if img == nil {
+			return nil
+		}
+		return heightToNormal(img, strength)
+	})
+}
+
+// mapAmount 贴图平均亮度与百分比的乘积，没有贴图时返回 false
+func (cv *ThreeDsToMst) mapAmount(m *tds.TextureMap) (float64, bool) {
+	name := tdsString(m.Name[:])
+	if name == "" {
+		return 0, false
+	}
+	percent := tdsMapPercent(m)
+	img := cv.textureImage(name)
+	if img == nil {
+		return percent, true
+	}
+	mean, ok := imageMean(img)
+	if !ok {
+		return percent, true
+	}
+	return percent * (mean[0] + mean[1] + mean[2]) / 3, true
+}
+
+// cachedTexture 同一个 MST 网格内复用纹理，避免重复解码和写入
+func (cv *ThreeDsToMst) cachedTexture(mstMh *mst.Mesh, key string, repete bool, load func() image.Image) *mst.Texture {
+	cache, ok := cv.texCache[mstMh]
+	if !ok {
+		cache = make(map[string]*mst.Texture)
+		cv.texCache[mstMh] = cache
+	}
+	if tex, ok := cache[key]; ok {
+		if tex != nil {
+			tex.Repeated = tex.Repeated || repete
+		}
+		return tex
+	}
+	var tex *mst.Texture
+	if img := load(); img != nil {
+		tex = imageToTex(img, cv.texId)
+		tex.Repeated = repete
+		cv.texId++
+	}
+	cache[key] = tex
+	return tex
+}
+
+// textureImage 读取并缓存纹理图像，找不到或无法解码时返回 nil
+func (cv *ThreeDsToMst) textureImage(name string) image.Image {
+	if img, ok := cv.imgCache[name]; ok {
+		return img
+	}
+	var img image.Image
+	if p := cv.resolveTexture(name); p != "" {
+		img, _ = loadImage(p)
+	}
+	cv.imgCache[name] = img
+	return img
+}
+
+// resolveTexture 在 3DS 文件所在目录中查找纹理，3DS 中的文件名多为大写的 8.3 格式，
+// 找不到时忽略大小写再匹配一次
+func (cv *ThreeDsToMst) resolveTexture(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	for _, p := range []string{filepath.Join(cv.baseDir, name), filepath.Join(cv.baseDir, filepath.Base(name))} {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	name = filepath.Base(name)
+	entries, err := os.ReadDir(cv.baseDir)
+	if err != nil {
+		return ""
+	}
+	for _, e := range entries {
+		if !e.IsDir() && strings.EqualFold(e.Name(), name) {
+			return filepath.Join(cv.baseDir, e.Name())
+		}
+	}
+	return ""
+}
+
+// tdsMapPercent 贴图的百分比，未设置时按 100% 处理
+func tdsMapPercent(m *tds.TextureMap) float64 {
+	if m.Percent <= 0 {
+		return 1
+	}
+	return float64(m.Percent)
+}
+
+func tdsColor(c [3]float32) [3]float64 {
+	return [3]float64{float64(c[0]), float64(c[1]), float64(c[2])}
+}
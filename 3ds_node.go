@@ -0,0 +1,332 @@
+package asset3d
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	tds "github.com/flywave/go-3ds"
+
+	mat4d "github.com/flywave/go3d/float64/mat4"
+	vec3d "github.com/flywave/go3d/float64/vec3"
+	vec4d "github.com/flywave/go3d/float64/vec4"
+)
+
+// 3DS 块标识，与 lib3ds 一致
+const (
+	tdsChunkMain       = 0x4D4D
+	tdsChunkKfData     = 0xB000
+	tdsChunkObjectNode = 0xB002
+	tdsChunkNodeHdr    = 0xB010
+	tdsChunkInstance   = 0xB011
+	tdsChunkPivot      = 0xB013
+	tdsChunkPosTrack   = 0xB020
+	tdsChunkRotTrack   = 0xB021
+	tdsChunkSclTrack   = 0xB022
+	tdsChunkNodeId     = 0xB030
+
+	// tdsNoParent 节点头中表示没有父节点的索引
+	tdsNoParent = 0xFFFF
+)
+
+// tdsNode 关键帧数据(KFDATA)中的节点，只取第0帧的姿态。
+// go-3ds 读取节点时会丢失节点类型，这里直接解析文件中的关键帧块。
+type tdsNode struct {
+	id       uint16
+	parentId uint16
+	// isMesh 是否为网格实例节点(OBJECT_NODE_TAG)，相机和灯光节点只参与层级
+	isMesh bool
+	// name 引用的网格名，虚拟节点为 $$$DUMMY
+	name string
+	// instance 实例名，同一网格被多次实例化时用于区分
+	instance string
+	pivot    vec3d.T
+	pos      vec3d.T
+	// rot 四元数 x, y, z, w
+	rot vec4d.T
+	scl vec3d.T
+
+	parent *tdsNode
+	world  *mat4d.T
+}
+
+// read3dsNodes 读取文件中的关键帧节点并计算世界矩阵，文件没有关键帧数据时返回空
+func read3dsNodes(path string) ([]*tdsNode, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	id, size, err := tdsReadChunkHeader(f)
+	if err != nil || id != tdsChunkMain {
+		return nil, err
+	}
+	// 跳过编辑器数据，只把关键帧块读入内存
+	var kf []byte
+	for pos := int64(6); pos+6 <= int64(size); {
+		cid, csize, err := tdsReadChunkHeader(f)
+		if err != nil {
+			return nil, err
+		}
+		if csize < 6 {
+			return nil, fmt.Errorf("3ds: invalid chunk 0x%04X size %d", cid, csize)
+		}
+		if cid == tdsChunkKfData {
+			kf = make([]byte, csize-6)
+			if _, err := io.ReadFull(f, kf); err != nil {
+				return nil, err
+			}
+			break
+		}
+		pos += int64(csize)
+		if _, err := f.Seek(pos, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	if kf == nil {
+		return nil, nil
+	}
+
+	var nodes []*tdsNode
+	err = tdsEachChunk(kf, func(cid uint16, data []byte) error {
+		// 0xB001 ~ 0xB007 为各类节点
+		if cid < 0xB001 || cid > 0xB007 {
+			return nil
+		}
+		nd := &tdsNode{
+			id:       uint16(len(nodes)),
+			parentId: tdsNoParent,
+			isMesh:   cid == tdsChunkObjectNode,
+			rot:      vec4d.T{0, 0, 0, 1},
+			scl:      vec3d.T{1, 1, 1},
+		}
+		nodes = append(nodes, nd)
+		return nd.read(data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	byId := make(map[uint16]*tdsNode, len(nodes))
+	for _, nd := range nodes {
+		byId[nd.id] = nd
+	}
+	for _, nd := range nodes {
+		if p, ok := byId[nd.parentId]; ok && nd.parentId != tdsNoParent && p != nd {
+			nd.parent = p
+		}
+	}
+	for _, nd := range nodes {
+		nd.worldMatrix(0)
+	}
+	return nodes, nil
+}
+
+func (nd *tdsNode) read(data []byte) error {
+	return tdsEachChunk(data, func(cid uint16, data []byte) error {
+		r := bytes.NewReader(data)
+		switch cid {
+		case tdsChunkNodeId:
+			return binary.Read(r, binary.LittleEndian, &nd.id)
+		case tdsChunkNodeHdr:
+			nd.name = tdsReadString(r)
+			var hdr struct{ Flags1, Flags2, Parent uint16 }
+			if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+				return err
+			}
+			nd.parentId = hdr.Parent
+		case tdsChunkInstance:
+			nd.instance = tdsReadString(r)
+		case tdsChunkPivot:
+			return tdsReadVector(r, nd.pivot[:])
+		case tdsChunkPosTrack:
+			var v [3]float64
+			if ok, err := tdsReadFirstKey(r, v[:]); err != nil || !ok {
+				return err
+			}
+			nd.pos = v
+		case tdsChunkRotTrack:
+			// 旋转关键帧为角度和旋转轴
+			var v [4]float64
+			if ok, err := tdsReadFirstKey(r, v[:]); err != nil || !ok {
+				return err
+			}
+			nd.rot = tdsAxisAngle(vec3d.T{v[1], v[2], v[3]}, v[0])
+		case tdsChunkSclTrack:
+			var v [3]float64
+			if ok, err := tdsReadFirstKey(r, v[:]); err != nil || !ok {
+				return err
+			}
+			nd.scl = v
+		}
+		return nil
+	})
+}
+
+// worldMatrix 按 lib3ds 的顺序计算 父节点矩阵·平移·旋转·缩放，depth 防止循环引用
+func (nd *tdsNode) worldMatrix(depth int) mat4d.T {
+	if nd.world != nil {
+		return *nd.world
+	}
+	q := nd.rot
+	x, y, z, w := q[0], q[1], q[2], q[3]
+	s := 2.0
+	if l := x*x + y*y + z*z + w*w; l > 1e-8 {
+		s = 2 / l
+	}
+	m := mat4d.T{
+		vec4d.T{(1 - s*(y*y+z*z)) * nd.scl[0], s * (x*y + w*z) * nd.scl[0], s * (x*z - w*y) * nd.scl[0], 0},
+		vec4d.T{s * (x*y - w*z) * nd.scl[1], (1 - s*(x*x+z*z)) * nd.scl[1], s * (y*z + w*x) * nd.scl[1], 0},
+		vec4d.T{s * (x*z + w*y) * nd.scl[2], s * (y*z - w*x) * nd.scl[2], (1 - s*(x*x+y*y)) * nd.scl[2], 0},
+		vec4d.T{nd.pos[0], nd.pos[1], nd.pos[2], 1},
+	}
+	if nd.parent != nil && depth < 256 {
+		m = mulMat4(nd.parent.worldMatrix(depth+1), m)
+	}
+	nd.world = &m
+	return m
+}
+
+// meshIndex 查找节点引用的网格，与 lib3ds 一样按节点名匹配，实例名只用于区分同一网格的多个节点，
+// 节点名找不到时再按实例名匹配
+func (nd *tdsNode) meshIndex(meshes map[string]int) (int, bool) {
+	if !nd.isMesh {
+		return 0, false
+	}
+	if i, ok := meshes[nd.name]; ok {
+		return i, true
+	}
+	if nd.instance == "" {
+		return 0, false
+	}
+	i, ok := meshes[nd.instance]
+	return i, ok
+}
+
+// pivotMatrix 将网格局部坐标变换到世界坐标: 世界矩阵·平移(-轴心)
+func (nd *tdsNode) pivotMatrix() mat4d.T {
+	m := nd.worldMatrix(0)
+	p := vec3d.T{-nd.pivot[0], -nd.pivot[1], -nd.pivot[2]}
+	t := m.MulVec3(&p)
+	m.SetTranslation(&t)
+	return m
+}
+
+// tdsMeshMatrix 网格自身的矩阵，即网格局部坐标到建模时世界坐标的变换
+func tdsMeshMatrix(m *tds.Mesh) mat4d.T {
+	mat := mat4d.Ident
+	for i, c := range m.Matrix {
+		mat[i] = vec4d.T{float64(c[0]), float64(c[1]), float64(c[2]), float64(c[3])}
+	}
+	return mat
+}
+
+// tdsMeshInverse 将网格顶点(建模时的世界坐标)变换回网格局部坐标，矩阵不可逆时返回单位阵
+func tdsMeshInverse(m *tds.Mesh) mat4d.T {
+	mat := tdsMeshMatrix(m)
+	if math.Abs(mat.Determinant()) < 1e-12 {
+		return mat4d.Ident
+	}
+	return mat.Inverted()
+}
+
+// tdsAxisAngle 与 lib3ds_quat_axis_angle 相同，3DS 的旋转角取反
+func tdsAxisAngle(axis vec3d.T, angle float64) vec4d.T {
+	l := axis.Length()
+	if l < 1e-8 {
+		return vec4d.T{0, 0, 0, 1}
+	}
+	omega := -0.5 * angle
+	s := math.Sin(omega) / l
+	return vec4d.T{s * axis[0], s * axis[1], s * axis[2], math.Cos(omega)}
+}
+
+func tdsReadChunkHeader(r io.Reader) (uint16, uint32, error) {
+	var hdr struct {
+		Id   uint16
+		Size uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return 0, 0, err
+	}
+	return hdr.Id, hdr.Size, nil
+}
+
+// tdsEachChunk 遍历 data 中的子块
+func tdsEachChunk(data []byte, fn func(id uint16, data []byte) error) error {
+	for len(data) >= 6 {
+		id := binary.LittleEndian.Uint16(data)
+		size := binary.LittleEndian.Uint32(data[2:])
+		if size < 6 || int64(size) > int64(len(data)) {
+			return fmt.Errorf("3ds: invalid chunk 0x%04X size %d", id, size)
+		}
+		if err := fn(id, data[6:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+func tdsReadString(r *bytes.Reader) string {
+	var buf []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil || c == 0 {
+			return string(buf)
+		}
+		buf = append(buf, c)
+	}
+}
+
+func tdsReadVector(r io.Reader, v []float64) error {
+	f := make([]float32, len(v))
+	if err := binary.Read(r, binary.LittleEndian, f); err != nil {
+		return err
+	}
+	for i := range f {
+		v[i] = float64(f[i])
+	}
+	return nil
+}
+
+// tdsReadFirstKey 读取关键帧轨迹的第一个关键帧，轨迹为空时返回 false
+func tdsReadFirstKey(r io.Reader, v []float64) (bool, error) {
+	var hdr struct {
+		Flags    uint16
+		Unknown  [2]uint32
+		NumKeys  uint32
+		Frame    uint32
+		KeyFlags uint16
+	}
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return false, err
+	}
+	if hdr.NumKeys == 0 {
+		return false, nil
+	}
+	// 张力、连续性、偏移、缓入、缓出各占一个浮点数
+	var tcb [5]float32
+	n := 0
+	for i := uint(0); i < 5; i++ {
+		if hdr.KeyFlags&(1<<i) != 0 {
+			n++
+		}
+	}
+	if err := binary.Read(r, binary.LittleEndian, tcb[:n]); err != nil {
+		return false, err
+	}
+	return true, tdsReadVector(r, v)
+}
+
+// tdsString 截取 go-3ds 定长名字中第一个 0 之前的部分
+func tdsString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		return string(b[:i])
+	}
+	return string(b)
+}
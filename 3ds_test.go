@@ -0,0 +1,183 @@
+package asset3d
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tds "github.com/flywave/go-3ds"
+	vec3d "github.com/flywave/go3d/float64/vec3"
+	"github.com/flywave/go3d/vec3"
+)
+
+// tdsTestChunk 写入一个 3DS 块，内容为 parts 依次的小端编码
+func tdsTestChunk(id uint16, parts ...interface{}) []byte {
+	var body bytes.Buffer
+	for _, p := range parts {
+		switch v := p.(type) {
+		case []byte:
+			body.Write(v)
+		case string:
+			body.WriteString(v)
+			body.WriteByte(0)
+		default:
+			binary.Write(&body, binary.LittleEndian, v)
+		}
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, id)
+	binary.Write(&buf, binary.LittleEndian, uint32(body.Len()+6))
+	buf.Write(body.Bytes())
+	return buf.Bytes()
+}
+
+// tdsTestTrack 只有第 0 帧一个关键帧的轨迹
+func tdsTestTrack(id uint16, v ...float32) []byte {
+	hdr := struct {
+		Flags    uint16
+		Unknown  [2]uint32
+		NumKeys  uint32
+		Frame    uint32
+		KeyFlags uint16
+	}{NumKeys: 1}
+	return tdsTestChunk(id, hdr, v)
+}
+
+// tdsTestNode 关键帧节点，extra 为附加的子块
+func tdsTestNode(tag, id, parent uint16, name string, extra ...[]byte) []byte {
+	parts := []interface{}{
+		tdsTestChunk(tdsChunkNodeId, id),
+		tdsTestChunk(tdsChunkNodeHdr, name, [3]uint16{0, 0, parent}),
+	}
+	for _, e := range extra {
+		parts = append(parts, e)
+	}
+	return tdsTestChunk(tag, parts...)
+}
+
+func TestRead3dsNodes(t *testing.T) {
+	kf := tdsTestChunk(tdsChunkKfData,
+		// 轴心 (1,0,0)，平移 (10,0,0)，绕 z 轴 90 度，缩放 2
+		tdsTestNode(tdsChunkObjectNode, 0, tdsNoParent, "Box",
+			tdsTestChunk(tdsChunkPivot, [3]float32{1, 0, 0}),
+			tdsTestTrack(tdsChunkPosTrack, 10, 0, 0),
+			tdsTestTrack(tdsChunkRotTrack, math.Pi/2, 0, 0, 1),
+			tdsTestTrack(tdsChunkSclTrack, 2, 2, 2)),
+		// 同一网格的第二个实例
+		tdsTestNode(tdsChunkObjectNode, 1, 0, "Box",
+			tdsTestChunk(tdsChunkInstance, "Copy"),
+			tdsTestTrack(tdsChunkPosTrack, 0, 5, 0)),
+		// 节点名不是网格名时按实例名匹配
+		tdsTestNode(tdsChunkObjectNode, 2, tdsNoParent, "Other",
+			tdsTestChunk(tdsChunkInstance, "Box")),
+		// 相机节点只参与层级
+		tdsTestNode(0xB003, 3, 0, "Camera01"),
+	)
+	// 编辑器数据块在读取节点时跳过
+	data := tdsTestChunk(tdsChunkMain, tdsTestChunk(0x3D3D, tdsTestChunk(0x3D3E, uint32(3))), kf)
+	path := filepath.Join(t.TempDir(), "nodes.3ds")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, err := read3dsNodes(path)
+	if err != nil {
+		t.Fatalf("读取节点失败: %v", err)
+	}
+	if len(nodes) != 4 {
+		t.Fatalf("节点数量 %d, 期望 4", len(nodes))
+	}
+	if nodes[1].parent != nodes[0] || nodes[3].parent != nodes[0] || nodes[0].parent != nil {
+		t.Errorf("父节点错误")
+	}
+	if nodes[1].instance != "Copy" || nodes[0].pivot != (vec3d.T{1, 0, 0}) {
+		t.Errorf("节点数据错误: %+v", nodes[1])
+	}
+
+	near := func(name string, got, want vec3d.T) {
+		t.Helper()
+		for i := range got {
+			if math.Abs(got[i]-want[i]) > 1e-5 {
+				t.Errorf("%s: %v, 期望 %v", name, got, want)
+				return
+			}
+		}
+	}
+	// lib3ds 的旋转角取反，90 度将 x 轴转到 -y 轴
+	m := nodes[0].worldMatrix(0)
+	near("世界矩阵", m.MulVec3(&vec3d.T{1, 0, 0}), vec3d.T{10, -2, 0})
+	m = nodes[1].worldMatrix(0)
+	near("子节点世界矩阵", m.MulVec3(&vec3d.T{}), vec3d.T{20, 0, 0})
+	// 轴心先平移到原点
+	m = nodes[0].pivotMatrix()
+	near("轴心", m.MulVec3(&vec3d.T{1, 0, 0}), vec3d.T{10, 0, 0})
+	near("轴心矩阵", m.MulVec3(&vec3d.T{2, 0, 0}), vec3d.T{10, -2, 0})
+
+	meshes := map[string]int{"Box": 0, "Copy": 1}
+	for i, want := range []int{0, 0, 0, -1} {
+		idx, ok := nodes[i].meshIndex(meshes)
+		if !ok {
+			idx = -1
+		}
+		if idx != want {
+			t.Errorf("节点 %d 的网格 %d, 期望 %d", i, idx, want)
+		}
+	}
+
+	// 没有关键帧数据的文件
+	path = filepath.Join(t.TempDir(), "empty.3ds")
+	if err := os.WriteFile(path, tdsTestChunk(tdsChunkMain, tdsTestChunk(0x3D3D)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if nodes, err := read3dsNodes(path); err != nil || nodes != nil {
+		t.Errorf("没有关键帧时应返回空: %v %v", nodes, err)
+	}
+}
+
+func TestTdsVertexNormals(t *testing.T) {
+	// 两个面沿 x 轴折成直角，面 0 朝 +z，面 1 朝 +y，共用顶点 0 和 1
+	pos := []vec3d.T{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	s := float32(math.Sqrt(0.5))
+	z, y, yz := vec3.T{0, 0, 1}, vec3.T{0, 1, 0}, vec3.T{0, s, s}
+	tests := []struct {
+		name   string
+		groups [2]uint32
+		flip   bool
+		want   [2][3]vec3.T
+	}{
+		{"同一平滑组", [2]uint32{1, 1}, false, [2][3]vec3.T{{yz, yz, z}, {yz, yz, y}}},
+		{"平滑组有交集", [2]uint32{3, 2}, false, [2][3]vec3.T{{yz, yz, z}, {yz, yz, y}}},
+		{"不同平滑组", [2]uint32{1, 2}, false, [2][3]vec3.T{{z, z, z}, {y, y, y}}},
+		// 平滑组为 0 的面只用自身的面法线，也不参与相邻面的平均
+		{"平滑组为 0", [2]uint32{0, 1}, false, [2][3]vec3.T{{z, z, z}, {y, y, y}}},
+		{"翻转", [2]uint32{1, 2}, true, [2][3]vec3.T{{z.Inverted(), z.Inverted(), z.Inverted()}, {y.Inverted(), y.Inverted(), y.Inverted()}}},
+	}
+	for _, tt := range tests {
+		faces := []tds.Face{
+			{Index: [3]uint16{0, 1, 2}, SmoothingGroup: tt.groups[0]},
+			{Index: [3]uint16{1, 0, 3}, SmoothingGroup: tt.groups[1]},
+		}
+		got := tdsVertexNormals(faces, pos, tt.flip)
+		for fi := range faces {
+			for k := range got[fi] {
+				d := vec3.Sub(&got[fi][k], &tt.want[fi][k])
+				if d.Length() > 1e-6 {
+					t.Errorf("%s: 面 %d 角点 %d 法线 %v, 期望 %v", tt.name, fi, k, got[fi][k], tt.want[fi][k])
+				}
+			}
+		}
+	}
+
+	// 退化面和越界索引使用默认法线
+	faces := []tds.Face{{Index: [3]uint16{0, 0, 1}, SmoothingGroup: 1}, {Index: [3]uint16{0, 1, 9}}}
+	for fi, ns := range tdsVertexNormals(faces, pos, false) {
+		for _, n := range ns {
+			if n != z {
+				t.Errorf("面 %d 的默认法线 %v", fi, n)
+			}
+		}
+	}
+}
@@ -23,7 +23,9 @@ func FormatFactory(format string) FormatConvert {
 	case TBIN:
 		return &ThreejsBinToMst{}
 	case STL:
-		return &StlToMst{}
+		return NewStlToMst()
+	case PLY:
+		return &PlyToMst{}
 	case RVM:
 		return &RvmToMst{}
 	case TILES_OBJ:
@@ -33,3 +35,20 @@ func FormatFactory(format string) FormatConvert {
 	}
 	return nil
 }
+
+// FormatExport 将 MST 网格写出为其他格式，与 FormatConvert 相对
+type FormatExport interface {
+	Export(mesh *mst.Mesh, path string) error
+}
+
+func ExportFactory(format string) FormatExport {
+	switch format {
+	case OBJ:
+		return &MstToObj{}
+	case STL:
+		return &MstToStl{}
+	case PLY:
+		return &MstToPly{}
+	}
+	return nil
+}
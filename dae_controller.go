@@ -0,0 +1,509 @@
+package asset3d
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	dae "github.com/flywave/go-collada"
+	mst "github.com/flywave/go-mst"
+	mat4d "github.com/flywave/go3d/float64/mat4"
+	vec3d "github.com/flywave/go3d/float64/vec3"
+	"github.com/flywave/go3d/vec3"
+)
+
+// DaeMaxInfluences 每个顶点保留的最大关节数
+const DaeMaxInfluences = 4
+
+// DaeBone 蒙皮关节及其祖先节点组成的层级中的一个节点，父节点总是排在子节点之前
+type DaeBone struct {
+	Id     string
+	Sid    string
+	Name   string
+	Parent int     // 父节点在 Skeleton 中的索引，根节点为 -1
+	Local  mat4d.T // 场景中的局部变换
+	Global mat4d.T // 场景中的全局变换
+}
+
+// DaeSkin 节点的蒙皮，Joints 为 Bones 中的下标，与节点顶点一一对应。
+// 场景中找不到的关节不在 Bones 中，其权重按零处理，其余权重重新归一化
+type DaeSkin struct {
+	Bones       []int     // 关节在 Skeleton 中的索引
+	InverseBind []mat4d.T // 将节点顶点变换到各关节绑定空间的矩阵，已包含 bind_shape_matrix
+	Joints      [][DaeMaxInfluences]uint16
+	Weights     [][DaeMaxInfluences]float32
+}
+
+// DaeMorph 变形控制器，混合结果为 顶点 + Σ Weight·Offsets，
+// NORMALIZED 方式的目标已换算为相对基础网格的偏移
+type DaeMorph struct {
+	Id      string
+	Method  string
+	Targets []*DaeMorphTarget
+}
+
+type DaeMorphTarget struct {
+	Name    string // 目标几何体的 id
+	Weight  float64
+	Offsets []vec3.T // 与节点顶点一一对应，位于节点顶点所在空间
+}
+
+// DaeAnimation library_animations 中的一个顶层动画，嵌套动画的通道合并到一起
+type DaeAnimation struct {
+	Id       string
+	Name     string
+	Channels []*DaeAnimationChannel
+}
+
+// DaeAnimationChannel 一条动画曲线，Target 为原始的目标路径，例如 "node/rotateZ.ANGLE"
+type DaeAnimationChannel struct {
+	Target        string
+	Node          string // 目标节点的 id
+	Member        string // 节点内的变换元素 sid 及分量
+	Bone          int    // 目标节点在 Skeleton 中的索引，不是蒙皮关节时为 -1
+	Times         []float64
+	Values        []float64
+	Stride        int // 每个关键帧的数值个数，矩阵动画为 16
+	Interpolation []string
+}
+
+type daeInputXml struct {
+	Semantic string `xml:"semantic,attr"`
+	Source   string `xml:"source,attr"`
+	Offset   int    `xml:"offset,attr"`
+}
+
+type daeSourceXml struct {
+	Id         string  `xml:"id,attr"`
+	FloatArray *string `xml:"float_array"`
+	NameArray  *string `xml:"Name_array"`
+	IdrefArray *string `xml:"IDREF_array"`
+	Accessor   struct {
+		Stride int `xml:"stride,attr"`
+	} `xml:"technique_common>accessor"`
+}
+
+type daeSkinXml struct {
+	Source          string         `xml:"source,attr"`
+	BindShapeMatrix string         `xml:"bind_shape_matrix"`
+	Sources         []daeSourceXml `xml:"source"`
+	Joints          struct {
+		Input []daeInputXml `xml:"input"`
+	} `xml:"joints"`
+	VertexWeights struct {
+		Input  []daeInputXml `xml:"input"`
+		VCount string        `xml:"vcount"`
+		V      string        `xml:"v"`
+	} `xml:"vertex_weights"`
+}
+
+type daeMorphXml struct {
+	Source  string         `xml:"source,attr"`
+	Method  string         `xml:"method,attr"`
+	Sources []daeSourceXml `xml:"source"`
+	Targets struct {
+		Input []daeInputXml `xml:"input"`
+	} `xml:"targets"`
+}
+
+type daeControllerXml struct {
+	Id    string       `xml:"id,attr"`
+	Skin  *daeSkinXml  `xml:"skin"`
+	Morph *daeMorphXml `xml:"morph"`
+}
+
+type daeAnimationXml struct {
+	Id       string         `xml:"id,attr"`
+	Name     string         `xml:"name,attr"`
+	Sources  []daeSourceXml `xml:"source"`
+	Samplers []struct {
+		Id    string        `xml:"id,attr"`
+		Input []daeInputXml `xml:"input"`
+	} `xml:"sampler"`
+	Channels []struct {
+		Source string `xml:"source,attr"`
+		Target string `xml:"target,attr"`
+	} `xml:"channel"`
+	Animations []*daeAnimationXml `xml:"animation"`
+}
+
+// daeController 一次 instance_controller 解析出的蒙皮和变形，蒙皮的源可以是变形控制器
+type daeController struct {
+	id       string
+	skin     *daeSkinXml
+	morph    *daeMorphXml
+	morphId  string
+	skeleton []string
+}
+
+func (cv *DaeToMst) indexControllers(doc *daeOrderDoc) {
+	cv.controllers = make(map[string]*daeControllerXml)
+	for _, lib := range doc.LibraryControllers {
+		for _, c := range lib.Controller {
+			cv.controllers[c.Id] = c
+		}
+	}
+	cv.animations = nil
+	for _, lib := range doc.LibraryAnimations {
+		cv.animations = append(cv.animations, lib.Animation...)
+	}
+}
+
+// resolveController 找到控制器最终引用的几何体
+func (cv *DaeToMst) resolveController(gi *daeGeometryInstance) (string, *daeController) {
+	c, ok := cv.controllers[gi.ctrlId]
+	if !ok {
+		return "", nil
+	}
+	ctrl := &daeController{id: c.Id, skeleton: gi.skeleton}
+	src := ""
+	switch {
+	case c.Skin != nil:
+		ctrl.skin = c.Skin
+		src = daeUriId(c.Skin.Source)
+		if m, ok := cv.controllers[src]; ok && m.Morph != nil {
+			ctrl.morph, ctrl.morphId = m.Morph, m.Id
+			src = daeUriId(m.Morph.Source)
+		}
+	case c.Morph != nil:
+		ctrl.morph, ctrl.morphId = c.Morph, c.Id
+		src = daeUriId(c.Morph.Source)
+	}
+	return src, ctrl
+}
+
+// bindShape 蒙皮的 bind_shape_matrix，没有蒙皮时为单位矩阵
+func (ctrl *daeController) bindShape() mat4d.T {
+	if ctrl == nil || ctrl.skin == nil {
+		return mat4d.Ident
+	}
+	return daeTransform{kind: "matrix", values: parseDaeFloats(ctrl.skin.BindShapeMatrix)}.matrix()
+}
+
+func daeUriId(uri string) string {
+	return strings.TrimPrefix(strings.TrimSpace(uri), "#")
+}
+
+func daeFindSource(srcs []daeSourceXml, uri string) *daeSourceXml {
+	id := daeUriId(uri)
+	for i := range srcs {
+		if srcs[i].Id == id {
+			return &srcs[i]
+		}
+	}
+	return nil
+}
+
+func (s *daeSourceXml) floats() []float64 {
+	if s == nil || s.FloatArray == nil {
+		return nil
+	}
+	return parseDaeFloats(*s.FloatArray)
+}
+
+func (s *daeSourceXml) names() []string {
+	switch {
+	case s == nil:
+		return nil
+	case s.NameArray != nil:
+		return strings.Fields(*s.NameArray)
+	case s.IdrefArray != nil:
+		return strings.Fields(*s.IdrefArray)
+	}
+	return nil
+}
+
+func daeInput(inputs []daeInputXml, semantic string) *daeInputXml {
+	for i := range inputs {
+		if inputs[i].Semantic == semantic {
+			return &inputs[i]
+		}
+	}
+	return nil
+}
+
+// convertController 在几何体转换完成后附加蒙皮和变形数据，mat 为节点顶点所在空间（含 bind_shape_matrix）
+func (cv *DaeToMst) convertController(ctrl *daeController, bd *daeMeshBuilder, nd *mst.MeshNode, geo *dae.Geometry, mat *mat4d.T) {
+	if ctrl.skin != nil {
+		if skin := cv.convertSkin(ctrl, bd, mat); skin != nil {
+			cv.Skins[nd] = skin
+		}
+	}
+	if ctrl.morph != nil {
+		if morph := cv.convertMorph(ctrl, bd, geo); morph != nil {
+			cv.Morphs[nd] = morph
+		}
+	}
+}
+
+func (cv *DaeToMst) convertSkin(ctrl *daeController, bd *daeMeshBuilder, mat *mat4d.T) *DaeSkin {
+	sk := ctrl.skin
+	jin := daeInput(sk.Joints.Input, "JOINT")
+	ibin := daeInput(sk.Joints.Input, "INV_BIND_MATRIX")
+	if jin == nil {
+		return nil
+	}
+	names := daeFindSource(sk.Sources, jin.Source).names()
+	var ibs []float64
+	if ibin != nil {
+		ibs = daeFindSource(sk.Sources, ibin.Source).floats()
+	}
+
+	// 顶点空间到 bind_shape 空间：(mat)⁻¹·BSM，这里 mat 已包含 BSM，矩阵奇异时不做变换
+	bsm := ctrl.bindShape()
+	toBind := mat4d.Ident
+	if math.Abs(mat.Determinant()) >= 1e-12 {
+		toBind = mat.Inverted()
+	}
+	toBind = mulMat4(bsm, toBind)
+
+	// remap 控制器中的关节序号到 Bones 下标，找不到的关节为 -1
+	skin := &DaeSkin{}
+	remap := make([]int, len(names))
+	for i, name := range names {
+		nd := cv.findJoint(name, ctrl.skeleton)
+		if nd == nil {
+			cv.Diagnostics.Warnf("joint %q of controller %q not found", name, ctrl.id)
+			remap[i] = -1
+			continue
+		}
+		remap[i] = len(skin.Bones)
+		skin.Bones = append(skin.Bones, cv.boneIndex(nd))
+		ib := mat4d.Ident
+		if len(ibs) >= (i+1)*16 {
+			ib = daeTransform{kind: "matrix", values: ibs[i*16 : (i+1)*16]}.matrix()
+		}
+		skin.InverseBind = append(skin.InverseBind, mulMat4(ib, toBind))
+	}
+
+	vw := sk.VertexWeights
+	jw := daeInput(vw.Input, "JOINT")
+	ww := daeInput(vw.Input, "WEIGHT")
+	if jw == nil || ww == nil {
+		return skin
+	}
+	weights := daeFindSource(sk.Sources, ww.Source).floats()
+	stride := 0
+	for _, in := range vw.Input {
+		if in.Offset+1 > stride {
+			stride = in.Offset + 1
+		}
+	}
+	vcount := parseDaeInts(vw.VCount)
+	v := parseDaeInts(vw.V)
+
+	type influence struct {
+		joint  int
+		weight float64
+	}
+	perPos := make([][DaeMaxInfluences]influence, len(vcount))
+	j := 0
+	for i, n := range vcount {
+		var infs []influence
+		for k := 0; k < n && j+stride <= len(v); k++ {
+			joint, wi := v[j+jw.Offset], v[j+ww.Offset]
+			j += stride
+			if joint < 0 || joint >= len(names) || remap[joint] < 0 || wi < 0 || wi >= len(weights) || weights[wi] <= 0 {
+				continue
+			}
+			infs = append(infs, influence{remap[joint], weights[wi]})
+		}
+		sort.SliceStable(infs, func(a, b int) bool { return infs[a].weight > infs[b].weight })
+		total := 0.0
+		for k := 0; k < len(infs) && k < DaeMaxInfluences; k++ {
+			total += infs[k].weight
+		}
+		for k := 0; k < len(infs) && k < DaeMaxInfluences; k++ {
+			perPos[i][k] = influence{infs[k].joint, infs[k].weight / total}
+		}
+	}
+
+	skin.Joints = make([][DaeMaxInfluences]uint16, len(bd.posIdx))
+	skin.Weights = make([][DaeMaxInfluences]float32, len(bd.posIdx))
+	for i, p := range bd.posIdx {
+		if p < 0 || p >= len(perPos) {
+			continue
+		}
+		for k, inf := range perPos[p] {
+			skin.Joints[i][k] = uint16(inf.joint)
+			skin.Weights[i][k] = float32(inf.weight)
+		}
+	}
+	return skin
+}
+
+// findJoint 优先在 skeleton 指定的子树中按 sid 查找，其次按 id，最后在整个文档中按 sid 查找
+func (cv *DaeToMst) findJoint(name string, skeleton []string) *dae.Node {
+	var find func(nd *dae.Node) *dae.Node
+	find = func(nd *dae.Node) *dae.Node {
+		if nd.Sid == name || string(nd.Id) == name {
+			return nd
+		}
+		for _, c := range nd.Node {
+			if r := find(c); r != nil {
+				return r
+			}
+		}
+		return nil
+	}
+	for _, root := range skeleton {
+		if nd, ok := cv.nodeMap[daeUriId(root)]; ok {
+			if r := find(nd); r != nil {
+				return r
+			}
+		}
+	}
+	if nd, ok := cv.nodeMap[name]; ok {
+		return nd
+	}
+	return cv.sidMap[name]
+}
+
+// boneIndex 返回节点在 Skeleton 中的索引，先加入其全部祖先
+func (cv *DaeToMst) boneIndex(nd *dae.Node) int {
+	if idx, ok := cv.bones[nd]; ok {
+		return idx
+	}
+	parent := -1
+	if p := cv.nodeParent[nd]; p != nil {
+		parent = cv.boneIndex(p)
+	}
+	local := cv.nodeMatrix(nd)
+	global, ok := cv.nodeWorld[nd]
+	if !ok {
+		global = *local
+	}
+	cv.bones[nd] = len(cv.Skeleton)
+	cv.Skeleton = append(cv.Skeleton, &DaeBone{
+		Id:     string(nd.Id),
+		Sid:    nd.Sid,
+		Name:   nd.Name,
+		Parent: parent,
+		Local:  *local,
+		Global: global,
+	})
+	return cv.bones[nd]
+}
+
+func (cv *DaeToMst) convertMorph(ctrl *daeController, bd *daeMeshBuilder, geo *dae.Geometry) *DaeMorph {
+	mp := ctrl.morph
+	tin := daeInput(mp.Targets.Input, "MORPH_TARGET")
+	win := daeInput(mp.Targets.Input, "MORPH_WEIGHT")
+	if tin == nil {
+		return nil
+	}
+	targets := daeFindSource(mp.Sources, tin.Source).names()
+	var weights []float64
+	if win != nil {
+		weights = daeFindSource(mp.Sources, win.Source).floats()
+	}
+	relative := strings.EqualFold(mp.Method, "RELATIVE")
+	base := daeMeshPositions(geo.Mesh)
+
+	morph := &DaeMorph{Id: ctrl.morphId, Method: mp.Method}
+	if morph.Method == "" {
+		morph.Method = "NORMALIZED"
+	}
+	for i, name := range targets {
+		tg, ok := cv.geometries[name]
+		if !ok || tg.Mesh == nil {
+			cv.Diagnostics.Warnf("morph target %q of controller %q not found", name, ctrl.morphId)
+			continue
+		}
+		tps := daeMeshPositions(tg.Mesh)
+		t := &DaeMorphTarget{Name: name, Offsets: make([]vec3.T, len(bd.posIdx))}
+		if i < len(weights) {
+			t.Weight = weights[i]
+		}
+		for k, p := range bd.posIdx {
+			if p < 0 || p >= len(tps) {
+				continue
+			}
+			d := tps[p]
+			if !relative && p < len(base) {
+				d = vec3d.Sub(&d, &base[p])
+			}
+			d = bd.mat.MulVec3W(&d, 0)
+			t.Offsets[k] = vec3.T{float32(d[0]), float32(d[1]), float32(d[2])}
+		}
+		morph.Targets = append(morph.Targets, t)
+	}
+	return morph
+}
+
+// daeMeshPositions 几何体 <vertices> 中 POSITION 输入的全部坐标
+func daeMeshPositions(mh *dae.Mesh) []vec3d.T {
+	b := &daeMeshBuilder{mh: mh, sources: make(map[string]*daeSourceData)}
+	for _, in := range mh.Vertices.Input {
+		if in.Semantic != "POSITION" {
+			continue
+		}
+		src := b.source(in.Source)
+		if src == nil {
+			return nil
+		}
+		var res []vec3d.T
+		for i := 0; ; i++ {
+			var v [3]float64
+			if !src.get(i, v[:]) {
+				return res
+			}
+			res = append(res, vec3d.T(v))
+		}
+	}
+	return nil
+}
+
+// convertAnimations 转换 library_animations，目标节点是蒙皮关节时记录其在 Skeleton 中的索引
+func (cv *DaeToMst) convertAnimations() []*DaeAnimation {
+	var res []*DaeAnimation
+	for _, a := range cv.animations {
+		anim := &DaeAnimation{Id: a.Id, Name: a.Name}
+		cv.collectChannels(a, anim)
+		if len(anim.Channels) > 0 {
+			res = append(res, anim)
+		}
+	}
+	return res
+}
+
+func (cv *DaeToMst) collectChannels(a *daeAnimationXml, anim *DaeAnimation) {
+	for _, ch := range a.Channels {
+		sid := daeUriId(ch.Source)
+		for _, smp := range a.Samplers {
+			if smp.Id != sid {
+				continue
+			}
+			c := &DaeAnimationChannel{Target: ch.Target, Bone: -1}
+			c.Node, c.Member = ch.Target, ""
+			if i := strings.Index(ch.Target, "/"); i >= 0 {
+				c.Node, c.Member = ch.Target[:i], ch.Target[i+1:]
+			}
+			if nd, ok := cv.nodeMap[c.Node]; ok {
+				if idx, ok := cv.bones[nd]; ok {
+					c.Bone = idx
+				}
+			}
+			if in := daeInput(smp.Input, "INPUT"); in != nil {
+				c.Times = daeFindSource(a.Sources, in.Source).floats()
+			}
+			if in := daeInput(smp.Input, "OUTPUT"); in != nil {
+				src := daeFindSource(a.Sources, in.Source)
+				c.Values = src.floats()
+				if src != nil {
+					c.Stride = src.Accessor.Stride
+				}
+			}
+			if c.Stride == 0 {
+				c.Stride = 1
+			}
+			if in := daeInput(smp.Input, "INTERPOLATION"); in != nil {
+				c.Interpolation = daeFindSource(a.Sources, in.Source).names()
+			}
+			anim.Channels = append(anim.Channels, c)
+		}
+	}
+	for _, sub := range a.Animations {
+		cv.collectChannels(sub, anim)
+	}
+}
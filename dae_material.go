@@ -0,0 +1,526 @@
+package asset3d
+
+import (
+	"image"
+	"image/color"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	dae "github.com/flywave/go-collada"
+	mst "github.com/flywave/go-mst"
+)
+
+// daeBumpNormalScale 高度图生成法线贴图时的强度
+const daeBumpNormalScale = 2.0
+
+// daeImageXml 兼容 COLLADA 1.4 的 <init_from>路径</init_from> 和 1.5 的 <init_from><ref>路径</ref></init_from>
+type daeImageXml struct {
+	Id       string `xml:"id,attr"`
+	InitFrom *struct {
+		Value string `xml:",chardata"`
+		Ref   string `xml:"ref"`
+	} `xml:"init_from"`
+}
+
+func (img *daeImageXml) path() string {
+	if img.InitFrom == nil {
+		return ""
+	}
+	if p := strings.TrimSpace(img.InitFrom.Ref); p != "" {
+		return p
+	}
+	return strings.TrimSpace(img.InitFrom.Value)
+}
+
+type daeParamRefXml struct {
+	Ref string `xml:"ref,attr"`
+}
+
+type daeTextureXml struct {
+	Texture  string `xml:"texture,attr"`
+	TexCoord string `xml:"texcoord,attr"`
+}
+
+type daeColorOrTextureXml struct {
+	Opaque  string          `xml:"opaque,attr"`
+	Color   *string         `xml:"color"`
+	Param   *daeParamRefXml `xml:"param"`
+	Texture *daeTextureXml  `xml:"texture"`
+}
+
+type daeFloatOrParamXml struct {
+	Float *string         `xml:"float"`
+	Param *daeParamRefXml `xml:"param"`
+}
+
+// daeShadingXml constant、lambert、phong 和 blinn 共用的着色参数，各模型只使用其中一部分
+type daeShadingXml struct {
+	Emission          *daeColorOrTextureXml `xml:"emission"`
+	Ambient           *daeColorOrTextureXml `xml:"ambient"`
+	Diffuse           *daeColorOrTextureXml `xml:"diffuse"`
+	Specular          *daeColorOrTextureXml `xml:"specular"`
+	Shininess         *daeFloatOrParamXml   `xml:"shininess"`
+	Reflective        *daeColorOrTextureXml `xml:"reflective"`
+	Reflectivity      *daeFloatOrParamXml   `xml:"reflectivity"`
+	Transparent       *daeColorOrTextureXml `xml:"transparent"`
+	Transparency      *daeFloatOrParamXml   `xml:"transparency"`
+	IndexOfRefraction *daeFloatOrParamXml   `xml:"index_of_refraction"`
+}
+
+type daeNewparamXml struct {
+	Sid      string  `xml:"sid,attr"`
+	Semantic string  `xml:"semantic"`
+	Float    *string `xml:"float"`
+	Float3   *string `xml:"float3"`
+	Float4   *string `xml:"float4"`
+	Surface  *struct {
+		InitFrom string `xml:"init_from"`
+	} `xml:"surface"`
+	Sampler2D *struct {
+		Source        string `xml:"source"`
+		WrapS         string `xml:"wrap_s"`
+		WrapT         string `xml:"wrap_t"`
+		InstanceImage *struct {
+			Url string `xml:"url,attr"`
+		} `xml:"instance_image"`
+	} `xml:"sampler2D"`
+}
+
+// daeExtraXml 各工具写在 <extra> 中的凹凸/法线贴图，例如 FCOLLADA 和 OpenCOLLADA 的 <bump>
+type daeExtraXml struct {
+	Technique []struct {
+		Profile string `xml:"profile,attr"`
+		Bump    []struct {
+			BumpType string         `xml:"bumptype,attr"`
+			Texture  *daeTextureXml `xml:"texture"`
+		} `xml:"bump"`
+	} `xml:"technique"`
+}
+
+type daeEffectXml struct {
+	Id            string            `xml:"id,attr"`
+	Images        []*daeImageXml    `xml:"image"`
+	Newparam      []*daeNewparamXml `xml:"newparam"`
+	ProfileCommon *struct {
+		Images    []*daeImageXml    `xml:"image"`
+		Newparam  []*daeNewparamXml `xml:"newparam"`
+		Technique *struct {
+			Constant *daeShadingXml `xml:"constant"`
+			Lambert  *daeShadingXml `xml:"lambert"`
+			Phong    *daeShadingXml `xml:"phong"`
+			Blinn    *daeShadingXml `xml:"blinn"`
+			Extra    []daeExtraXml  `xml:"extra"`
+		} `xml:"technique"`
+		Extra []daeExtraXml `xml:"extra"`
+	} `xml:"profile_COMMON"`
+	Extra []daeExtraXml `xml:"extra"`
+}
+
+// daeEffect 解析后的 profile_COMMON 效果，params 合并了效果级和 profile 级的 newparam
+type daeEffect struct {
+	model   string
+	shading *daeShadingXml
+	params  map[string]*daeNewparamXml
+	extras  []daeExtraXml
+}
+
+func (cv *DaeToMst) indexEffects(doc *daeOrderDoc) {
+	cv.images = make(map[string]*daeImageXml)
+	addImages := func(imgs []*daeImageXml) {
+		for _, img := range imgs {
+			if _, ok := cv.images[img.Id]; !ok {
+				cv.images[img.Id] = img
+			}
+		}
+	}
+	for _, lib := range doc.LibraryImages {
+		addImages(lib.Image)
+	}
+
+	cv.effects = make(map[string]*daeEffect)
+	for _, lib := range doc.LibraryEffects {
+		for _, e := range lib.Effect {
+			addImages(e.Images)
+			ef := &daeEffect{params: make(map[string]*daeNewparamXml), extras: e.Extra}
+			for _, p := range e.Newparam {
+				ef.params[p.Sid] = p
+			}
+			if pc := e.ProfileCommon; pc != nil {
+				addImages(pc.Images)
+				for _, p := range pc.Newparam {
+					ef.params[p.Sid] = p
+				}
+				ef.extras = append(ef.extras, pc.Extra...)
+				if t := pc.Technique; t != nil {
+					ef.extras = append(ef.extras, t.Extra...)
+					switch {
+					case t.Phong != nil:
+						ef.model, ef.shading = "phong", t.Phong
+					case t.Blinn != nil:
+						ef.model, ef.shading = "blinn", t.Blinn
+					case t.Lambert != nil:
+						ef.model, ef.shading = "lambert", t.Lambert
+					case t.Constant != nil:
+						ef.model, ef.shading = "constant", t.Constant
+					}
+				}
+			}
+			cv.effects[e.Id] = ef
+		}
+	}
+}
+
+// color 解析颜色或引用 float3/float4 参数的颜色，alpha 缺省为 1
+func (ef *daeEffect) color(ct *daeColorOrTextureXml) ([4]float64, bool) {
+	var vs []float64
+	switch {
+	case ct == nil:
+		return [4]float64{}, false
+	case ct.Color != nil:
+		vs = parseDaeFloats(*ct.Color)
+	case ct.Param != nil:
+		if p, ok := ef.params[ct.Param.Ref]; ok {
+			if p.Float4 != nil {
+				vs = parseDaeFloats(*p.Float4)
+			} else if p.Float3 != nil {
+				vs = parseDaeFloats(*p.Float3)
+			}
+		}
+	}
+	if len(vs) < 3 {
+		return [4]float64{}, false
+	}
+	c := [4]float64{vs[0], vs[1], vs[2], 1}
+	if len(vs) > 3 {
+		c[3] = vs[3]
+	}
+	return c, true
+}
+
+func (ef *daeEffect) float(fp *daeFloatOrParamXml, def float64) (float64, bool) {
+	var s *string
+	switch {
+	case fp == nil:
+		return def, false
+	case fp.Float != nil:
+		s = fp.Float
+	case fp.Param != nil:
+		if p, ok := ef.params[fp.Param.Ref]; ok {
+			s = p.Float
+		}
+	}
+	if s == nil {
+		return def, false
+	}
+	vs := parseDaeFloats(*s)
+	if len(vs) == 0 {
+		return def, false
+	}
+	return vs[0], true
+}
+
+// imageId 沿 sampler2D→surface→image 的 newparam 链找到图像，
+// 兼容 1.5 的 instance_image 和直接引用图像 id 的导出器
+func (ef *daeEffect) imageId(tex *daeTextureXml) (string, bool) {
+	if tex == nil {
+		return "", false
+	}
+	repeated := true
+	p, ok := ef.params[tex.Texture]
+	if !ok || p.Sampler2D == nil {
+		return tex.Texture, repeated
+	}
+	smp := p.Sampler2D
+	for _, w := range []string{smp.WrapS, smp.WrapT} {
+		switch strings.TrimSpace(w) {
+		case "CLAMP", "BORDER", "NONE":
+			repeated = false
+		}
+	}
+	if smp.InstanceImage != nil {
+		url := dae.Uri(smp.InstanceImage.Url)
+		return url.GetId(), repeated
+	}
+	src := strings.TrimSpace(smp.Source)
+	if sp, ok := ef.params[src]; ok && sp.Surface != nil {
+		return strings.TrimSpace(sp.Surface.InitFrom), repeated
+	}
+	return src, repeated
+}
+
+// bump 各 profile 的 <extra> 中第一个带纹理的 <bump>
+func (ef *daeEffect) bump() (*daeTextureXml, bool) {
+	for _, ex := range ef.extras {
+		for _, t := range ex.Technique {
+			for _, b := range t.Bump {
+				if b.Texture != nil {
+					return b.Texture, strings.EqualFold(b.BumpType, "HEIGHTFIELD")
+				}
+			}
+		}
+	}
+	return nil, false
+}
+
+// opacity 按 opaque 模式合成 transparent 与 transparency，透明纹理的颜色未知时按白色处理
+func (ef *daeEffect) opacity() (float64, string) {
+	sh := ef.shading
+	tf, hasTf := ef.float(sh.Transparency, 1)
+	if sh.Transparent == nil && !hasTf {
+		return 1, ""
+	}
+	mode := "A_ONE"
+	c := [4]float64{1, 1, 1, 1}
+	if sh.Transparent != nil {
+		if sh.Transparent.Opaque != "" {
+			mode = sh.Transparent.Opaque
+		}
+		if cl, ok := ef.color(sh.Transparent); ok {
+			c = cl
+		}
+	}
+	lum := (c[0] + c[1] + c[2]) / 3
+	switch mode {
+	case "A_ZERO":
+		return 1 - c[3]*tf, mode
+	case "RGB_ZERO":
+		return 1 - lum*tf, mode
+	case "RGB_ONE":
+		return lum * tf, mode
+	}
+	return c[3] * tf, mode
+}
+
+func (cv *DaeToMst) convertMtl(mesh *mst.Mesh, mtl *dae.Material, collada *dae.Collada) {
+	baseMtl := &mst.BaseMaterial{Color: [3]byte{255, 255, 255}}
+	if mtl == nil {
+		mesh.Materials = append(mesh.Materials, baseMtl)
+		return
+	}
+	ef, ok := cv.effects[mtl.InstanceEffect.Url.GetId()]
+	if !ok {
+		mesh.Materials = append(mesh.Materials, baseMtl)
+		return
+	}
+	if ef.shading == nil {
+		for _, p := range ef.params {
+			if p.Semantic != "DIFFUSECOLOR" {
+				continue
+			}
+			if c, ok := ef.color(&daeColorOrTextureXml{Param: &daeParamRefXml{Ref: p.Sid}}); ok {
+				baseMtl.Color = daeColorBytes(c)
+				baseMtl.Transparency = daeTransparency(c[3])
+			}
+		}
+		mesh.Materials = append(mesh.Materials, baseMtl)
+		return
+	}
+
+	sh := ef.shading
+	opacity, mode := ef.opacity()
+	transparency := daeTransparency(opacity)
+
+	emission, _ := ef.color(sh.Emission)
+	if ef.model == "constant" {
+		tmt := &mst.TextureMaterial{BaseMaterial: mst.BaseMaterial{Color: daeColorBytes(emission), Transparency: transparency}}
+		tmt.Texture = cv.colorTexture(ef, sh.Emission, sh.Transparent, mode, tmt.Color)
+		mesh.Materials = append(mesh.Materials, tmt)
+		return
+	}
+
+	diffuse, ok := ef.color(sh.Diffuse)
+	if !ok {
+		diffuse = [4]float64{1, 1, 1, 1}
+	}
+	ambient, _ := ef.color(sh.Ambient)
+	lambert := mst.LambertMaterial{
+		TextureMaterial: mst.TextureMaterial{
+			BaseMaterial: mst.BaseMaterial{Color: daeColorBytes(diffuse), Transparency: transparency},
+		},
+		Ambient:  daeColorBytes(ambient),
+		Diffuse:  daeColorBytes(diffuse),
+		Emissive: daeColorBytes(emission),
+	}
+	lambert.Texture = cv.colorTexture(ef, sh.Diffuse, sh.Transparent, mode, lambert.Color)
+	lambert.Normal = cv.bumpTexture(ef)
+	if ef.model == "lambert" {
+		mesh.Materials = append(mesh.Materials, &lambert)
+		return
+	}
+
+	specular, _ := ef.color(sh.Specular)
+	shininess, _ := ef.float(sh.Shininess, 0)
+	mesh.Materials = append(mesh.Materials, &mst.PhongMaterial{
+		LambertMaterial: lambert,
+		Specular:        daeColorBytes(specular),
+		Shininess:       float32(shininess),
+	})
+}
+
+// colorTexture 颜色通道的纹理，透明纹理与其不同或需要换算时合并到 alpha 通道
+func (cv *DaeToMst) colorTexture(ef *daeEffect, base, transparent *daeColorOrTextureXml, mode string, cl [3]byte) *mst.Texture {
+	var baseId, maskId string
+	repeated := false
+	if base != nil && base.Texture != nil {
+		baseId, repeated = ef.imageId(base.Texture)
+	}
+	if transparent != nil && transparent.Texture != nil {
+		var rep bool
+		maskId, rep = ef.imageId(transparent.Texture)
+		repeated = repeated || rep
+		if maskId == baseId && (mode == "" || mode == "A_ONE") {
+			maskId = ""
+		}
+	}
+	if maskId == "" {
+		if baseId == "" {
+			return nil
+		}
+		return cv.cachedTexture(baseId, repeated, func() image.Image {
+			return cv.image(baseId)
+		})
+	}
+	return cv.cachedTexture(baseId+"|"+mode+":"+maskId, repeated, func() image.Image {
+		mask := cv.image(maskId)
+		var img image.Image
+		if baseId != "" {
+			img = cv.image(baseId)
+		}
+		if mask == nil {
+			return img
+		}
+		return imageWithAlpha(img, cl, daeTransparencyMask(mask, mode))
+	})
+}
+
+// bumpTexture 法线贴图，bumptype 为 HEIGHTFIELD 时由高度生成法线
+func (cv *DaeToMst) bumpTexture(ef *daeEffect) *mst.Texture {
+	tex, height := ef.bump()
+	if tex == nil {
+		return nil
+	}
+	id, repeated := ef.imageId(tex)
+	if !height {
+		return cv.cachedTexture(id, repeated, func() image.Image {
+			return cv.image(id)
+		})
+	}
+	return cv.cachedTexture("bump:"+id, repeated, func() image.Image {
+		img := cv.image(id)
+		if img == nil {
+			return nil
+		}
+		return heightToNormal(img, daeBumpNormalScale)
+	})
+}
+
+func (cv *DaeToMst) cachedTexture(key string, repeated bool, load func() image.Image) *mst.Texture {
+	if tex, ok := cv.texMap[key]; ok {
+		if tex != nil {
+			tex.Repeated = tex.Repeated || repeated
+		}
+		return tex
+	}
+	var tex *mst.Texture
+	if img := load(); img != nil {
+		tex = imageToTex(img, cv.texId)
+		tex.Repeated = repeated
+		cv.texId++
+	}
+	cv.texMap[key] = tex
+	return tex
+}
+
+// image 按图像 id 加载图片，依次尝试记录的路径和 DAE 所在目录下的同名文件
+func (cv *DaeToMst) image(id string) image.Image {
+	if img, ok := cv.imgCache[id]; ok {
+		return img
+	}
+	var res image.Image
+	if img, ok := cv.images[id]; ok {
+		for _, p := range cv.imagePaths(img.path()) {
+			if _, err := os.Stat(p); err != nil {
+				continue
+			}
+			decoded, err := loadImage(p)
+			if err != nil {
+				cv.Diagnostics.Warnf("decode image %q: %v", p, err)
+				break
+			}
+			res = decoded
+			break
+		}
+		if res == nil {
+			cv.Diagnostics.Warnf("image %q not found", img.path())
+		}
+	} else {
+		cv.Diagnostics.Warnf("image %q is not defined", id)
+	}
+	cv.imgCache[id] = res
+	return res
+}
+
+func (cv *DaeToMst) imagePaths(uri string) []string {
+	if uri == "" {
+		return nil
+	}
+	p := uri
+	if strings.HasPrefix(p, "file://") {
+		p = strings.TrimPrefix(p, "file://")
+		if len(p) > 2 && p[0] == '/' && p[2] == ':' {
+			p = p[1:]
+		}
+	}
+	if up, err := url.PathUnescape(p); err == nil {
+		p = up
+	}
+	p = strings.ReplaceAll(p, "\\", "/")
+	var res []string
+	if filepath.IsAbs(p) {
+		res = append(res, p)
+	} else {
+		res = append(res, filepath.Join(cv.baseDir, p))
+	}
+	res = append(res, filepath.Join(cv.baseDir, filepath.Base(p)))
+	return res
+}
+
+// daeTransparencyMask 把透明纹理换算成 imageWithAlpha 使用的不透明度灰度图
+func daeTransparencyMask(img image.Image, mode string) image.Image {
+	bd := img.Bounds()
+	out := image.NewGray(image.Rect(0, 0, bd.Dx(), bd.Dy()))
+	for y := 0; y < bd.Dy(); y++ {
+		for x := 0; x < bd.Dx(); x++ {
+			c := color.NRGBAModel.Convert(img.At(bd.Min.X+x, bd.Min.Y+y)).(color.NRGBA)
+			lum := (uint32(c.R) + uint32(c.G) + uint32(c.B)) / 3
+			var v uint32
+			switch mode {
+			case "A_ZERO":
+				v = 255 - uint32(c.A)
+			case "RGB_ZERO":
+				v = 255 - lum
+			case "RGB_ONE":
+				v = lum
+			default:
+				v = uint32(c.A)
+			}
+			out.SetGray(x, y, color.Gray{Y: byte(v)})
+		}
+	}
+	return out
+}
+
+func daeColorBytes(c [4]float64) [3]byte {
+	return [3]byte{byte(clampUnit(c[0])*255 + 0.5), byte(clampUnit(c[1])*255 + 0.5), byte(clampUnit(c[2])*255 + 0.5)}
+}
+
+// daeTransparency 不透明度转换为 MST 的透明度，完全透明按不透明处理
+func daeTransparency(opacity float64) float32 {
+	t := 1 - opacity
+	if t < 0 || t >= 1 {
+		t = 0
+	}
+	return float32(t)
+}
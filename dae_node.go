@@ -0,0 +1,472 @@
+package asset3d
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	dae "github.com/flywave/go-collada"
+	mat4d "github.com/flywave/go3d/float64/mat4"
+	vec3d "github.com/flywave/go3d/float64/vec3"
+	vec4d "github.com/flywave/go3d/float64/vec4"
+)
+
+// daeGeometryInstance 场景树中一次 instance_geometry 或 instance_controller 引用及其世界矩阵
+type daeGeometryInstance struct {
+	geoId    string
+	ctrlId   string
+	skeleton []string
+	mat      mat4d.T
+}
+
+// key 相同的实例共享同一份网格
+func (gi *daeGeometryInstance) key() string {
+	if gi.ctrlId != "" {
+		return "controller:" + gi.ctrlId
+	}
+	return gi.geoId
+}
+
+// daeLibraryNodes go-collada 没有解析 library_nodes，这里单独解码
+type daeLibraryNodes struct {
+	LibraryNodes []struct {
+		Node []*dae.Node `xml:"node"`
+	} `xml:"library_nodes"`
+}
+
+// daeTokens 重放已读取的 XML 记号
+type daeTokens struct {
+	toks []xml.Token
+	pos  int
+}
+
+func (r *daeTokens) Token() (xml.Token, error) {
+	if r.pos >= len(r.toks) {
+		return nil, io.EOF
+	}
+	t := r.toks[r.pos]
+	r.pos++
+	return t, nil
+}
+
+// loadDaeDocument 只对文档做一次词法解析：COLLADA 下的每个顶层元素读成记号后，
+// 依次解码到 go-collada 的文档、补充顺序信息的 daeOrderDoc 和 library_nodes 中，
+// 切片字段在多次解码之间累加，内存中同时只保留一个顶层元素的记号
+func loadDaeDocument(r io.Reader) (*dae.Collada, *daeOrderDoc, []*dae.Node, error) {
+	collada, doc, lib := &dae.Collada{}, &daeOrderDoc{}, &daeLibraryNodes{}
+	d := xml.NewDecoder(r)
+	var root *xml.StartElement
+	var toks []xml.Token
+	depth := 0
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		// depth 为相对根元素的深度，顶层元素为 1
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if root == nil {
+				st := t.Copy()
+				root = &st
+				continue
+			}
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				continue
+			}
+			depth--
+		default:
+			if depth == 0 {
+				// 根元素之外和根元素直接包含的文本、注释
+				continue
+			}
+		}
+		toks = append(toks, xml.CopyToken(tok))
+		if depth > 0 {
+			continue
+		}
+
+		// 一个顶层元素结束，包上根元素后分别解码
+		name := toks[0].(xml.StartElement).Name.Local
+		frag := append([]xml.Token{*root}, toks...)
+		frag = append(frag, root.End())
+		targets := []interface{}{collada, doc}
+		if name == "library_nodes" {
+			targets = append(targets, lib)
+		}
+		for _, v := range targets {
+			if err := xml.NewTokenDecoder(&daeTokens{toks: frag}).Decode(v); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		toks = toks[:0]
+	}
+	if root == nil {
+		return nil, nil, nil, fmt.Errorf("not a COLLADA document")
+	}
+	var nodes []*dae.Node
+	for _, l := range lib.LibraryNodes {
+		nodes = append(nodes, l.Node...)
+	}
+	return collada, doc, nodes, nil
+}
+
+// indexNodes 递归记录所有带 id 和 sid 的节点，供 instance_node 和蒙皮关节查找
+func (cv *DaeToMst) indexNodes(nodes []*dae.Node) {
+	for _, nd := range nodes {
+		if nd.Id != "" {
+			if _, ok := cv.nodeMap[string(nd.Id)]; !ok {
+				cv.nodeMap[string(nd.Id)] = nd
+			}
+		}
+		if nd.Sid != "" {
+			if _, ok := cv.sidMap[nd.Sid]; !ok {
+				cv.sidMap[nd.Sid] = nd
+			}
+		}
+		cv.indexNodes(nd.Node)
+	}
+}
+
+// activeScenes 返回 scene 中实例化的视觉场景，未指定时返回全部
+func (cv *DaeToMst) activeScenes(collada *dae.Collada) []*dae.VisualScene {
+	var all []*dae.VisualScene
+	for _, sce := range collada.LibraryVisualScenes {
+		all = append(all, sce.VisualScene...)
+	}
+	if collada.Scene == nil || collada.Scene.InstanceVisualScene == nil {
+		return all
+	}
+	id := collada.Scene.InstanceVisualScene.Url.GetId()
+	for _, vs := range all {
+		if string(vs.Id) == id {
+			return []*dae.VisualScene{vs}
+		}
+	}
+	return all
+}
+
+// collectNode 自根向叶合成矩阵，收集节点及其子节点、引用节点下的全部几何实例，
+// 同时记录节点首次出现时的父节点和世界矩阵，供蒙皮关节使用
+func (cv *DaeToMst) collectNode(nd, parentNd *dae.Node, parent mat4d.T, visiting map[*dae.Node]bool, res []*daeGeometryInstance) []*daeGeometryInstance {
+	if visiting[nd] {
+		return res
+	}
+	visiting[nd] = true
+	defer delete(visiting, nd)
+
+	var mat mat4d.T
+	mat.AssignMul(&parent, cv.nodeMatrix(nd))
+	if _, ok := cv.nodeWorld[nd]; !ok {
+		cv.nodeWorld[nd] = mat
+		cv.nodeParent[nd] = parentNd
+	}
+	for _, g := range nd.InstanceGeometry {
+		res = append(res, &daeGeometryInstance{geoId: g.Url.GetId(), mat: mat})
+	}
+	for _, c := range nd.InstanceController {
+		res = append(res, &daeGeometryInstance{ctrlId: c.Url.GetId(), skeleton: cv.skeletons[c], mat: mat})
+	}
+	for _, child := range nd.Node {
+		res = cv.collectNode(child, nd, mat, visiting, res)
+	}
+	for _, in := range nd.InstanceNode {
+		if ref, ok := cv.nodeMap[in.Url.GetId()]; ok {
+			res = cv.collectNode(ref, nd, mat, visiting, res)
+		}
+	}
+	return res
+}
+
+// daeTransform 节点上的一个变换元素，values 为元素内的全部浮点数
+type daeTransform struct {
+	kind   string
+	values []float64
+}
+
+// daeXformNode 仅用于按文档顺序读取节点的变换元素
+type daeXformNode struct {
+	Elems              []daeXformElem  `xml:",any"`
+	Node               []*daeXformNode `xml:"node"`
+	InstanceController []struct {
+		Skeleton []string `xml:"skeleton"`
+	} `xml:"instance_controller"`
+}
+
+type daeXformElem struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+// daeStripsXml go-collada 的 trifans/tristrips 只保留最后一个 <p>，这里收集全部条带
+type daeStripsXml struct {
+	P []string `xml:"p"`
+}
+
+// daeOrderDoc 补充 go-collada 丢失的元素顺序和重复元素，以及它没有解析的图像、着色模型、控制器和动画
+type daeOrderDoc struct {
+	LibraryControllers []struct {
+		Controller []*daeControllerXml `xml:"controller"`
+	} `xml:"library_controllers"`
+	LibraryAnimations []struct {
+		Animation []*daeAnimationXml `xml:"animation"`
+	} `xml:"library_animations"`
+	LibraryImages []struct {
+		Image []*daeImageXml `xml:"image"`
+	} `xml:"library_images"`
+	LibraryEffects []struct {
+		Effect []*daeEffectXml `xml:"effect"`
+	} `xml:"library_effects"`
+	LibraryNodes []struct {
+		Node []*daeXformNode `xml:"node"`
+	} `xml:"library_nodes"`
+	LibraryVisualScenes []struct {
+		VisualScene []struct {
+			Node []*daeXformNode `xml:"node"`
+		} `xml:"visual_scene"`
+	} `xml:"library_visual_scenes"`
+	LibraryGeometries []struct {
+		Geometry []struct {
+			Mesh *struct {
+				Trifans   []daeStripsXml `xml:"trifans"`
+				Tristrips []daeStripsXml `xml:"tristrips"`
+			} `xml:"mesh"`
+		} `xml:"geometry"`
+	} `xml:"library_geometries"`
+}
+
+// loadOrderedElements go-collada 按类型分组存放变换元素，丢失了先后顺序，
+// 条带和扇形也只保留一个 <p>，这里按相同的结构把 daeOrderDoc 与 go-collada 的对象一一对应
+func (cv *DaeToMst) loadOrderedElements(doc *daeOrderDoc, collada *dae.Collada, libNodes []*dae.Node) {
+	cv.xforms = make(map[*dae.Node][]daeTransform)
+	cv.skeletons = make(map[*dae.InstanceController][]string)
+	for i, sce := range collada.LibraryVisualScenes {
+		if i >= len(doc.LibraryVisualScenes) {
+			break
+		}
+		for j, vs := range sce.VisualScene {
+			if j >= len(doc.LibraryVisualScenes[i].VisualScene) {
+				break
+			}
+			cv.indexTransforms(vs.Node, doc.LibraryVisualScenes[i].VisualScene[j].Node)
+		}
+	}
+	var xlib []*daeXformNode
+	for _, l := range doc.LibraryNodes {
+		xlib = append(xlib, l.Node...)
+	}
+	cv.indexTransforms(libNodes, xlib)
+
+	cv.indexEffects(doc)
+	cv.indexControllers(doc)
+
+	cv.strips = make(map[dae.Trig][]string)
+	for i, lib := range collada.LibraryGeometries {
+		if i >= len(doc.LibraryGeometries) {
+			break
+		}
+		for j, geo := range lib.Geometry {
+			if j >= len(doc.LibraryGeometries[i].Geometry) {
+				break
+			}
+			xmh := doc.LibraryGeometries[i].Geometry[j].Mesh
+			if geo.Mesh == nil || xmh == nil {
+				continue
+			}
+			for k, t := range geo.Mesh.Trifans {
+				if k < len(xmh.Trifans) {
+					cv.strips[t] = xmh.Trifans[k].P
+				}
+			}
+			for k, t := range geo.Mesh.Tristrips {
+				if k < len(xmh.Tristrips) {
+					cv.strips[t] = xmh.Tristrips[k].P
+				}
+			}
+		}
+	}
+}
+
+func (cv *DaeToMst) indexTransforms(nodes []*dae.Node, xnodes []*daeXformNode) {
+	for i, nd := range nodes {
+		if i >= len(xnodes) {
+			return
+		}
+		var ts []daeTransform
+		for _, e := range xnodes[i].Elems {
+			switch e.XMLName.Local {
+			case "matrix", "translate", "rotate", "scale", "lookat", "skew":
+				ts = append(ts, daeTransform{kind: e.XMLName.Local, values: parseDaeFloats(e.Value)})
+			}
+		}
+		cv.xforms[nd] = ts
+		for k, c := range nd.InstanceController {
+			if k < len(xnodes[i].InstanceController) {
+				cv.skeletons[c] = xnodes[i].InstanceController[k].Skeleton
+			}
+		}
+		cv.indexTransforms(nd.Node, xnodes[i].Node)
+	}
+}
+
+// nodeTransforms 返回节点按文档顺序排列的变换元素，
+// 没有顺序信息时按 lookat、matrix、translate、rotate、scale、skew 的常见顺序组合
+func (cv *DaeToMst) nodeTransforms(nd *dae.Node) []daeTransform {
+	if ts, ok := cv.xforms[nd]; ok {
+		return ts
+	}
+	var ts []daeTransform
+	for _, t := range nd.Lookat {
+		ts = append(ts, daeTransform{kind: "lookat", values: parseDaeFloats(t.V)})
+	}
+	for _, t := range nd.Matrix {
+		ts = append(ts, daeTransform{kind: "matrix", values: parseDaeFloats(t.V)})
+	}
+	for _, t := range nd.Translate {
+		ts = append(ts, daeTransform{kind: "translate", values: parseDaeFloats(t.V)})
+	}
+	for _, t := range nd.Rotate {
+		ts = append(ts, daeTransform{kind: "rotate", values: parseDaeFloats(t.V)})
+	}
+	for _, t := range nd.Scale {
+		ts = append(ts, daeTransform{kind: "scale", values: parseDaeFloats(t.V)})
+	}
+	for _, t := range nd.Skew {
+		ts = append(ts, daeTransform{kind: "skew", values: parseDaeFloats(t.V)})
+	}
+	return ts
+}
+
+// nodeMatrix 按 COLLADA 规范将变换元素依次右乘，得到节点的局部矩阵
+func (cv *DaeToMst) nodeMatrix(nd *dae.Node) *mat4d.T {
+	mat := mat4d.Ident
+	for _, t := range cv.nodeTransforms(nd) {
+		prev := mat
+		m := t.matrix()
+		mat.AssignMul(&prev, &m)
+	}
+	return &mat
+}
+
+func (t daeTransform) matrix() mat4d.T {
+	v := t.values
+	switch t.kind {
+	case "matrix":
+		if len(v) < 16 {
+			break
+		}
+		var ay [16]float64
+		copy(ay[:], v)
+		return *arryToMat(ay).Transpose()
+	case "translate":
+		if len(v) < 3 {
+			break
+		}
+		m := mat4d.Ident
+		m.SetTranslation(&vec3d.T{v[0], v[1], v[2]})
+		return m
+	case "rotate":
+		if len(v) < 4 {
+			break
+		}
+		return daeRotation(vec3d.T{v[0], v[1], v[2]}, v[3]*math.Pi/180)
+	case "scale":
+		if len(v) < 3 {
+			break
+		}
+		m := mat4d.Ident
+		m[0][0], m[1][1], m[2][2] = v[0], v[1], v[2]
+		return m
+	case "lookat":
+		if len(v) < 9 {
+			break
+		}
+		return daeLookat(vec3d.T{v[0], v[1], v[2]}, vec3d.T{v[3], v[4], v[5]}, vec3d.T{v[6], v[7], v[8]})
+	case "skew":
+		if len(v) < 7 {
+			break
+		}
+		return daeSkew(v[0]*math.Pi/180, vec3d.T{v[1], v[2], v[3]}, vec3d.T{v[4], v[5], v[6]})
+	}
+	return mat4d.Ident
+}
+
+// daeRotation 绕任意轴旋转 angle 弧度
+func daeRotation(axis vec3d.T, angle float64) mat4d.T {
+	if axis.Length() == 0 {
+		return mat4d.Ident
+	}
+	axis.Normalize()
+	x, y, z := axis[0], axis[1], axis[2]
+	c, s := math.Cos(angle), math.Sin(angle)
+	t := 1 - c
+	m := mat4d.Ident
+	m[0][0], m[0][1], m[0][2] = t*x*x+c, t*x*y+s*z, t*x*z-s*y
+	m[1][0], m[1][1], m[1][2] = t*x*y-s*z, t*y*y+c, t*y*z+s*x
+	m[2][0], m[2][1], m[2][2] = t*x*z+s*y, t*y*z-s*x, t*z*z+c
+	return m
+}
+
+// daeLookat 把位于 eye、朝向 interest 的对象放置到父空间，局部 -Z 指向目标点
+func daeLookat(eye, interest, up vec3d.T) mat4d.T {
+	z := vec3d.Sub(&eye, &interest)
+	if z.Length() == 0 {
+		return mat4d.Ident
+	}
+	z.Normalize()
+	x := vec3d.Cross(&up, &z)
+	if x.Length() == 0 {
+		return mat4d.Ident
+	}
+	x.Normalize()
+	y := vec3d.Cross(&z, &x)
+	m := mat4d.Ident
+	m[0] = vec4d.T{x[0], x[1], x[2], 0}
+	m[1] = vec4d.T{y[0], y[1], y[2], 0}
+	m[2] = vec4d.T{z[0], z[1], z[2], 0}
+	m[3] = vec4d.T{eye[0], eye[1], eye[2], 1}
+	return m
+}
+
+// daeSkew 与 RenderMan 的 RiSkew 一致：点沿 trans 方向平移，
+// 使 rot 向量朝 trans 方向转过 angle 弧度
+func daeSkew(angle float64, rot, trans vec3d.T) mat4d.T {
+	if rot.Length() == 0 || trans.Length() == 0 {
+		return mat4d.Ident
+	}
+	rot.Normalize()
+	trans.Normalize()
+	along := vec3d.Dot(&rot, &trans)
+	perp := trans.Scaled(along)
+	perp = vec3d.Sub(&rot, &perp)
+	h := perp.Length()
+	if h < 1e-12 {
+		return mat4d.Ident
+	}
+	perp.Scale(1 / h)
+	k := math.Tan(math.Atan2(along, h)+angle) - along/h
+	m := mat4d.Ident
+	for c := 0; c < 3; c++ {
+		for r := 0; r < 3; r++ {
+			m[c][r] += k * trans[r] * perp[c]
+		}
+	}
+	return m
+}
+
+func parseDaeFloats(s string) []float64 {
+	var res []float64
+	for _, f := range strings.Fields(s) {
+		v, _ := strconv.ParseFloat(f, 64)
+		res = append(res, v)
+	}
+	return res
+}
@@ -0,0 +1,428 @@
+package asset3d
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	dae "github.com/flywave/go-collada"
+	mst "github.com/flywave/go-mst"
+	mat4d "github.com/flywave/go3d/float64/mat4"
+	vec3d "github.com/flywave/go3d/float64/vec3"
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+)
+
+// DaeVertexLayers 节点的附加顶点属性，与节点顶点一一对应
+type DaeVertexLayers struct {
+	// TexCoords 全部纹理坐标组，下标为 set 属性，缺少某组的图元以零填充，
+	// 所有图元都没有的组为 nil
+	TexCoords [][]vec2.T
+}
+
+// daeCorner 图元中的一个顶点，按 offset 保存各输入的索引
+type daeCorner []int
+
+type daePolygon struct {
+	outer []daeCorner
+	holes [][]daeCorner
+}
+
+// daePrimitive 统一表示 polylist、polygons、triangles、trifans、tristrips、lines 和 linestrips
+type daePrimitive struct {
+	inputs   []*dae.InputShared
+	stride   int
+	material string
+	polys    []daePolygon
+	lines    [][2]daeCorner
+}
+
+func newDaePrimitive(inputs []*dae.InputShared, material string) *daePrimitive {
+	p := &daePrimitive{inputs: inputs, material: material}
+	for _, in := range inputs {
+		if int(in.Offset)+1 > p.stride {
+			p.stride = int(in.Offset) + 1
+		}
+	}
+	return p
+}
+
+func (p *daePrimitive) corners(s string) []daeCorner {
+	if p.stride == 0 {
+		return nil
+	}
+	ints := parseDaeInts(s)
+	res := make([]daeCorner, 0, len(ints)/p.stride)
+	for i := 0; i+p.stride <= len(ints); i += p.stride {
+		res = append(res, daeCorner(ints[i:i+p.stride]))
+	}
+	return res
+}
+
+func (p *daePrimitive) addTriangle(a, b, c daeCorner) {
+	p.polys = append(p.polys, daePolygon{outer: []daeCorner{a, b, c}})
+}
+
+func (cv *DaeToMst) meshPrimitives(mh *dae.Mesh) []*daePrimitive {
+	var prims []*daePrimitive
+	for _, pl := range mh.Polylist {
+		prim := newDaePrimitive(pl.Input, pl.Material)
+		if pl.P != nil {
+			cs := prim.corners(pl.P.V)
+			var vcount []int
+			if pl.VCount != nil {
+				vcount = parseDaeInts(pl.VCount.V)
+			}
+			j := 0
+			for _, n := range vcount {
+				if n < 0 || j+n > len(cs) {
+					break
+				}
+				prim.polys = append(prim.polys, daePolygon{outer: cs[j : j+n]})
+				j += n
+			}
+		}
+		prims = append(prims, prim)
+	}
+	for _, pg := range mh.Polygons {
+		prim := newDaePrimitive(pg.Input, pg.Material)
+		for _, pp := range pg.P {
+			prim.polys = append(prim.polys, daePolygon{outer: prim.corners(pp.V)})
+		}
+		for _, ph := range pg.Ph {
+			poly := daePolygon{outer: prim.corners(ph.P.V)}
+			for _, h := range ph.H {
+				poly.holes = append(poly.holes, prim.corners(h.V))
+			}
+			prim.polys = append(prim.polys, poly)
+		}
+		prims = append(prims, prim)
+	}
+	for _, t := range mh.Triangles {
+		prim := newDaePrimitive(t.Input, t.Material)
+		if t.P != nil {
+			cs := prim.corners(t.P.V)
+			for i := 0; i+3 <= len(cs); i += 3 {
+				prim.addTriangle(cs[i], cs[i+1], cs[i+2])
+			}
+		}
+		prims = append(prims, prim)
+	}
+	for _, t := range mh.Trifans {
+		prim := newDaePrimitive(t.Input, t.Material)
+		for _, s := range cv.stripPs(t) {
+			cs := prim.corners(s)
+			for i := 1; i+1 < len(cs); i++ {
+				prim.addTriangle(cs[0], cs[i], cs[i+1])
+			}
+		}
+		prims = append(prims, prim)
+	}
+	for _, t := range mh.Tristrips {
+		prim := newDaePrimitive(t.Input, t.Material)
+		for _, s := range cv.stripPs(t) {
+			cs := prim.corners(s)
+			for i := 0; i+2 < len(cs); i++ {
+				if i%2 == 0 {
+					prim.addTriangle(cs[i], cs[i+1], cs[i+2])
+				} else {
+					prim.addTriangle(cs[i+1], cs[i], cs[i+2])
+				}
+			}
+		}
+		prims = append(prims, prim)
+	}
+	for _, l := range mh.Lines {
+		prim := newDaePrimitive(l.Input, l.Material)
+		if l.P != nil {
+			cs := prim.corners(l.P.V)
+			for i := 0; i+1 < len(cs); i += 2 {
+				prim.lines = append(prim.lines, [2]daeCorner{cs[i], cs[i+1]})
+			}
+		}
+		prims = append(prims, prim)
+	}
+	for _, l := range mh.Linestrips {
+		prim := newDaePrimitive(l.Input, l.Material)
+		for _, pp := range l.P {
+			cs := prim.corners(pp.V)
+			for i := 0; i+1 < len(cs); i++ {
+				prim.lines = append(prim.lines, [2]daeCorner{cs[i], cs[i+1]})
+			}
+		}
+		prims = append(prims, prim)
+	}
+	return prims
+}
+
+// stripPs 返回条带或扇形的全部 <p>，没有顺序信息时退回 go-collada 保留的那一个
+func (cv *DaeToMst) stripPs(t dae.Trig) []string {
+	if ps, ok := cv.strips[t]; ok {
+		return ps
+	}
+	if p := t.GetP(); p != nil {
+		return []string{p.V}
+	}
+	return nil
+}
+
+// daeSourceData 解析后的 source 数据
+type daeSourceData struct {
+	values []float64
+	stride int
+	offset int
+}
+
+func (s *daeSourceData) get(idx int, out []float64) bool {
+	base := s.offset + idx*s.stride
+	if idx < 0 || base+len(out) > len(s.values) {
+		return false
+	}
+	copy(out, s.values[base:base+len(out)])
+	return true
+}
+
+// daeInputRef 图元中的一个属性输入，offset 为 -1 时表示该输入来自 VERTEX 引用的 <vertices>
+type daeInputRef struct {
+	semantic string
+	set      int
+	offset   int
+	src      *daeSourceData
+}
+
+// daeMeshBuilder 把一个几何体的全部图元展开为 MST 节点，每个三角形角点生成一个顶点
+type daeMeshBuilder struct {
+	mh        *dae.Mesh
+	mat       *mat4d.T
+	normalMat mat4d.T
+	sources   map[string]*daeSourceData
+
+	nd        *mst.MeshNode
+	hasNormal bool
+	hasColor  bool
+	uvs       [][]vec2.T
+	bbx       vec3d.Box
+	posIdx    []int // 每个输出顶点对应的 POSITION 索引，供蒙皮和变形使用
+}
+
+func newDaeMeshBuilder(mh *dae.Mesh, mat *mat4d.T) *daeMeshBuilder {
+	b := &daeMeshBuilder{mh: mh, mat: mat, sources: make(map[string]*daeSourceData), nd: &mst.MeshNode{}, bbx: vec3d.MinBox}
+	b.normalMat = mat.Adjugated()
+	b.normalMat.Transpose()
+	if mat.Determinant() < 0 {
+		b.normalMat.Mul(-1)
+	}
+	return b
+}
+
+func (b *daeMeshBuilder) source(uri dae.Uri) *daeSourceData {
+	id := uri.GetId()
+	if s, ok := b.sources[id]; ok {
+		return s
+	}
+	var res *daeSourceData
+	for _, src := range b.mh.Source {
+		if string(src.Id) != id || src.FloatArray == nil {
+			continue
+		}
+		acc := src.TechniqueCommon.Accessor
+		res = &daeSourceData{values: parseDaeFloats(src.FloatArray.V), stride: acc.Stride, offset: acc.Offset}
+		if res.stride == 0 {
+			res.stride = len(acc.Params)
+		}
+		if res.stride == 0 {
+			res.stride = 1
+		}
+		break
+	}
+	b.sources[id] = res
+	return res
+}
+
+func (b *daeMeshBuilder) inputRefs(prim *daePrimitive) (pos *daeInputRef, refs []*daeInputRef) {
+	for _, in := range prim.inputs {
+		if in.Semantic == "VERTEX" {
+			for _, vin := range b.mh.Vertices.Input {
+				ref := &daeInputRef{semantic: vin.Semantic, offset: int(in.Offset), src: b.source(vin.Source)}
+				if ref.src == nil {
+					continue
+				}
+				if ref.semantic == "POSITION" {
+					pos = ref
+				} else {
+					refs = append(refs, ref)
+				}
+			}
+			continue
+		}
+		ref := &daeInputRef{semantic: in.Semantic, set: int(in.Set), offset: int(in.Offset), src: b.source(in.Source)}
+		if ref.src != nil {
+			refs = append(refs, ref)
+		}
+	}
+	sort.SliceStable(refs, func(i, j int) bool { return refs[i].set < refs[j].set })
+	// 同一图元中 set 重复(通常是省略了 set)的纹理坐标依次放入后面未使用的组
+	used := make(map[int]bool)
+	for _, ref := range refs {
+		if ref.semantic != "TEXCOORD" {
+			continue
+		}
+		for used[ref.set] {
+			ref.set++
+		}
+		used[ref.set] = true
+	}
+	return
+}
+
+func (b *daeMeshBuilder) position(pos *daeInputRef, c daeCorner) vec3d.T {
+	var v [3]float64
+	pos.src.get(c[pos.offset], v[:])
+	return vec3d.T(v)
+}
+
+// addCorner 追加一个顶点，缺失的属性以零填充，保证各属性数组与顶点等长
+func (b *daeMeshBuilder) addCorner(pos *daeInputRef, refs []*daeInputRef, c daeCorner) uint32 {
+	idx := uint32(len(b.nd.Vertices))
+	p := b.position(pos, c)
+	b.posIdx = append(b.posIdx, c[pos.offset])
+	p = b.mat.MulVec3(&p)
+	b.bbx.Extend(&p)
+	b.nd.Vertices = append(b.nd.Vertices, vec3.T{float32(p[0]), float32(p[1]), float32(p[2])})
+
+	var normal vec3.T
+	var color [3]byte
+	for _, ref := range refs {
+		switch ref.semantic {
+		case "NORMAL":
+			var v [3]float64
+			if ref.src.get(c[ref.offset], v[:]) {
+				n := vec3d.T(v)
+				n = b.normalMat.MulVec3W(&n, 0)
+				n.Normalize()
+				normal = vec3.T{float32(n[0]), float32(n[1]), float32(n[2])}
+				b.hasNormal = true
+			}
+		case "COLOR":
+			var v [3]float64
+			if ref.src.get(c[ref.offset], v[:]) {
+				for i := range color {
+					color[i] = byte(clampUnit(v[i])*255 + 0.5)
+				}
+				b.hasColor = true
+			}
+		case "TEXCOORD":
+			var v [2]float64
+			ref.src.get(c[ref.offset], v[:])
+			for len(b.uvs) <= ref.set {
+				b.uvs = append(b.uvs, nil)
+			}
+			if b.uvs[ref.set] == nil {
+				b.uvs[ref.set] = make([]vec2.T, idx, idx+1)
+			}
+			b.uvs[ref.set] = append(b.uvs[ref.set], vec2.T{float32(v[0]), float32(v[1])})
+		}
+	}
+	for i := range b.uvs {
+		if b.uvs[i] != nil && len(b.uvs[i]) <= int(idx) {
+			b.uvs[i] = append(b.uvs[i], vec2.T{})
+		}
+	}
+	b.nd.Normals = append(b.nd.Normals, normal)
+	b.nd.Colors = append(b.nd.Colors, color)
+	return idx
+}
+
+func (b *daeMeshBuilder) addPrimitive(prim *daePrimitive, batch int32) {
+	pos, refs := b.inputRefs(prim)
+	if pos == nil {
+		return
+	}
+	if len(prim.polys) > 0 {
+		fg := &mst.MeshTriangle{Batchid: batch}
+		for _, poly := range prim.polys {
+			corners := append([]daeCorner(nil), poly.outer...)
+			outer := make([]vec3d.T, len(poly.outer))
+			for i, c := range poly.outer {
+				outer[i] = b.position(pos, c)
+			}
+			holes := make([][]vec3d.T, 0, len(poly.holes))
+			for _, h := range poly.holes {
+				if len(h) < 3 {
+					continue
+				}
+				hole := make([]vec3d.T, len(h))
+				for i, c := range h {
+					hole[i] = b.position(pos, c)
+				}
+				holes = append(holes, hole)
+				corners = append(corners, h...)
+			}
+			for _, t := range triangulatePolygon(outer, holes) {
+				f := &mst.Face{}
+				for k := range t {
+					f.Vertex[k] = b.addCorner(pos, refs, corners[t[k]])
+				}
+				fg.Faces = append(fg.Faces, f)
+			}
+		}
+		b.nd.FaceGroup = append(b.nd.FaceGroup, fg)
+	}
+	if len(prim.lines) > 0 {
+		eg := &mst.MeshOutline{Batchid: batch}
+		for _, l := range prim.lines {
+			eg.Edges = append(eg.Edges, [2]uint32{b.addCorner(pos, refs, l[0]), b.addCorner(pos, refs, l[1])})
+		}
+		b.nd.EdgeGroup = append(b.nd.EdgeGroup, eg)
+	}
+}
+
+// finish 丢弃全部为空的属性，选取 set 为 uvChannel 的纹理坐标组写入节点，该组不存在时使用第一个存在的组
+func (b *daeMeshBuilder) finish(uvChannel int) (*mst.MeshNode, *DaeVertexLayers) {
+	nd := b.nd
+	for i := range b.uvs {
+		for b.uvs[i] != nil && len(b.uvs[i]) < len(nd.Vertices) {
+			b.uvs[i] = append(b.uvs[i], vec2.T{})
+		}
+	}
+	if !b.hasColor {
+		nd.Colors = nil
+	}
+	if !b.hasNormal {
+		nd.Normals = nil
+	}
+	if uvChannel >= 0 && uvChannel < len(b.uvs) && b.uvs[uvChannel] != nil {
+		nd.TexCoords = b.uvs[uvChannel]
+	} else {
+		for _, uv := range b.uvs {
+			if uv != nil {
+				nd.TexCoords = uv
+				break
+			}
+		}
+	}
+	if nd.Normals == nil && len(nd.FaceGroup) > 0 {
+		nd.ReComputeNormal()
+	}
+	return nd, &DaeVertexLayers{TexCoords: b.uvs}
+}
+
+func clampUnit(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
+
+func parseDaeInts(s string) []int {
+	fs := strings.Fields(s)
+	res := make([]int, 0, len(fs))
+	for _, f := range fs {
+		v, _ := strconv.Atoi(f)
+		res = append(res, v)
+	}
+	return res
+}
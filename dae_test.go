@@ -0,0 +1,244 @@
+package asset3d
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flywave/go3d/vec2"
+)
+
+// writeDaeTest 写出只包含给定库元素的 COLLADA 文件
+func writeDaeTest(t *testing.T, body string) string {
+	t.Helper()
+	src := `<?xml version="1.0" encoding="utf-8"?>
+<COLLADA xmlns="http://www.collada.org/2005/11/COLLADASchema" version="1.4.1">
+` + body + `
+</COLLADA>`
+	path := filepath.Join(t.TempDir(), "test.dae")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// daeTestTriangle 一个三角形的位置数据和 <vertices>
+const daeTestTriangle = `
+      <source id="pos">
+        <float_array id="pos-array" count="9">0 0 0 1 0 0 0 1 0</float_array>
+        <technique_common><accessor source="#pos-array" count="3" stride="3"/></technique_common>
+      </source>
+      <vertices id="verts"><input semantic="POSITION" source="#pos"/></vertices>`
+
+func TestDaeToMst_TexcoordSets(t *testing.T) {
+	// 第一个图元只有 set 1，第二个图元有 set 0 和 set 1
+	path := writeDaeTest(t, `
+  <library_geometries>
+    <geometry id="geo">
+      <mesh>`+daeTestTriangle+`
+        <source id="uv0">
+          <float_array id="uv0-array" count="2">0.25 0.25</float_array>
+          <technique_common><accessor source="#uv0-array" count="1" stride="2"/></technique_common>
+        </source>
+        <source id="uv1">
+          <float_array id="uv1-array" count="2">0.75 0.5</float_array>
+          <technique_common><accessor source="#uv1-array" count="1" stride="2"/></technique_common>
+        </source>
+        <triangles count="1">
+          <input semantic="VERTEX" source="#verts" offset="0"/>
+          <input semantic="TEXCOORD" source="#uv1" offset="1" set="1"/>
+          <p>0 0 1 0 2 0</p>
+        </triangles>
+        <triangles count="1">
+          <input semantic="VERTEX" source="#verts" offset="0"/>
+          <input semantic="TEXCOORD" source="#uv0" offset="1" set="0"/>
+          <input semantic="TEXCOORD" source="#uv1" offset="1" set="1"/>
+          <p>0 0 1 0 2 0</p>
+        </triangles>
+      </mesh>
+    </geometry>
+  </library_geometries>
+  <library_visual_scenes>
+    <visual_scene id="scene"><node id="n"><instance_geometry url="#geo"/></node></visual_scene>
+  </library_visual_scenes>`)
+
+	for _, channel := range []int{1, 0} {
+		cv := &DaeToMst{UVChannel: channel}
+		mesh, _, err := cv.Convert(path)
+		if err != nil {
+			t.Fatalf("转换失败: %v", err)
+		}
+		nd := mesh.Nodes[0]
+		layers := cv.Layers[nd]
+		if len(nd.Vertices) != 6 || len(layers.TexCoords) != 2 {
+			t.Fatalf("期望6个顶点和2组纹理坐标，实际%d个顶点，%d组", len(nd.Vertices), len(layers.TexCoords))
+		}
+		// 第一个图元的 set 1 不能被压缩到第 0 组
+		if layers.TexCoords[0][0] != (vec2.T{}) || layers.TexCoords[1][0] != (vec2.T{0.75, 0.5}) {
+			t.Errorf("纹理坐标组错误: %v", layers.TexCoords)
+		}
+		if layers.TexCoords[0][3] != (vec2.T{0.25, 0.25}) || layers.TexCoords[1][3] != (vec2.T{0.75, 0.5}) {
+			t.Errorf("纹理坐标组错误: %v", layers.TexCoords)
+		}
+		want := [2]vec2.T{{0.25, 0.25}, {0.75, 0.5}}[channel]
+		if nd.TexCoords[3] != want {
+			t.Errorf("UVChannel=%d 选择了错误的纹理坐标组: %v", channel, nd.TexCoords)
+		}
+	}
+}
+
+// daeTestGeometry 只有一个三角形的几何体
+const daeTestGeometry = `
+  <library_geometries>
+    <geometry id="geo">
+      <mesh>` + daeTestTriangle + `
+        <triangles count="1">
+          <input semantic="VERTEX" source="#verts" offset="0"/>
+          <p>0 1 2</p>
+        </triangles>
+      </mesh>
+    </geometry>
+  </library_geometries>`
+
+func TestDaeToMst_TransformOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		xform string
+		want  [3]float32 // 顶点 (1,0,0) 变换后的位置
+	}{
+		// 变换元素按文档顺序右乘，最后一个元素最先作用于顶点
+		{"先旋转后平移", `<translate>1 0 0</translate><rotate>0 0 1 90</rotate>`, [3]float32{1, 1, 0}},
+		{"先平移后旋转", `<rotate>0 0 1 90</rotate><translate>1 0 0</translate>`, [3]float32{0, 2, 0}},
+		{"缩放", `<translate>0 0 3</translate><scale>2 2 2</scale>`, [3]float32{2, 0, 3}},
+		// matrix 按行主序书写
+		{"矩阵", `<matrix>1 0 0 5 0 1 0 6 0 0 1 7 0 0 0 1</matrix><scale>2 1 1</scale>`, [3]float32{7, 6, 7}},
+	}
+	for _, tt := range tests {
+		path := writeDaeTest(t, daeTestGeometry+`
+  <library_visual_scenes>
+    <visual_scene id="scene">
+      <node id="n">`+tt.xform+`<instance_geometry url="#geo"/></node>
+    </visual_scene>
+  </library_visual_scenes>`)
+		mesh, _, err := (&DaeToMst{}).Convert(path)
+		if err != nil {
+			t.Fatalf("%s: 转换失败: %v", tt.name, err)
+		}
+		v := mesh.Nodes[0].Vertices[1]
+		for i := range v {
+			if d := v[i] - tt.want[i]; d > 1e-5 || d < -1e-5 {
+				t.Errorf("%s: 顶点为%v，期望%v", tt.name, v, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestDaeToMst_InstanceNode(t *testing.T) {
+	// library_nodes 中的节点被引用两次，父子节点的矩阵依次合成；自引用的节点不会无限递归
+	path := writeDaeTest(t, daeTestGeometry+`
+  <library_nodes>
+    <node id="part">
+      <translate>0 0 5</translate>
+      <instance_geometry url="#geo"/>
+      <instance_node url="#part"/>
+    </node>
+  </library_nodes>
+  <library_visual_scenes>
+    <visual_scene id="scene">
+      <node id="a"><translate>10 0 0</translate><instance_node url="#part"/></node>
+      <node id="b">
+        <translate>20 0 0</translate>
+        <node id="c"><translate>0 1 0</translate><instance_node url="#part"/></node>
+      </node>
+    </visual_scene>
+  </library_visual_scenes>
+  <scene><instance_visual_scene url="#scene"/></scene>`)
+	mesh, bbox, err := (&DaeToMst{}).Convert(path)
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	if len(mesh.Nodes) != 0 || len(mesh.Instances) != 1 {
+		t.Fatalf("期望一个共享网格的实例，实际%d个节点，%d个实例", len(mesh.Nodes), len(mesh.Instances))
+	}
+	inst := mesh.Instances[0]
+	if len(inst.Transfors) != 2 {
+		t.Fatalf("期望2个变换，实际%d个", len(inst.Transfors))
+	}
+	for i, want := range [][3]float64{{10, 0, 5}, {20, 1, 5}} {
+		if tr := inst.Transfors[i][3]; tr[0] != want[0] || tr[1] != want[1] || tr[2] != want[2] {
+			t.Errorf("第%d个实例的平移为%v，期望%v", i, tr, want)
+		}
+	}
+	if bbox[0] != 10 || bbox[3] != 21 || bbox[4] != 2 || bbox[2] != 5 {
+		t.Errorf("包围盒错误: %v", bbox)
+	}
+}
+
+func TestDaeToMst_SkinMissingJoint(t *testing.T) {
+	// 第二个关节在场景中不存在，节点矩阵缩放为零不可逆
+	path := writeDaeTest(t, daeTestGeometry+`
+  <library_controllers>
+    <controller id="skin">
+      <skin source="#geo">
+        <source id="joints">
+          <Name_array id="joints-array" count="2">J1 MISSING</Name_array>
+          <technique_common><accessor source="#joints-array" count="2"/></technique_common>
+        </source>
+        <source id="weights">
+          <float_array id="weights-array" count="2">0.25 0.75</float_array>
+          <technique_common><accessor source="#weights-array" count="2"/></technique_common>
+        </source>
+        <joints><input semantic="JOINT" source="#joints"/></joints>
+        <vertex_weights count="3">
+          <input semantic="JOINT" source="#joints" offset="0"/>
+          <input semantic="WEIGHT" source="#weights" offset="1"/>
+          <vcount>2 1 1</vcount>
+          <v>0 0 1 1 0 0 1 1</v>
+        </vertex_weights>
+      </skin>
+    </controller>
+  </library_controllers>
+  <library_visual_scenes>
+    <visual_scene id="scene">
+      <node id="J1" sid="J1" type="JOINT"/>
+      <node id="mesh"><scale>0 1 1</scale><instance_controller url="#skin"/></node>
+    </visual_scene>
+  </library_visual_scenes>
+  <scene><instance_visual_scene url="#scene"/></scene>`)
+	cv := &DaeToMst{ImportAnimation: true}
+	mesh, _, err := cv.Convert(path)
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	if len(mesh.Nodes) != 1 {
+		t.Fatalf("期望1个节点，实际%d个", len(mesh.Nodes))
+	}
+	skin := cv.Skins[mesh.Nodes[0]]
+	if skin == nil {
+		t.Fatal("没有蒙皮")
+	}
+	if len(skin.Bones) != 1 || cv.Skeleton[skin.Bones[0]].Id != "J1" {
+		t.Fatalf("找不到的关节不应出现在 Bones 中: %v", skin.Bones)
+	}
+	for i, w := range skin.Weights {
+		if skin.Joints[i][0] != 0 || w[1] != 0 {
+			t.Errorf("第%d个顶点的关节或权重错误: %v %v", i, skin.Joints[i], w)
+		}
+	}
+	// 第一个顶点去掉找不到的关节后权重重新归一化，第三个顶点只有找不到的关节
+	if skin.Weights[0][0] != 1 || skin.Weights[1][0] != 1 || skin.Weights[2][0] != 0 {
+		t.Errorf("权重错误: %v", skin.Weights)
+	}
+	for _, v := range skin.InverseBind[0] {
+		for _, f := range v {
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				t.Fatalf("奇异矩阵的逆无效: %v", skin.InverseBind[0])
+			}
+		}
+	}
+	if len(cv.Diagnostics.Filter(DiagnosticWarning)) != 1 {
+		t.Errorf("找不到的关节应记录警告: %v", cv.Diagnostics)
+	}
+}
@@ -0,0 +1,64 @@
+package asset3d
+
+import "fmt"
+
+// DiagnosticLevel 诊断信息级别
+type DiagnosticLevel int
+
+const (
+	DiagnosticInfo DiagnosticLevel = iota
+	DiagnosticWarning
+	DiagnosticError
+)
+
+func (l DiagnosticLevel) String() string {
+	switch l {
+	case DiagnosticInfo:
+		return "info"
+	case DiagnosticWarning:
+		return "warning"
+	case DiagnosticError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// Diagnostic 转换过程中不影响输出结果的问题，例如找不到的纹理
+type Diagnostic struct {
+	Level   DiagnosticLevel
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return d.Level.String() + ": " + d.Message
+}
+
+// Diagnostics 按发生顺序记录的诊断信息
+type Diagnostics []Diagnostic
+
+func (d *Diagnostics) add(level DiagnosticLevel, format string, args ...interface{}) {
+	*d = append(*d, Diagnostic{Level: level, Message: fmt.Sprintf(format, args...)})
+}
+
+func (d *Diagnostics) Infof(format string, args ...interface{}) {
+	d.add(DiagnosticInfo, format, args...)
+}
+
+func (d *Diagnostics) Warnf(format string, args ...interface{}) {
+	d.add(DiagnosticWarning, format, args...)
+}
+
+func (d *Diagnostics) Errorf(format string, args ...interface{}) {
+	d.add(DiagnosticError, format, args...)
+}
+
+// Filter 返回不低于指定级别的诊断信息
+func (d Diagnostics) Filter(level DiagnosticLevel) Diagnostics {
+	var res Diagnostics
+	for _, it := range d {
+		if it.Level >= level {
+			res = append(res, it)
+		}
+	}
+	return res
+}
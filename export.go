@@ -0,0 +1,295 @@
+package asset3d
+
+import (
+	"fmt"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	mst "github.com/flywave/go-mst"
+	mat4d "github.com/flywave/go3d/float64/mat4"
+	vec3d "github.com/flywave/go3d/float64/vec3"
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+)
+
+// exportMesh 展开后的 MST 网格: 实例按每个变换复制一份，所有顶点变换到世界坐标
+type exportMesh struct {
+	materials []mst.MeshMaterial
+	nodes     []*exportNode
+}
+
+// exportNode 展开后的节点，各顶点属性按顶点索引对齐，缺少的属性为空
+type exportNode struct {
+	name      string
+	vertices  []vec3.T
+	normals   []vec3.T
+	texCoords []vec2.T
+	colors    [][3]byte
+	groups    []*exportGroup
+}
+
+// exportGroup 使用同一材质的三角形，material 为 exportMesh.materials 中的索引，-1 表示没有材质
+type exportGroup struct {
+	material int
+	faces    [][3]uint32
+}
+
+// flattenMesh 展开网格和实例。names 为节点名，没有名字的节点按 node_<序号> 和
+// instance_<实例序号>_<变换序号> 命名
+func flattenMesh(mesh *mst.Mesh, names map[*mst.MeshNode]string) *exportMesh {
+	em := &exportMesh{}
+	name := func(nd *mst.MeshNode, format string, args ...interface{}) string {
+		if n, ok := names[nd]; ok && n != "" {
+			return n
+		}
+		return fmt.Sprintf(format, args...)
+	}
+
+	offset := em.addMaterials(&mesh.BaseMesh)
+	for i, nd := range mesh.Nodes {
+		em.addNode(nd, offset, len(mesh.Materials), nil, name(nd, "node_%d", i))
+	}
+	for i, inst := range mesh.Instances {
+		if inst == nil || inst.Mesh == nil {
+			continue
+		}
+		offset := em.addMaterials(inst.Mesh)
+		for j, mat := range inst.Transfors {
+			for k, nd := range inst.Mesh.Nodes {
+				n := name(nd, "instance_%d_%d", i, j)
+				if len(inst.Mesh.Nodes) > 1 {
+					n = fmt.Sprintf("%s_%d", n, k)
+				}
+				em.addNode(nd, offset, len(inst.Mesh.Materials), mat, n)
+			}
+		}
+	}
+	return em
+}
+
+func (em *exportMesh) addMaterials(mh *mst.BaseMesh) int {
+	offset := len(em.materials)
+	em.materials = append(em.materials, mh.Materials...)
+	return offset
+}
+
+// addNode 复制节点的顶点属性，法线、纹理坐标和颜色都按顶点索引取值，只复制被面引用的顶点，
+// 矩阵为 实例变换·节点矩阵
+func (em *exportMesh) addNode(nd *mst.MeshNode, offset, nmtl int, inst *mat4d.T, name string) {
+	if nd == nil {
+		return
+	}
+	var mat *mat4d.T
+	switch {
+	case inst != nil && nd.Mat != nil:
+		m := mulMat4(*inst, *nd.Mat)
+		mat = &m
+	case inst != nil:
+		mat = inst
+	default:
+		mat = nd.Mat
+	}
+	tr := newExportTransform(mat)
+
+	en := &exportNode{name: name}
+	index := make(map[uint32]uint32)
+	corner := func(v uint32) uint32 {
+		if idx, ok := index[v]; ok {
+			return idx
+		}
+		idx := uint32(len(en.vertices))
+		index[v] = idx
+		en.vertices = append(en.vertices, tr.point(nd.Vertices[v]))
+		if len(nd.Normals) > 0 {
+			var vn vec3.T
+			if int(v) < len(nd.Normals) {
+				vn = tr.normal(nd.Normals[v])
+			}
+			en.normals = append(en.normals, vn)
+		}
+		if len(nd.TexCoords) > 0 {
+			var vt vec2.T
+			if int(v) < len(nd.TexCoords) {
+				vt = nd.TexCoords[v]
+			}
+			en.texCoords = append(en.texCoords, vt)
+		}
+		if len(nd.Colors) > 0 {
+			var cl [3]byte
+			if int(v) < len(nd.Colors) {
+				cl = nd.Colors[v]
+			}
+			en.colors = append(en.colors, cl)
+		}
+		return idx
+	}
+
+	for _, fg := range nd.FaceGroup {
+		g := &exportGroup{material: -1}
+		if fg.Batchid >= 0 && int(fg.Batchid) < nmtl {
+			g.material = offset + int(fg.Batchid)
+		}
+	faces:
+		for _, f := range fg.Faces {
+			var tri [3]uint32
+			for k := 0; k < 3; k++ {
+				v := f.Vertex[k]
+				if int(v) >= len(nd.Vertices) {
+					continue faces
+				}
+				tri[k] = corner(v)
+			}
+			if tr.flip {
+				tri[1], tri[2] = tri[2], tri[1]
+			}
+			g.faces = append(g.faces, tri)
+		}
+		if len(g.faces) > 0 {
+			en.groups = append(en.groups, g)
+		}
+	}
+	if len(en.groups) > 0 {
+		em.nodes = append(em.nodes, en)
+	}
+}
+
+// exportTransform 顶点和法线的变换，法线使用逆转置矩阵，镜像变换时翻转三角形方向
+type exportTransform struct {
+	mat       *mat4d.T
+	normalMat mat4d.T
+	flip      bool
+}
+
+func newExportTransform(mat *mat4d.T) *exportTransform {
+	tr := &exportTransform{mat: mat}
+	if mat == nil {
+		return tr
+	}
+	det := mat.Determinant3x3()
+	tr.flip = det < 0
+	tr.normalMat = *mat
+	if math.Abs(det) > 1e-12 {
+		tr.normalMat = mat.Inverted()
+		tr.normalMat.Transpose()
+	}
+	return tr
+}
+
+func (tr *exportTransform) point(v vec3.T) vec3.T {
+	if tr.mat == nil {
+		return v
+	}
+	p := tr.mat.MulVec3(&vec3d.T{float64(v[0]), float64(v[1]), float64(v[2])})
+	return vec3.T{float32(p[0]), float32(p[1]), float32(p[2])}
+}
+
+func (tr *exportTransform) normal(v vec3.T) vec3.T {
+	if tr.mat == nil {
+		return v
+	}
+	n := tr.normalMat.MulVec3W(&vec3d.T{float64(v[0]), float64(v[1]), float64(v[2])}, 0)
+	if n.LengthSqr() > 0 {
+		n.Normalize()
+	}
+	return vec3.T{float32(n[0]), float32(n[1]), float32(n[2])}
+}
+
+// exportMaterial 各类 MST 材质中导出时用到的属性
+type exportMaterial struct {
+	color, ambient, diffuse, specular, emissive [3]byte
+	transparency                                float32
+	shininess                                   float32
+	metallic, roughness                         float32
+	// lighting 0 为纯色材质，1 为 Lambert，2 为 Phong，3 为 PBR
+	lighting        int
+	texture, normal *mst.Texture
+}
+
+func newExportMaterial(m mst.MeshMaterial) *exportMaterial {
+	em := &exportMaterial{}
+	var tm *mst.TextureMaterial
+	switch mt := m.(type) {
+	case *mst.BaseMaterial:
+		em.color, em.transparency = mt.Color, mt.Transparency
+	case *mst.TextureMaterial:
+		tm = mt
+	case *mst.LambertMaterial:
+		tm = &mt.TextureMaterial
+		em.lighting = 1
+		em.ambient, em.diffuse, em.emissive = mt.Ambient, mt.Diffuse, mt.Emissive
+	case *mst.PhongMaterial:
+		tm = &mt.TextureMaterial
+		em.lighting = 2
+		em.ambient, em.diffuse, em.emissive = mt.Ambient, mt.Diffuse, mt.Emissive
+		em.specular, em.shininess = mt.Specular, mt.Shininess
+	case *mst.PbrMaterial:
+		tm = &mt.TextureMaterial
+		em.lighting = 3
+		em.emissive = mt.Emissive
+		em.metallic, em.roughness = mt.Metallic, mt.Roughness
+	default:
+		if m != nil {
+			em.color, em.emissive = m.GetColor(), m.GetEmissive()
+			em.texture = m.GetTexture()
+		}
+	}
+	if tm != nil {
+		em.color, em.transparency = tm.Color, tm.Transparency
+		em.texture, em.normal = tm.Texture, tm.Normal
+	}
+	if em.lighting == 0 || em.lighting == 3 {
+		em.diffuse = em.color
+	}
+	return em
+}
+
+// exportTextures 将纹理解压后写为 PNG 文件，文件放在导出文件旁边，同一纹理只写一次
+type exportTextures struct {
+	dir    string
+	prefix string
+	names  map[*mst.Texture]string
+	diag   *Diagnostics
+}
+
+func newExportTextures(path string, diag *Diagnostics) *exportTextures {
+	base := filepath.Base(path)
+	return &exportTextures{
+		dir:    filepath.Dir(path),
+		prefix: strings.TrimSuffix(base, filepath.Ext(base)),
+		names:  make(map[*mst.Texture]string),
+		diag:   diag,
+	}
+}
+
+// write 返回纹理相对导出文件的文件名，纹理无法解码时记录诊断信息并返回空
+func (et *exportTextures) write(tex *mst.Texture) (string, error) {
+	if tex == nil {
+		return "", nil
+	}
+	if name, ok := et.names[tex]; ok {
+		return name, nil
+	}
+	img, err := texToImage(tex)
+	if err != nil {
+		et.diag.Warnf("texture %d cannot be decoded: %v", tex.Id, err)
+		et.names[tex] = ""
+		return "", nil
+	}
+	name := fmt.Sprintf("%s_%d.png", et.prefix, len(et.names))
+	f, err := os.Create(filepath.Join(et.dir, name))
+	if err != nil {
+		return "", err
+	}
+	err = png.Encode(f, img)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	et.names[tex] = name
+	return name, nil
+}
@@ -0,0 +1,204 @@
+package asset3d
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	mst "github.com/flywave/go-mst"
+	mat4d "github.com/flywave/go3d/float64/mat4"
+	vec3d "github.com/flywave/go3d/float64/vec3"
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+)
+
+// exportTestMesh 一个带纹理的单位正方形，以及一个平移两次的红色正方形实例
+func exportTestMesh() *mst.Mesh {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.NRGBA{255, 0, 0, 255})
+	img.Set(1, 0, color.NRGBA{0, 255, 0, 255})
+	img.Set(0, 1, color.NRGBA{0, 0, 255, 255})
+	img.Set(1, 1, color.NRGBA{255, 255, 0, 255})
+	tex := imageToTex(img, 0)
+	tex.Repeated = true
+
+	quad := func() *mst.MeshNode {
+		return &mst.MeshNode{
+			Vertices:  []vec3.T{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0}},
+			Normals:   []vec3.T{{0, 0, 1}, {0, 0, 1}, {0, 0, 1}, {0, 0, 1}},
+			TexCoords: []vec2.T{{0, 0}, {1, 0}, {1, 1}, {0, 1}},
+			FaceGroup: []*mst.MeshTriangle{{
+				Batchid: 0,
+				Faces:   []*mst.Face{{Vertex: [3]uint32{0, 1, 2}}, {Vertex: [3]uint32{0, 2, 3}}},
+			}},
+		}
+	}
+
+	mesh := mst.NewMesh()
+	mtl := &mst.TextureMaterial{Texture: tex}
+	mtl.Color = [3]byte{255, 255, 255}
+	mesh.Materials = append(mesh.Materials, mtl)
+	mesh.Nodes = append(mesh.Nodes, quad())
+
+	inst := &mst.InstanceMesh{Mesh: &mst.BaseMesh{
+		Materials: []mst.MeshMaterial{&mst.BaseMaterial{Color: [3]byte{255, 0, 0}}},
+		Nodes:     []*mst.MeshNode{quad()},
+	}}
+	for _, x := range []float64{2, 4} {
+		mat := mat4d.Ident
+		mat.SetTranslation(&vec3d.T{x, 0, 0})
+		inst.Transfors = append(inst.Transfors, &mat)
+	}
+	mesh.Instances = append(mesh.Instances, inst)
+	return mesh
+}
+
+func TestMstToStl_Binary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quads.stl")
+	if err := (&MstToStl{}).Export(exportTestMesh(), path); err != nil {
+		t.Fatalf("导出失败: %v", err)
+	}
+
+	cv := NewStlToMst()
+	mesh, bbox, err := cv.Convert(path)
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	if cv.Report.Triangles != 6 {
+		t.Errorf("期望6个三角形，实际%d个", cv.Report.Triangles)
+	}
+	if bbox[0] != 0 || bbox[3] != 5 || bbox[4] != 1 {
+		t.Errorf("包围盒错误: %v", bbox)
+	}
+	// 白色材质和红色实例材质按面颜色分组
+	colors := make(map[[3]byte]bool)
+	for _, m := range mesh.Materials {
+		colors[m.GetColor()] = true
+	}
+	if !colors[[3]byte{255, 255, 255}] || !colors[[3]byte{255, 0, 0}] {
+		t.Errorf("面颜色丢失: %v", colors)
+	}
+}
+
+func TestMstToStl_Ascii(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quads.stl")
+	if err := (&MstToStl{Ascii: true}).Export(exportTestMesh(), path); err != nil {
+		t.Fatalf("导出失败: %v", err)
+	}
+
+	cv := NewStlToMst()
+	mesh, _, err := cv.Convert(path)
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	if len(mesh.Nodes) != 3 {
+		t.Fatalf("期望3个solid，实际%d个", len(mesh.Nodes))
+	}
+	names := []string{"node_0", "instance_0_0", "instance_0_1"}
+	for i, nd := range mesh.Nodes {
+		if cv.NodeNames[nd] != names[i] {
+			t.Errorf("节点%d名称为%q，期望%q", i, cv.NodeNames[nd], names[i])
+		}
+	}
+}
+
+func TestMstToObj_Export(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "quads.obj")
+	exp := &MstToObj{}
+	if err := exp.Export(exportTestMesh(), path); err != nil {
+		t.Fatalf("导出失败: %v", err)
+	}
+	if len(exp.Diagnostics) != 0 {
+		t.Errorf("意外的诊断信息: %v", exp.Diagnostics)
+	}
+
+	// 纹理解压后写在 OBJ 旁边
+	img, err := loadImage(filepath.Join(dir, "quads_0.png"))
+	if err != nil {
+		t.Fatalf("读取纹理失败: %v", err)
+	}
+	if c := color.NRGBAModel.Convert(img.At(1, 1)).(color.NRGBA); c != (color.NRGBA{255, 255, 0, 255}) {
+		t.Errorf("纹理像素错误: %v", c)
+	}
+	mtl, err := os.ReadFile(filepath.Join(dir, "quads.mtl"))
+	if err != nil {
+		t.Fatalf("读取材质失败: %v", err)
+	}
+	if !bytes.Contains(mtl, []byte("map_Kd quads_0.png")) {
+		t.Errorf("材质中没有纹理:\n%s", mtl)
+	}
+
+	cv := &ObjToMst{}
+	mesh, bbox, err := cv.Convert(path)
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	if bbox[3] != 5 {
+		t.Errorf("包围盒错误: %v", bbox)
+	}
+	faces := 0
+	for _, nd := range mesh.Nodes {
+		for _, fg := range nd.FaceGroup {
+			faces += len(fg.Faces)
+		}
+	}
+	if faces != 6 {
+		t.Errorf("期望6个三角形，实际%d个", faces)
+	}
+	var textured, red bool
+	for _, m := range mesh.Materials {
+		textured = textured || m.HasTexture()
+		red = red || m.GetColor() == [3]byte{255, 0, 0}
+	}
+	if !textured || !red {
+		t.Errorf("材质丢失: textured=%v red=%v", textured, red)
+	}
+}
+
+func TestMstToPly_Export(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "quads.ply")
+	if err := (&MstToPly{Ascii: true}).Export(exportTestMesh(), path); err != nil {
+		t.Fatalf("导出失败: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, body, ok := strings.Cut(string(data), "end_header\n")
+	if !ok {
+		t.Fatalf("缺少文件头:\n%s", data)
+	}
+	for _, s := range []string{"format ascii 1.0", "comment TextureFile quads_0.png", "element vertex 12", "element face 6", "property uchar red", "property int texnumber"} {
+		if !strings.Contains(header, s) {
+			t.Errorf("文件头缺少 %q:\n%s", s, header)
+		}
+	}
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if len(lines) != 18 {
+		t.Fatalf("期望18行数据，实际%d行", len(lines))
+	}
+	// 第二个实例的第一个顶点平移到 x=4，颜色为实例材质的红色
+	if lines[8] != "4 0 0 0 0 1 0 0 255 0 0" {
+		t.Errorf("顶点数据错误: %q", lines[8])
+	}
+
+	bin := filepath.Join(dir, "quads_bin.ply")
+	if err := (&MstToPly{}).Export(exportTestMesh(), bin); err != nil {
+		t.Fatalf("导出失败: %v", err)
+	}
+	data, err = os.ReadFile(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, body, _ = strings.Cut(string(data), "end_header\n")
+	// 每个顶点 8 个浮点数和 3 个字节，每个面 1 个字节、3 个索引和纹理序号
+	if len(body) != 12*(8*4+3)+6*(1+4*4) {
+		t.Errorf("二进制数据长度错误: %d", len(body))
+	}
+}
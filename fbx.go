@@ -1,11 +1,10 @@
 package asset3d
 
 import (
-	"fmt"
+	"image"
 	"math"
 	"os"
 	"path/filepath"
-	"strings"
 
 	mst "github.com/flywave/go-mst"
 	mat4d "github.com/flywave/go3d/float64/mat4"
@@ -18,10 +17,47 @@ import (
 )
 
 type FbxToMst struct {
+	// UVChannel 指定写入 MeshNode.TexCoords 的 UV 层，该层为空时使用第一个非空层
+	UVChannel int
+	// Layers 记录 MST 节点无法直接承载的顶点属性（切线、全部 UV 层），与节点顶点一一对应
+	Layers map[*mst.MeshNode]*FbxVertexLayers
+	// TextureSearchDirs 在 FBX 所在目录之外查找纹理文件的目录
+	TextureSearchDirs []string
+	// Diagnostics 转换过程中的诊断信息，例如无法解析的纹理
+	Diagnostics Diagnostics
+	// Skeleton 蒙皮骨骼和动画节点组成的层级
+	Skeleton []*FbxBone
+	// Skins 节点的蒙皮权重
+	Skins map[*mst.MeshNode]*FbxSkin
+	// BlendShapes 节点的融合变形通道
+	BlendShapes map[*mst.MeshNode][]*FbxBlendShape
+	// Animations 场景中的动画栈
+	Animations []*FbxAnimation
+	// Bake 不为空时将指定动画栈某一帧的姿态烘焙到静态几何，烘焙后的节点不再输出蒙皮和融合变形
+	Bake *FbxBake
+	// PreserveHierarchy 为 true 时顶点保留在模型的局部空间，MeshNode.Mat 为模型的全局变换，
+	// 节点树记录在 Hierarchy 中；为 false 时顶点展开到世界空间
+	PreserveHierarchy bool
+	// Hierarchy 保留层级模式下的模型节点树
+	Hierarchy []*FbxNode
+
 	baseDir      string
 	texId        int
 	backup_texId int
-	texMap       map[string]int32
+	conns        *fbxConnections
+	texCache     map[*mst.Mesh]map[string]*mst.Texture
+	mtlCache     map[*mst.Mesh]map[fbxMtlKey]int32
+	imgCache     map[uint64]image.Image
+	bones        map[uint64]int
+	rest         *fbxPose
+	pose         *fbxPose
+	nodeIndex    map[uint64]int
+}
+
+// FbxVertexLayers 节点的附加顶点属性
+type FbxVertexLayers struct {
+	Tangents  []vec3.T
+	TexCoords [fbx.MaxUvs][]vec2.T
 }
 
 func (cv *FbxToMst) Convert(path string) (*mst.Mesh, *[6]float64, error) {
@@ -36,7 +72,30 @@ func (cv *FbxToMst) Convert(path string) (*mst.Mesh, *[6]float64, error) {
 	if er != nil {
 		return nil, nil, er
 	}
-	cv.texMap = map[string]int32{}
+	cv.conns = newFbxConnections(scene)
+	cv.texCache = make(map[*mst.Mesh]map[string]*mst.Texture)
+	cv.mtlCache = make(map[*mst.Mesh]map[fbxMtlKey]int32)
+	cv.imgCache = make(map[uint64]image.Image)
+	cv.Layers = make(map[*mst.MeshNode]*FbxVertexLayers)
+	cv.Diagnostics = nil
+	cv.Skeleton = nil
+	cv.bones = make(map[uint64]int)
+	cv.Skins = make(map[*mst.MeshNode]*FbxSkin)
+	cv.BlendShapes = make(map[*mst.MeshNode][]*FbxBlendShape)
+	cv.rest = newFbxPose(cv.conns, nil, 0)
+	cv.Animations = cv.convertAnimations(scene)
+	cv.pose = nil
+	if cv.Bake != nil {
+		cv.pose = cv.bakePose(scene)
+	}
+	cv.Hierarchy = nil
+	if cv.PreserveHierarchy {
+		pose := cv.pose
+		if pose == nil {
+			pose = cv.rest
+		}
+		cv.Hierarchy = cv.convertHierarchy(pose)
+	}
 	cv.baseDir = filepath.Dir(path)
 	isInstance := make(map[uint64]bool)
 	instMp := make(map[uint64]*mst.InstanceMesh)
@@ -82,37 +141,35 @@ func (cv *FbxToMst) convertMesh(mstMh *mst.Mesh, mh *fbx.Mesh) *vec3d.Box {
 	mhNode := &mst.MeshNode{}
 	bbx := vec3d.MinBox
 	g := mh.Geometry
-	if strings.HasPrefix(mh.Name(), "Cylinder67") {
-		fmt.Println(mh.Name())
+	matrix := mat4d.Ident
+	hnode := cv.hierarchyNode(mh.ID())
+	if hnode == nil {
+		mtx := fbx.GetGlobalMatrix(mh)
+		matrix = mat4d.FromArray(mtx.ToArray())
 	}
-	mtx := fbx.GetGlobalMatrix(mh)
-	mary := mtx.ToArray()
-	matrix := mat4d.FromArray(mary)
+	// 法线和切线使用逆转置矩阵变换
+	nmlMatrix := matrix.Inverted()
+	nmlMatrix.Transpose()
 
-	repete := false
-	batchs := g.Materials
-
-	if g.UVs[0] != nil {
-		for _, v := range g.UVs[0] {
-			mhNode.TexCoords = append(mhNode.TexCoords, vec2.T{float32(v[0]), float32(v[1])})
-			repete = repete || v[0] > 1.1 || v[1] > 1.1 || v[0] < 0 || v[1] < 0
-		}
-	}
+	layers := newFbxLayers(g)
+	uvChannel := layers.uvChannel(cv.UVChannel)
+	repete := layers.repeated(uvChannel)
+	batchs := fbxFaceMaterials(g)
 
 	fgMap := make(map[int32]*mst.MeshTriangle)
 	mtlMp := make(map[int]int32)
-	if len(batchs) == 0 {
-		batchs = make([]int, len(g.Faces))
-	}
+	vtMap := make(map[fbxVertexKey]uint32)
+	extra := &FbxVertexLayers{}
+	var cps []int
 
-	vertexOffset := 0
-	for i := 0; i < len(batchs); i++ {
+	pv := 0
+	for i, face := range g.Faces {
 		batchId := batchs[i]
 		bid, ok := mtlMp[batchId]
 		var gp *mst.MeshTriangle
 		var mt *fbx.Material
 
-		if len(mh.Materials) > batchId {
+		if batchId >= 0 && len(mh.Materials) > batchId {
 			mt = mh.Materials[batchId]
 		}
 		if !ok {
@@ -125,47 +182,262 @@ func (cv *FbxToMst) convertMesh(mstMh *mst.Mesh, mh *fbx.Mesh) *vec3d.Box {
 			gp = fgMap[bid]
 		}
 
-		face := g.Faces[i]
-		count := len(face)
-
-		newFaces := [][]int{face}
-		if count != 3 {
-			var tris [][]int
-			switch count {
-			case 4:
-				pts := []*vec3d.T{}
-				for _, f := range face {
-					v := g.Vertices[f]
-					pt := &vec3d.T{float64(v[0]), float64(v[1]), float64(v[2])}
-					pts = append(pts, pt)
+		for _, tri := range triangulateFbxFace(face, g) {
+			var fc [3]uint32
+			for k, c := range tri {
+				key := layers.key(face[c], pv+c, i)
+				idx, ok := vtMap[key]
+				if !ok {
+					idx = uint32(len(mhNode.Vertices))
+					vtMap[key] = idx
+					cps = append(cps, key.cp)
+
+					vt := key.position(g)
+					dvt := matrix.MulVec3(&vt)
+					mhNode.Vertices = append(mhNode.Vertices, vec3.T{float32(dvt[0]), float32(dvt[1]), float32(dvt[2])})
+					bbx.Extend(&dvt)
+
+					if layers.hasNormals {
+						mhNode.Normals = append(mhNode.Normals, transformDirection(&nmlMatrix, key.normal))
+					}
+					if layers.hasColors {
+						mhNode.Colors = append(mhNode.Colors, [3]byte{floatToByte(key.color[0]), floatToByte(key.color[1]), floatToByte(key.color[2])})
+					}
+					if layers.hasTangents {
+						extra.Tangents = append(extra.Tangents, transformDirection(&matrix, key.tangent))
+					}
+					for ch := range key.uvs {
+						if !layers.hasUVs[ch] {
+							continue
+						}
+						uv := vec2.T{float32(key.uvs[ch][0]), float32(key.uvs[ch][1])}
+						extra.TexCoords[ch] = append(extra.TexCoords[ch], uv)
+						if ch == uvChannel {
+							mhNode.TexCoords = append(mhNode.TexCoords, uv)
+						}
+					}
 				}
-				tris = quadToTriangles(face, pts)
-			case 5:
-				tris = pentagonToTriangles(face)
+				fc[k] = idx
 			}
-			newFaces = tris
+			gp.Faces = append(gp.Faces, &mst.Face{Vertex: fc})
 		}
+		pv += len(face)
+	}
 
-		for _, fc := range newFaces {
-			for _, f := range fc {
-				vt := g.Vertices[f]
-				dvt := matrix.MulVec3(&vec3d.T{float64(vt[0]), float64(vt[1]), float64(vt[2])})
-				mhNode.Vertices = append(mhNode.Vertices, vec3.T{float32(dvt[0]), float32(dvt[1]), float32(dvt[2])})
-				bbx.Extend((*vec3d.T)(&dvt))
-			}
-			baseIdx := uint32(vertexOffset)
-			gp.Faces = append(gp.Faces, &mst.Face{
-				Vertex: [3]uint32{baseIdx, baseIdx + 1, baseIdx + 2},
-			})
-			vertexOffset += 3
+	skin := cv.convertSkin(mh, cps, &matrix)
+	shapes := cv.convertBlendShapes(mh, cps, &matrix)
+	if cv.pose != nil {
+		cv.bakeNode(cv.pose, mh, mhNode, extra, skin, shapes)
+		bbx = vec3d.MinBox
+		for _, v := range mhNode.Vertices {
+			bbx.Extend(&vec3d.T{float64(v[0]), float64(v[1]), float64(v[2])})
+		}
+	} else {
+		if skin != nil {
+			cv.Skins[mhNode] = skin
+		}
+		if len(shapes) > 0 {
+			cv.BlendShapes[mhNode] = shapes
 		}
 	}
 
-	mhNode.ReComputeNormal()
+	// 没有导入法线时才生成法线，保留 DCC 工具中设置的软硬边
+	if !layers.hasNormals {
+		mhNode.ReComputeNormal()
+	}
 	mstMh.Nodes = append(mstMh.Nodes, mhNode)
+	cv.Layers[mhNode] = extra
+	if hnode != nil {
+		global := hnode.Global
+		mhNode.Mat = &global
+		hnode.Meshes = append(hnode.Meshes, mhNode)
+		if len(mhNode.Vertices) > 0 {
+			return transformBox(&bbx, &global)
+		}
+	}
 	return &bbx
 }
 
+// fbxLayers 将 ofbx 展开后的图层数据映射回控制点、多边形顶点或多边形
+type fbxLayers struct {
+	g           *fbx.Geometry
+	polyVerts   int
+	hasNormals  bool
+	hasTangents bool
+	hasColors   bool
+	hasUVs      [fbx.MaxUvs]bool
+}
+
+type fbxVertexKey struct {
+	cp      int
+	normal  [3]float64
+	tangent [3]float64
+	color   [4]float64
+	uvs     [fbx.MaxUvs][2]float64
+}
+
+func newFbxLayers(g *fbx.Geometry) *fbxLayers {
+	l := &fbxLayers{g: g}
+	for _, f := range g.Faces {
+		l.polyVerts += len(f)
+	}
+	l.hasNormals = l.mapping(len(g.Normals)) != fbxMappingNone
+	l.hasTangents = l.mapping(len(g.Tangents)) != fbxMappingNone
+	l.hasColors = l.mapping(len(g.Colors)) != fbxMappingNone
+	for ch := range g.UVs {
+		l.hasUVs[ch] = l.mapping(len(g.UVs[ch])) != fbxMappingNone
+	}
+	return l
+}
+
+const (
+	fbxMappingNone = iota
+	fbxMappingPolygonVertex
+	fbxMappingVertex
+	fbxMappingPolygon
+	fbxMappingAllSame
+)
+
+// mapping 根据图层长度推断映射方式，ofbx 已经按 IndexToDirect/Direct 展开了引用
+func (l *fbxLayers) mapping(n int) int {
+	switch {
+	case n == 0:
+		return fbxMappingNone
+	case n >= l.polyVerts:
+		return fbxMappingPolygonVertex
+	case n == len(l.g.Vertices):
+		return fbxMappingVertex
+	case n == len(l.g.Faces):
+		return fbxMappingPolygon
+	case n == 1:
+		return fbxMappingAllSame
+	}
+	return fbxMappingNone
+}
+
+func (l *fbxLayers) index(n, cp, pv, poly int) int {
+	switch l.mapping(n) {
+	case fbxMappingPolygonVertex:
+		return pv
+	case fbxMappingVertex:
+		return cp
+	case fbxMappingPolygon:
+		return poly
+	case fbxMappingAllSame:
+		return 0
+	}
+	return -1
+}
+
+func (l *fbxLayers) key(cp, pv, poly int) fbxVertexKey {
+	g := l.g
+	k := fbxVertexKey{cp: cp}
+	if i := l.index(len(g.Normals), cp, pv, poly); i >= 0 {
+		k.normal = g.Normals[i]
+	}
+	if i := l.index(len(g.Tangents), cp, pv, poly); i >= 0 {
+		k.tangent = g.Tangents[i]
+	}
+	if i := l.index(len(g.Colors), cp, pv, poly); i >= 0 {
+		k.color = g.Colors[i]
+	}
+	for ch := range g.UVs {
+		if i := l.index(len(g.UVs[ch]), cp, pv, poly); i >= 0 {
+			k.uvs[ch] = g.UVs[ch][i]
+		}
+	}
+	return k
+}
+
+func (l *fbxLayers) uvChannel(ch int) int {
+	if ch >= 0 && ch < fbx.MaxUvs && l.hasUVs[ch] {
+		return ch
+	}
+	for i, ok := range l.hasUVs {
+		if ok {
+			return i
+		}
+	}
+	return -1
+}
+
+func (l *fbxLayers) repeated(ch int) bool {
+	if ch < 0 {
+		return false
+	}
+	for _, v := range l.g.UVs[ch] {
+		if v[0] > 1.1 || v[1] > 1.1 || v[0] < 0 || v[1] < 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *fbxVertexKey) position(g *fbx.Geometry) vec3d.T {
+	v := g.Vertices[k.cp]
+	return vec3d.T{v[0], v[1], v[2]}
+}
+
+// fbxFaceMaterials 返回每个多边形的材质索引，ofbx 的 Materials 按三角化后的三角形存储
+func fbxFaceMaterials(g *fbx.Geometry) []int {
+	mtls := make([]int, len(g.Faces))
+	if len(g.Materials) == 0 {
+		return mtls
+	}
+	if len(g.Materials) == len(g.Faces) {
+		copy(mtls, g.Materials)
+		return mtls
+	}
+	tri := 0
+	for i, f := range g.Faces {
+		if tri < len(g.Materials) {
+			mtls[i] = g.Materials[tri]
+		}
+		if len(f) > 2 {
+			tri += len(f) - 2
+		}
+	}
+	return mtls
+}
+
+// triangulateFbxFace 返回多边形内的局部角点索引
+func triangulateFbxFace(face []int, g *fbx.Geometry) [][]int {
+	corners := make([]int, len(face))
+	for i := range corners {
+		corners[i] = i
+	}
+	switch len(face) {
+	case 0, 1, 2:
+		return nil
+	case 3:
+		return [][]int{corners}
+	case 4:
+		pts := []*vec3d.T{}
+		for _, f := range face {
+			v := g.Vertices[f]
+			pts = append(pts, &vec3d.T{v[0], v[1], v[2]})
+		}
+		return quadToTriangles(corners, pts)
+	case 5:
+		return pentagonToTriangles(corners)
+	}
+	var tris [][]int
+	for i := 1; i < len(corners)-1; i++ {
+		tris = append(tris, []int{0, i, i + 1})
+	}
+	return tris
+}
+
+func transformDirection(mat *mat4d.T, d [3]float64) vec3.T {
+	v := mat.MulVec3W(&vec3d.T{d[0], d[1], d[2]}, 0)
+	v.Normalize()
+	return vec3.T{float32(v[0]), float32(v[1]), float32(v[2])}
+}
+
+func floatToByte(v float64) byte {
+	return byte(math.Max(0, math.Min(1, v)) * 255)
+}
+
 func pentagonToTriangles(pent []int) [][]int {
 	return [][]int{
 		{pent[0], pent[1], pent[2]}, // 三角形1
@@ -202,63 +474,5 @@ func distance(a, b *vec3d.T) float64 {
 	return math.Sqrt(dx*dx + dy*dy + dz*dz)
 }
 
-func (cv *FbxToMst) convertMaterial(mstMh *mst.Mesh, mt *fbx.Material, repete bool) int32 {
-	idx := int32(len(mstMh.Materials))
-	mtl := &mst.PbrMaterial{Metallic: 0, Roughness: 1}
-	if mt != nil {
-		if mt.Textures[0] != nil {
-			str := strings.ReplaceAll(mt.Textures[0].GetRelativeFileName().String(), "\\", "/")
-			_, fileName := filepath.Split(str)
-			f := filepath.Join(cv.baseDir, fileName)
-
-			if midx, ok := cv.texMap[f]; ok {
-				return midx
-			}
-
-			tex, err := convertTex(f, cv.texId)
-			if err != nil {
-				return 0
-			}
-			tex.Repeated = repete
-			mtl.Texture = tex
-			cv.texId++
-			cv.texMap[f] = idx
-		}
-
-		if mt.Textures[1] != nil {
-			str := strings.ReplaceAll(mt.Textures[1].GetRelativeFileName().String(), "\\", "/")
-			_, fileName := filepath.Split(str)
-			f := filepath.Join(cv.baseDir, fileName)
-
-			if midx, ok := cv.texMap[f]; ok {
-				return midx
-			}
-
-			tex, err := convertTex(f, cv.texId)
-			if err != nil {
-				return 0
-			}
-			tex.Repeated = repete
-			mtl.Normal = tex
-			cv.texId++
-			cv.texMap[f] = idx
-		}
-
-		cl := mt.EmissiveColor
-		mtl.Emissive[0] = byte(cl.R * 255)
-		mtl.Emissive[1] = byte(cl.G * 255)
-		mtl.Emissive[2] = byte(cl.B * 255)
-
-		cl = mt.DiffuseColor
-		mtl.Color[0] = byte(cl.R * 255)
-		mtl.Color[1] = byte(cl.G * 255)
-		mtl.Color[2] = byte(cl.B * 255)
-	} else {
-		mtl.Color = [3]byte{255, 255, 255}
-	}
-	mstMh.Materials = append(mstMh.Materials, mtl)
-	return idx
-}
-
 // Ensure FbxToMst implements FormatConvert interface
 var _ FormatConvert = (*FbxToMst)(nil)
@@ -0,0 +1,679 @@
+package asset3d
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	mst "github.com/flywave/go-mst"
+	mat4d "github.com/flywave/go3d/float64/mat4"
+	vec3d "github.com/flywave/go3d/float64/vec3"
+	"github.com/flywave/go3d/vec3"
+
+	fbx "github.com/flywave/ofbx"
+)
+
+// FbxMaxInfluences 每个顶点保留的骨骼影响数
+const FbxMaxInfluences = 4
+
+const (
+	fbxDeformPercent    = "DeformPercent"
+	fbxDefaultFrameRate = 30.0
+	fbxTicksPerSecond   = 46186158000.0
+)
+
+// FbxBone 蒙皮骨骼和动画节点组成的层级中的一个节点，父节点总是排在子节点之前
+type FbxBone struct {
+	Id     uint64
+	Name   string
+	Parent int     // 父节点在 Skeleton 中的索引，根节点为 -1
+	Local  mat4d.T // 静止姿态的局部变换
+	Global mat4d.T // 静止姿态的全局变换
+}
+
+// FbxSkin 节点的蒙皮，Joints 为 Bones 中的下标，与节点顶点一一对应
+type FbxSkin struct {
+	Bones       []int     // 骨骼在 Skeleton 中的索引
+	InverseBind []mat4d.T // 将节点顶点变换到各骨骼绑定空间的矩阵
+	Joints      [][FbxMaxInfluences]uint16
+	Weights     [][FbxMaxInfluences]float32
+}
+
+// FbxBlendShape 融合变形通道，Targets 按 FullWeight 升序排列，多个目标时为中间帧
+type FbxBlendShape struct {
+	Id            uint64
+	Name          string
+	DeformPercent float64
+	Targets       []*FbxShapeTarget
+}
+
+// FbxShapeTarget 融合变形目标，Offsets 为相对节点顶点的位移
+type FbxShapeTarget struct {
+	Name       string
+	FullWeight float64
+	Offsets    []vec3.T
+}
+
+// FbxAnimation 动画栈，时间单位为秒
+type FbxAnimation struct {
+	Name        string
+	Start, Stop float64
+	Channels    []*FbxAnimationChannel
+}
+
+// FbxAnimationChannel 作用在一个对象属性上的曲线，Property 为 Lcl Translation、Lcl Rotation、
+// Lcl Scaling 或 DeformPercent，Bone 为目标在 Skeleton 中的索引，目标不是节点时为 -1
+type FbxAnimationChannel struct {
+	Target   uint64
+	Bone     int
+	Property string
+	Curves   [3]*FbxCurve
+}
+
+// FbxCurve 关键帧曲线，关键帧之间线性插值
+type FbxCurve struct {
+	Times  []float64
+	Values []float64
+}
+
+// FbxBake 烘焙选项，Frame 按场景帧率换算为动画栈内的时间
+type FbxBake struct {
+	Stack string // 动画栈名称，为空时使用第一个动画栈
+	Frame float64
+}
+
+// Eval 返回时刻 t 的值，超出范围时取首尾关键帧
+func (c *FbxCurve) Eval(t float64) float64 {
+	n := len(c.Times)
+	if n == 0 {
+		return 0
+	}
+	if t <= c.Times[0] {
+		return c.Values[0]
+	}
+	if t >= c.Times[n-1] {
+		return c.Values[n-1]
+	}
+	i := sort.SearchFloat64s(c.Times, t)
+	t0, t1 := c.Times[i-1], c.Times[i]
+	if t1 <= t0 {
+		return c.Values[i]
+	}
+	f := (t - t0) / (t1 - t0)
+	return c.Values[i-1]*(1-f) + c.Values[i]*f
+}
+
+// Offset 返回权重 w（百分比）下第 i 个顶点的位移，相邻中间帧之间线性插值
+func (bs *FbxBlendShape) Offset(i int, w float64) vec3.T {
+	n := len(bs.Targets)
+	if n == 0 || w == 0 {
+		return vec3.T{}
+	}
+	k := sort.Search(n, func(j int) bool { return bs.Targets[j].FullWeight >= w })
+	var prev, next *FbxShapeTarget
+	prevWeight, f := 0.0, 0.0
+	switch {
+	case k == 0:
+		next = bs.Targets[0]
+	case k == n:
+		prev = bs.Targets[n-1]
+		prevWeight = prev.FullWeight
+	default:
+		prev, next = bs.Targets[k-1], bs.Targets[k]
+		prevWeight = prev.FullWeight
+	}
+	if next == nil {
+		// 超过最后一个目标时按比例外推
+		return prev.Offsets[i].Scaled(float32(w / prevWeight))
+	}
+	if next.FullWeight > prevWeight {
+		f = (w - prevWeight) / (next.FullWeight - prevWeight)
+	}
+	res := next.Offsets[i].Scaled(float32(f))
+	if prev != nil {
+		d := prev.Offsets[i].Scaled(float32(1 - f))
+		res.Add(&d)
+	}
+	return res
+}
+
+type fbxChannelKey struct {
+	target   uint64
+	property string
+}
+
+// convertAnimations 转换动画栈，多个动画层作用于同一属性时只取第一层
+func (cv *FbxToMst) convertAnimations(scene *fbx.Scene) []*FbxAnimation {
+	var anims []*FbxAnimation
+	for _, st := range scene.AnimationStacks {
+		props := newFbxProps(st)
+		anim := &FbxAnimation{
+			Name:  fbxObjectName(st.Element()),
+			Start: props.float(0, "LocalStart") / fbxTicksPerSecond,
+			Stop:  props.float(0, "LocalStop") / fbxTicksPerSecond,
+		}
+		seen := make(map[fbxChannelKey]bool)
+		for _, layer := range st.Layers {
+			for _, cn := range layer.CurveNodes {
+				ch := cv.convertChannel(scene, cn)
+				if ch == nil {
+					continue
+				}
+				key := fbxChannelKey{target: ch.Target, property: ch.Property}
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				anim.Channels = append(anim.Channels, ch)
+			}
+		}
+		if anim.Stop <= anim.Start {
+			anim.Start, anim.Stop = anim.timeRange()
+		}
+		anims = append(anims, anim)
+	}
+	return anims
+}
+
+func (cv *FbxToMst) convertChannel(scene *fbx.Scene, cn *fbx.AnimationCurveNode) *FbxAnimationChannel {
+	var ch *FbxAnimationChannel
+	for _, t := range cv.conns.targets[cn.ID()] {
+		if t.property != "" {
+			ch = &FbxAnimationChannel{Target: t.to, Bone: -1, Property: t.property}
+			break
+		}
+	}
+	if ch == nil {
+		return nil
+	}
+	if e, ok := cv.conns.objects[ch.Target]; ok && fbxElementId(e) == "Model" {
+		ch.Bone = cv.boneIndex(ch.Target)
+	}
+
+	empty := true
+	for _, lk := range cv.conns.links[cn.ID()] {
+		curve, ok := scene.ObjectMap[lk.from].(*fbx.AnimationCurve)
+		if !ok || len(curve.Values) == 0 {
+			continue
+		}
+		axis := fbxCurveAxis(lk.property)
+		if axis < 0 || ch.Curves[axis] != nil {
+			continue
+		}
+		// ofbx 转换时间时截断到微秒，从原始的 KeyTime 换算秒数
+		times := fbxChildArray(curve.Element(), "KeyTime")
+		if len(times) != len(curve.Values) {
+			continue
+		}
+		c := &FbxCurve{Times: make([]float64, len(times)), Values: make([]float64, len(curve.Values))}
+		for i, t := range times {
+			c.Times[i] = t / fbxTicksPerSecond
+			c.Values[i] = float64(curve.Values[i])
+		}
+		ch.Curves[axis] = c
+		empty = false
+	}
+	if empty {
+		return nil
+	}
+	return ch
+}
+
+func fbxCurveAxis(property string) int {
+	switch property {
+	case "d|X", "d|" + fbxDeformPercent:
+		return 0
+	case "d|Y":
+		return 1
+	case "d|Z":
+		return 2
+	}
+	return -1
+}
+
+func (anim *FbxAnimation) timeRange() (float64, float64) {
+	start, stop := math.Inf(1), math.Inf(-1)
+	for _, ch := range anim.Channels {
+		for _, c := range ch.Curves {
+			if c == nil {
+				continue
+			}
+			start = math.Min(start, c.Times[0])
+			stop = math.Max(stop, c.Times[len(c.Times)-1])
+		}
+	}
+	if start > stop {
+		return 0, 0
+	}
+	return start, stop
+}
+
+// bakePose 返回烘焙选项对应的姿态，找不到动画栈时记录诊断信息
+func (cv *FbxToMst) bakePose(scene *fbx.Scene) *fbxPose {
+	var anim *FbxAnimation
+	for _, a := range cv.Animations {
+		if cv.Bake.Stack == "" || a.Name == cv.Bake.Stack {
+			anim = a
+			break
+		}
+	}
+	if anim == nil {
+		cv.Diagnostics.Warnf("fbx: animation stack %q not found, geometry is not baked", cv.Bake.Stack)
+		return nil
+	}
+	// 默认时间模式下 ofbx 返回的帧率为 1
+	rate := float64(scene.FrameRate)
+	if rate <= 1 {
+		rate = fbxDefaultFrameRate
+	}
+	return newFbxPose(cv.conns, anim, anim.Start+cv.Bake.Frame/rate)
+}
+
+// boneIndex 返回节点在 Skeleton 中的索引，必要时连同祖先节点一起加入
+func (cv *FbxToMst) boneIndex(id uint64) int {
+	if idx, ok := cv.bones[id]; ok {
+		return idx
+	}
+	parent := -1
+	if pid, ok := cv.conns.parentModel(id); ok {
+		parent = cv.boneIndex(pid)
+	}
+	idx := len(cv.Skeleton)
+	cv.Skeleton = append(cv.Skeleton, &FbxBone{
+		Id:     id,
+		Name:   fbxObjectName(cv.conns.objects[id]),
+		Parent: parent,
+		Local:  cv.rest.local(id),
+		Global: cv.rest.global(id),
+	})
+	cv.bones[id] = idx
+	return idx
+}
+
+type fbxInfluence struct {
+	joint  int
+	weight float64
+}
+
+// convertSkin 将控制点上的簇权重映射到节点顶点，cps 为每个节点顶点对应的控制点，
+// matrix 为写入节点顶点时使用的全局变换
+func (cv *FbxToMst) convertSkin(mh *fbx.Mesh, cps []int, matrix *mat4d.T) *FbxSkin {
+	g := mh.Geometry
+	if g.Skin == nil || len(g.Skin.Clusters) == 0 {
+		return nil
+	}
+	skin := &FbxSkin{}
+	meshInv := matrix.Inverted()
+	influences := make(map[int][]fbxInfluence)
+	for _, cl := range g.Skin.Clusters {
+		// ofbx 展开后的 Indices 会把每个簇都关联到第一个顶点，直接读取原始的控制点索引
+		indexes := fbxChildArray(cl.Element(), "Indexes")
+		weights := fbxChildArray(cl.Element(), "Weights")
+		if cl.Link == nil || len(indexes) == 0 || len(indexes) != len(weights) {
+			continue
+		}
+		joint := len(skin.Bones)
+		skin.Bones = append(skin.Bones, cv.boneIndex(cl.Link.ID()))
+		// 节点顶点 = matrix * 局部顶点，绑定时骨骼空间的顶点 = TransformLink⁻¹ * Transform * 局部顶点
+		link := fbxMatrix(cl.TransformLink).Inverted()
+		ib := mulMat4(mulMat4(link, fbxMatrix(cl.Transform)), meshInv)
+		skin.InverseBind = append(skin.InverseBind, ib)
+
+		for i, idx := range indexes {
+			cp := int(idx)
+			influences[cp] = append(influences[cp], fbxInfluence{joint: joint, weight: weights[i]})
+		}
+	}
+	if len(skin.Bones) == 0 {
+		return nil
+	}
+
+	skin.Joints = make([][FbxMaxInfluences]uint16, len(cps))
+	skin.Weights = make([][FbxMaxInfluences]float32, len(cps))
+	for i, cp := range cps {
+		inf := influences[cp]
+		sort.SliceStable(inf, func(a, b int) bool { return inf[a].weight > inf[b].weight })
+		if len(inf) > FbxMaxInfluences {
+			inf = inf[:FbxMaxInfluences]
+		}
+		total := 0.0
+		for _, it := range inf {
+			total += it.weight
+		}
+		if total <= 0 {
+			continue
+		}
+		for k, it := range inf {
+			skin.Joints[i][k] = uint16(it.joint)
+			skin.Weights[i][k] = float32(it.weight / total)
+		}
+	}
+	return skin
+}
+
+// convertBlendShapes 读取几何体上 BlendShape → BlendShapeChannel → Shape 的连接，
+// 位移按 matrix 的线性部分变换到节点空间
+func (cv *FbxToMst) convertBlendShapes(mh *fbx.Mesh, cps []int, matrix *mat4d.T) []*FbxBlendShape {
+	var shapes []*FbxBlendShape
+	for _, deformer := range cv.conns.links[mh.Geometry.ID()] {
+		if !cv.conns.isClass(deformer.from, "Deformer", "BlendShape") {
+			continue
+		}
+		for _, channel := range cv.conns.links[deformer.from] {
+			if !cv.conns.isClass(channel.from, "Deformer", "BlendShapeChannel") {
+				continue
+			}
+			e := cv.conns.objects[channel.from]
+			bs := &FbxBlendShape{
+				Id:            channel.from,
+				Name:          fbxObjectName(e),
+				DeformPercent: fbxElementProps(e).float(0, fbxDeformPercent),
+			}
+			fullWeights := fbxChildArray(e, "FullWeights")
+			for _, shape := range cv.conns.links[channel.from] {
+				if !cv.conns.isClass(shape.from, "Geometry", "Shape") {
+					continue
+				}
+				se := cv.conns.objects[shape.from]
+				target := &FbxShapeTarget{Name: fbxObjectName(se), FullWeight: 100}
+				if i := len(bs.Targets); i < len(fullWeights) {
+					target.FullWeight = fullWeights[i]
+				}
+				target.Offsets = fbxShapeOffsets(se, cps, matrix)
+				bs.Targets = append(bs.Targets, target)
+			}
+			if len(bs.Targets) == 0 {
+				continue
+			}
+			sort.SliceStable(bs.Targets, func(a, b int) bool {
+				return bs.Targets[a].FullWeight < bs.Targets[b].FullWeight
+			})
+			shapes = append(shapes, bs)
+		}
+	}
+	return shapes
+}
+
+func fbxShapeOffsets(e *fbx.Element, cps []int, matrix *mat4d.T) []vec3.T {
+	indexes := fbxChildArray(e, "Indexes")
+	deltas := fbxChildArray(e, "Vertices")
+	cpOffsets := make(map[int]vec3.T, len(indexes))
+	for i, idx := range indexes {
+		if 3*i+2 >= len(deltas) {
+			break
+		}
+		d := vec3d.T{deltas[3*i], deltas[3*i+1], deltas[3*i+2]}
+		d = matrix.MulVec3W(&d, 0)
+		cpOffsets[int(idx)] = vec3.T{float32(d[0]), float32(d[1]), float32(d[2])}
+	}
+	offsets := make([]vec3.T, len(cps))
+	for i, cp := range cps {
+		offsets[i] = cpOffsets[cp]
+	}
+	return offsets
+}
+
+// bakeNode 按烘焙姿态变形节点：先叠加融合变形，再做蒙皮，没有蒙皮时使用节点自身的动画变换。
+// 保留层级时节点自身的动画体现在 Hierarchy 的变换中，蒙皮结果再变换回节点局部空间
+func (cv *FbxToMst) bakeNode(pose *fbxPose, mh *fbx.Mesh, node *mst.MeshNode, extra *FbxVertexLayers, skin *FbxSkin, shapes []*FbxBlendShape) {
+	for _, bs := range shapes {
+		w := pose.deformPercent(bs)
+		for i := range node.Vertices {
+			d := bs.Offset(i, w)
+			node.Vertices[i].Add(&d)
+		}
+	}
+
+	local := cv.hierarchyNode(mh.ID()) != nil
+	if skin == nil {
+		if local {
+			return
+		}
+		rest := cv.rest.global(mh.ID())
+		m := mulMat4(pose.global(mh.ID()), rest.Inverted())
+		for i := range node.Vertices {
+			bakeVertex(node, extra, i, &m)
+		}
+		return
+	}
+
+	toNode := mat4d.Ident
+	if local {
+		toNode = pose.global(mh.ID()).Inverted()
+	}
+	joints := make([]mat4d.T, len(skin.Bones))
+	for j, b := range skin.Bones {
+		joints[j] = mulMat4(toNode, mulMat4(pose.global(cv.Skeleton[b].Id), skin.InverseBind[j]))
+	}
+	for i := range node.Vertices {
+		var m mat4d.T
+		total := float32(0)
+		for k := 0; k < FbxMaxInfluences; k++ {
+			w := skin.Weights[i][k]
+			if w == 0 {
+				continue
+			}
+			jm := joints[skin.Joints[i][k]].Muled(float64(w))
+			fbxAddMatrix(&m, &jm)
+			total += w
+		}
+		// 没有权重的顶点保持不动
+		if total == 0 {
+			continue
+		}
+		bakeVertex(node, extra, i, &m)
+	}
+}
+
+func bakeVertex(node *mst.MeshNode, extra *FbxVertexLayers, i int, m *mat4d.T) {
+	v := node.Vertices[i]
+	dv := vec3d.T{float64(v[0]), float64(v[1]), float64(v[2])}
+	dv = m.MulVec3(&dv)
+	node.Vertices[i] = vec3.T{float32(dv[0]), float32(dv[1]), float32(dv[2])}
+	if i < len(node.Normals) {
+		nm := m.Inverted()
+		nm.Transpose()
+		n := node.Normals[i]
+		node.Normals[i] = transformDirection(&nm, [3]float64{float64(n[0]), float64(n[1]), float64(n[2])})
+	}
+	if i < len(extra.Tangents) {
+		t := extra.Tangents[i]
+		extra.Tangents[i] = transformDirection(m, [3]float64{float64(t[0]), float64(t[1]), float64(t[2])})
+	}
+}
+
+// fbxPose 计算节点在动画某一时刻的变换，anim 为空时为静止姿态
+type fbxPose struct {
+	conns    *fbxConnections
+	channels map[fbxChannelKey]*FbxAnimationChannel
+	time     float64
+	globals  map[uint64]mat4d.T
+}
+
+func newFbxPose(conns *fbxConnections, anim *FbxAnimation, t float64) *fbxPose {
+	p := &fbxPose{
+		conns:    conns,
+		channels: make(map[fbxChannelKey]*FbxAnimationChannel),
+		time:     t,
+		globals:  make(map[uint64]mat4d.T),
+	}
+	if anim != nil {
+		for _, ch := range anim.Channels {
+			p.channels[fbxChannelKey{target: ch.Target, property: ch.Property}] = ch
+		}
+	}
+	return p
+}
+
+func (p *fbxPose) global(id uint64) mat4d.T {
+	if m, ok := p.globals[id]; ok {
+		return m
+	}
+	m := p.local(id)
+	if pid, ok := p.conns.parentModel(id); ok {
+		m = mulMat4(p.global(pid), m)
+	}
+	p.globals[id] = m
+	return m
+}
+
+// local 按 FBX SDK 的顺序计算局部变换：
+// T * Roff * Rp * Rpre * R * Rpost⁻¹ * Rp⁻¹ * Soff * Sp * S * Sp⁻¹
+func (p *fbxPose) local(id uint64) mat4d.T {
+	props := fbxElementProps(p.conns.objects[id])
+	t := p.vec3(id, props, fbx.BoneTranslate, vec3d.T{})
+	r := p.vec3(id, props, fbx.BoneRotate, vec3d.T{})
+	s := p.vec3(id, props, fbx.BoneScale, vec3d.T{1, 1, 1})
+	order := int(props.float(0, "RotationOrder"))
+
+	rp := p.vec3(id, props, "RotationPivot", vec3d.T{})
+	sp := p.vec3(id, props, "ScalingPivot", vec3d.T{})
+	rpost := fbxEulerMatrix(p.vec3(id, props, "PostRotation", vec3d.T{}), 0)
+
+	ms := []mat4d.T{
+		fbxTranslation(t),
+		fbxTranslation(p.vec3(id, props, "RotationOffset", vec3d.T{})),
+		fbxTranslation(rp),
+		fbxEulerMatrix(p.vec3(id, props, "PreRotation", vec3d.T{}), 0),
+		fbxEulerMatrix(r, order),
+		rpost.Inverted(),
+		fbxTranslation(rp.Scaled(-1)),
+		fbxTranslation(p.vec3(id, props, "ScalingOffset", vec3d.T{})),
+		fbxTranslation(sp),
+		fbxScaling(s),
+		fbxTranslation(sp.Scaled(-1)),
+	}
+	m := mat4d.Ident
+	for i := range ms {
+		m = mulMat4(m, ms[i])
+	}
+	return m
+}
+
+func (p *fbxPose) vec3(id uint64, props fbxProps, name string, def vec3d.T) vec3d.T {
+	v := def
+	if vs := props.values(name); len(vs) >= 3 {
+		v = vec3d.T{vs[0], vs[1], vs[2]}
+	}
+	if ch, ok := p.channels[fbxChannelKey{target: id, property: name}]; ok {
+		for i, c := range ch.Curves {
+			if c != nil {
+				v[i] = c.Eval(p.time)
+			}
+		}
+	}
+	return v
+}
+
+func (p *fbxPose) deformPercent(bs *FbxBlendShape) float64 {
+	if ch, ok := p.channels[fbxChannelKey{target: bs.Id, property: fbxDeformPercent}]; ok && ch.Curves[0] != nil {
+		return ch.Curves[0].Eval(p.time)
+	}
+	return bs.DeformPercent
+}
+
+// parentModel 返回节点的父节点，父节点为场景根时返回 false
+func (c *fbxConnections) parentModel(id uint64) (uint64, bool) {
+	for _, t := range c.targets[id] {
+		if t.property != "" {
+			continue
+		}
+		if e, ok := c.objects[t.to]; ok && fbxElementId(e) == "Model" {
+			return t.to, true
+		}
+	}
+	return 0, false
+}
+
+func (c *fbxConnections) isClass(id uint64, typ, class string) bool {
+	e, ok := c.objects[id]
+	if !ok || fbxElementId(e) != typ || len(e.Properties) < 3 {
+		return false
+	}
+	return e.Properties[2].String() == class
+}
+
+// fbxObjectName 去掉二进制 FBX 名称中 "\x00\x01Class" 的后缀和文本格式的 "Class::" 前缀
+func fbxObjectName(e *fbx.Element) string {
+	if e == nil || len(e.Properties) < 2 {
+		return ""
+	}
+	name := e.Properties[1].String()
+	if i := strings.IndexByte(name, 0); i >= 0 {
+		return name[:i]
+	}
+	if i := strings.Index(name, "::"); i >= 0 {
+		return name[i+2:]
+	}
+	return name
+}
+
+// fbxChildArray 读取子节点中的数组属性
+func fbxChildArray(e *fbx.Element, id string) []float64 {
+	for _, ch := range e.Children {
+		if fbxElementId(ch) == id && len(ch.Properties) > 0 {
+			return fbxPropertyValues(ch.Properties[0])
+		}
+	}
+	return nil
+}
+
+func fbxMatrix(m fbx.Matrix) mat4d.T {
+	res := mat4d.FromArray(m.ToArray())
+	if res.IsZero() {
+		return mat4d.Ident
+	}
+	return res
+}
+
+func fbxAddMatrix(a, b *mat4d.T) {
+	for c := range a {
+		for r := range a[c] {
+			a[c][r] += b[c][r]
+		}
+	}
+}
+
+func fbxTranslation(v vec3d.T) mat4d.T {
+	m := mat4d.Ident
+	m.SetTranslation(&v)
+	return m
+}
+
+func fbxScaling(s vec3d.T) mat4d.T {
+	m := mat4d.Ident
+	m[0][0], m[1][1], m[2][2] = s[0], s[1], s[2]
+	return m
+}
+
+// fbxEulerMatrix 欧拉角（度）转旋转矩阵，XYZ 顺序表示先绕 X 轴再绕 Y、Z 轴旋转
+func fbxEulerMatrix(r vec3d.T, order int) mat4d.T {
+	axes := [3]int{0, 1, 2}
+	switch order {
+	case 1:
+		axes = [3]int{0, 2, 1}
+	case 2:
+		axes = [3]int{1, 2, 0}
+	case 3:
+		axes = [3]int{1, 0, 2}
+	case 4:
+		axes = [3]int{2, 0, 1}
+	case 5:
+		axes = [3]int{2, 1, 0}
+	}
+	m := mat4d.Ident
+	for _, a := range axes {
+		var rm mat4d.T
+		angle := r[a] * math.Pi / 180
+		switch a {
+		case 0:
+			rm.AssignXRotation(angle)
+		case 1:
+			rm.AssignYRotation(angle)
+		case 2:
+			rm.AssignZRotation(angle)
+		}
+		m = mulMat4(rm, m)
+	}
+	return m
+}
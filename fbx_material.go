@@ -0,0 +1,694 @@
+package asset3d
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"io"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	mst "github.com/flywave/go-mst"
+	fbx "github.com/flywave/ofbx"
+)
+
+// FBX 传统材质(Phong/Lambert)的纹理连接属性
+const (
+	fbxDiffuseColor       = "DiffuseColor"
+	fbxNormalMap          = "NormalMap"
+	fbxBump               = "Bump"
+	fbxTransparentColor   = "TransparentColor"
+	fbxTransparencyFactor = "TransparencyFactor"
+	fbxSpecularColor      = "SpecularColor"
+	fbxSpecularFactor     = "SpecularFactor"
+	fbxEmissiveColor      = "EmissiveColor"
+	fbxEmissiveFactor     = "EmissiveFactor"
+	fbxAmbientColor       = "AmbientColor"
+	fbxReflectionColor    = "ReflectionColor"
+	fbxReflectionFactor   = "ReflectionFactor"
+)
+
+// Stingray PBS、Arnold aiStandardSurface 和 3ds Max Physical 材质的属性名
+var (
+	fbxPbrBaseColor     = []string{"Maya|base_color", "Maya|baseColor", "3dsMax|Parameters|base_color"}
+	fbxPbrBaseWeight    = []string{"Maya|base", "3dsMax|Parameters|base_weight"}
+	fbxPbrMetallic      = []string{"Maya|metallic", "Maya|metalness", "3dsMax|Parameters|metalness"}
+	fbxPbrRoughness     = []string{"Maya|roughness", "Maya|specularRoughness", "3dsMax|Parameters|roughness"}
+	fbxPbrEmissive      = []string{"Maya|emissive", "Maya|emissionColor", "3dsMax|Parameters|emit_color"}
+	fbxPbrEmission      = []string{"Maya|emissive_intensity", "Maya|emission", "3dsMax|Parameters|emission"}
+	fbxPbrTransparency  = []string{"Maya|transmission", "3dsMax|Parameters|transparency"}
+	fbxPbrCoat          = []string{"Maya|coat", "3dsMax|Parameters|coating"}
+	fbxPbrCoatRoughness = []string{"Maya|coatRoughness", "3dsMax|Parameters|coat_roughness"}
+	fbxPbrAnisotropy    = []string{"Maya|specularAnisotropy", "3dsMax|Parameters|anisotropy"}
+	fbxPbrBaseColorMap  = []string{"Maya|TEX_color_map", "Maya|baseColor", "Maya|base_color", "3dsMax|Parameters|base_color_map", fbxDiffuseColor}
+	fbxPbrNormalMap     = []string{"Maya|TEX_normal_map", "3dsMax|Parameters|bump_map", fbxNormalMap}
+	fbxPbrOpacityMap    = []string{"Maya|opacity", "3dsMax|Parameters|cutout_map", fbxTransparentColor}
+)
+
+const (
+	fbxPbrUseColorMap   = "Maya|use_color_map"
+	fbxPbrUseNormalMap  = "Maya|use_normal_map"
+	fbxPbrRoughnessInv  = "3dsMax|Parameters|roughness_inv"
+	fbxPbrArnoldOpacity = "Maya|opacity"
+
+	fbxBumpNormalScale  = 2.0
+	fbxDefaultMetallic  = 0.0
+	fbxDefaultRoughness = 1.0
+)
+
+type fbxMtlKey struct {
+	id     uint64
+	repete bool
+}
+
+type fbxLink struct {
+	from     uint64
+	property string
+}
+
+type fbxTarget struct {
+	to       uint64
+	property string
+}
+
+// fbxConnections 索引原始的 Connections/Objects 节点，ofbx 只解析了漫反射和法线贴图连接
+type fbxConnections struct {
+	scene   *fbx.Scene
+	links   map[uint64][]fbxLink
+	targets map[uint64][]fbxTarget
+	objects map[uint64]*fbx.Element
+	models  []uint64
+}
+
+func newFbxConnections(scene *fbx.Scene) *fbxConnections {
+	c := &fbxConnections{
+		scene:   scene,
+		links:   make(map[uint64][]fbxLink),
+		targets: make(map[uint64][]fbxTarget),
+		objects: make(map[uint64]*fbx.Element),
+	}
+	if scene.RootElement == nil {
+		return c
+	}
+	for _, e := range scene.RootElement.Children {
+		switch fbxElementId(e) {
+		case "Connections":
+			for _, con := range e.Children {
+				if fbxElementId(con) != "C" || len(con.Properties) < 3 {
+					continue
+				}
+				from := fbxPropertyId(con.Properties[1])
+				to := fbxPropertyId(con.Properties[2])
+				lk := fbxLink{from: from}
+				if len(con.Properties) > 3 {
+					lk.property = con.Properties[3].String()
+				}
+				c.links[to] = append(c.links[to], lk)
+				c.targets[from] = append(c.targets[from], fbxTarget{to: to, property: lk.property})
+			}
+		case "Objects":
+			for _, obj := range e.Children {
+				if len(obj.Properties) == 0 {
+					continue
+				}
+				id := fbxPropertyId(obj.Properties[0])
+				c.objects[id] = obj
+				if fbxElementId(obj) == "Model" {
+					c.models = append(c.models, id)
+				}
+			}
+		}
+	}
+	return c
+}
+
+// textures 返回连接到对象各属性上的纹理，分层纹理取第一层
+func (c *fbxConnections) textures(id uint64) map[string]*fbx.Texture {
+	res := make(map[string]*fbx.Texture)
+	for _, lk := range c.links[id] {
+		if _, ok := res[lk.property]; ok || lk.property == "" {
+			continue
+		}
+		if tex := c.texture(lk.from); tex != nil {
+			res[lk.property] = tex
+		}
+	}
+	return res
+}
+
+func (c *fbxConnections) texture(id uint64) *fbx.Texture {
+	if tex, ok := c.scene.ObjectMap[id].(*fbx.Texture); ok {
+		return tex
+	}
+	if e, ok := c.objects[id]; ok && fbxElementId(e) == "LayeredTexture" {
+		for _, lk := range c.links[id] {
+			if tex, ok := c.scene.ObjectMap[lk.from].(*fbx.Texture); ok {
+				return tex
+			}
+		}
+	}
+	return nil
+}
+
+// videoContent 纹理连接的 Video 对象中内嵌的文件内容，二进制 FBX 为原始字节，ASCII FBX 为 base64。
+// ofbx 保留了二进制原始数据前 4 字节的长度
+func (c *fbxConnections) videoContent(id uint64) []byte {
+	for _, lk := range c.links[id] {
+		e, ok := c.objects[lk.from]
+		if !ok || fbxElementId(e) != "Video" {
+			continue
+		}
+		for _, ch := range e.Children {
+			if fbxElementId(ch) != "Content" || len(ch.Properties) == 0 {
+				continue
+			}
+			p := ch.Properties[0]
+			content := p.String()
+			if p.Type == fbx.RAWSTRING && len(content) >= 4 &&
+				binary.LittleEndian.Uint32([]byte(content[:4])) == uint32(len(content)-4) {
+				return []byte(content[4:])
+			}
+			if data, err := base64.StdEncoding.DecodeString(content); err == nil && len(data) > 0 {
+				return data
+			}
+			return []byte(content)
+		}
+	}
+	return nil
+}
+
+func fbxElementId(e *fbx.Element) string {
+	if e == nil || e.ID == nil {
+		return ""
+	}
+	return e.ID.String()
+}
+
+func fbxPropertyId(p *fbx.Property) uint64 {
+	v, _ := strconv.ParseInt(strings.TrimSpace(p.String()), 10, 64)
+	return uint64(v)
+}
+
+// fbxPropertyRaw 属性的原始数据。ofbx 只能通过 String 取值，双精度数按 %f 格式化只保留 6 位小数，
+// 这里直接读取未导出的数据
+func fbxPropertyRaw(p *fbx.Property) []byte {
+	f := reflect.ValueOf(p).Elem().FieldByName("value")
+	if !f.IsValid() || f.Type() != reflect.TypeOf((*fbx.DataView)(nil)) || f.IsNil() {
+		return nil
+	}
+	dv := (*fbx.DataView)(f.UnsafePointer())
+	raw := make([]byte, dv.Size())
+	n, _ := dv.ReadAt(raw, 0)
+	return raw[:n]
+}
+
+// fbxPropertyValues 按属性类型解码数值或数值数组，压缩的数组先解压，非数值属性返回 nil
+func fbxPropertyValues(p *fbx.Property) []float64 {
+	if p == nil {
+		return nil
+	}
+	size := p.Type.Size()
+	if size == 0 {
+		return nil
+	}
+	raw := fbxPropertyRaw(p)
+	if !p.Type.IsArray() {
+		if len(raw) != size {
+			return nil
+		}
+		return []float64{fbxNumber(p.Type, raw)}
+	}
+	if p.Encoding == 1 {
+		zr, err := zlib.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil
+		}
+		defer zr.Close()
+		if raw, err = io.ReadAll(zr); err != nil {
+			return nil
+		}
+	}
+	if p.Count < 0 || len(raw) < p.Count*size {
+		return nil
+	}
+	vs := make([]float64, p.Count)
+	for i := range vs {
+		vs[i] = fbxNumber(p.Type, raw[i*size:])
+	}
+	return vs
+}
+
+// fbxNumber 按小端序解码一个数值
+func fbxNumber(t fbx.PropertyType, b []byte) float64 {
+	le := binary.LittleEndian
+	switch t {
+	case fbx.DOUBLE, fbx.ArrayDOUBLE:
+		return math.Float64frombits(le.Uint64(b))
+	case fbx.FLOAT, fbx.ArrayFLOAT:
+		return float64(math.Float32frombits(le.Uint32(b)))
+	case fbx.LONG, fbx.ArrayLONG:
+		return float64(int64(le.Uint64(b)))
+	case fbx.INTEGER, fbx.ArrayINT:
+		return float64(int32(le.Uint32(b)))
+	case fbx.INT16:
+		return float64(int16(le.Uint16(b)))
+	}
+	return float64(b[0])
+}
+
+func fbxChildProperty(e *fbx.Element, id string) string {
+	for _, ch := range e.Children {
+		if fbxElementId(ch) == id && len(ch.Properties) > 0 {
+			return ch.Properties[0].String()
+		}
+	}
+	return ""
+}
+
+// fbxProps 材质 Properties70 中的属性
+type fbxProps map[string]*fbx.Element
+
+func newFbxProps(obj fbx.Obj) fbxProps {
+	return fbxElementProps(obj.Element())
+}
+
+func fbxElementProps(e *fbx.Element) fbxProps {
+	props := fbxProps{}
+	if e == nil {
+		return props
+	}
+	for _, ch := range e.Children {
+		if fbxElementId(ch) != "Properties70" {
+			continue
+		}
+		for _, p := range ch.Children {
+			if len(p.Properties) > 0 {
+				props[p.Properties[0].String()] = p
+			}
+		}
+	}
+	return props
+}
+
+func (p fbxProps) has(names ...string) bool {
+	for _, n := range names {
+		if _, ok := p[n]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (p fbxProps) values(names ...string) []float64 {
+	for _, n := range names {
+		e, ok := p[n]
+		if !ok || len(e.Properties) < 5 {
+			continue
+		}
+		var vs []float64
+		for _, v := range e.Properties[4:] {
+			f := fbxPropertyValues(v)
+			if len(f) != 1 {
+				break
+			}
+			vs = append(vs, f[0])
+		}
+		if len(vs) > 0 {
+			return vs
+		}
+	}
+	return nil
+}
+
+func (p fbxProps) float(def float64, names ...string) float64 {
+	if vs := p.values(names...); len(vs) > 0 {
+		return vs[0]
+	}
+	return def
+}
+
+func (p fbxProps) color(def [3]float64, names ...string) [3]float64 {
+	vs := p.values(names...)
+	switch len(vs) {
+	case 0:
+		return def
+	case 1, 2:
+		return [3]float64{vs[0], vs[0], vs[0]}
+	}
+	return [3]float64{vs[0], vs[1], vs[2]}
+}
+
+func (p fbxProps) bool(names ...string) bool {
+	return p.float(0, names...) != 0
+}
+
+func fbxColor(c fbx.Color) [3]float64 {
+	return [3]float64{float64(c.R), float64(c.G), float64(c.B)}
+}
+
+func colorToBytes(c [3]float64, f float64) [3]byte {
+	return [3]byte{floatToByte(c[0] * f), floatToByte(c[1] * f), floatToByte(c[2] * f)}
+}
+
+func (cv *FbxToMst) convertMaterial(mstMh *mst.Mesh, mt *fbx.Material, repete bool) int32 {
+	key := fbxMtlKey{repete: repete}
+	if mt != nil {
+		key.id = mt.ID()
+	}
+	cache, ok := cv.mtlCache[mstMh]
+	if !ok {
+		cache = make(map[fbxMtlKey]int32)
+		cv.mtlCache[mstMh] = cache
+	}
+	if idx, ok := cache[key]; ok {
+		return idx
+	}
+
+	idx := int32(len(mstMh.Materials))
+	var mtl mst.MeshMaterial
+	if mt == nil {
+		pbr := &mst.PbrMaterial{Metallic: fbxDefaultMetallic, Roughness: fbxDefaultRoughness}
+		pbr.Color = [3]byte{255, 255, 255}
+		mtl = pbr
+	} else {
+		props := newFbxProps(mt)
+		texs := cv.conns.textures(mt.ID())
+		if props.has(fbxPbrBaseColor...) || props.has(fbxPbrMetallic...) {
+			mtl = cv.convertPbrMaterial(mstMh, mt, props, texs, repete)
+		} else {
+			mtl = cv.convertPhongMaterial(mstMh, mt, props, texs, repete)
+		}
+	}
+	mstMh.Materials = append(mstMh.Materials, mtl)
+	cache[key] = idx
+	return idx
+}
+
+// convertPhongMaterial 转换 Phong/Lambert 材质，MST 只有基础色和法线两个纹理槽，
+// 透明度贴图合并到基础色纹理的 alpha 通道，凹凸贴图转换为法线贴图。
+// 高光、自发光和环境光贴图按平均颜色折算为对应的颜色和系数，反射颜色、反射系数及其贴图
+// 按平均亮度折算后累加到高光强度，与 3DS 的反射贴图相同；Lambert 材质没有高光，忽略高光和反射
+func (cv *FbxToMst) convertPhongMaterial(mstMh *mst.Mesh, mt *fbx.Material, props fbxProps, texs map[string]*fbx.Texture, repete bool) mst.MeshMaterial {
+	diffuse := colorToBytes(fbxColor(mt.DiffuseColor), props.float(1, "DiffuseFactor"))
+	ambient := colorToBytes(cv.mapColor(texs[fbxAmbientColor], fbxColor(mt.AmbientColor)), props.float(1, "AmbientFactor"))
+	emissive := colorToBytes(cv.mapColor(texs[fbxEmissiveColor], fbxColor(mt.EmissiveColor)),
+		cv.mapFactor(texs[fbxEmissiveFactor], props.float(1, fbxEmissiveFactor)))
+
+	transparency := 0.0
+	if props.has("Opacity") {
+		transparency = 1 - props.float(1, "Opacity")
+	} else if props.has(fbxTransparencyFactor) {
+		tc := props.color([3]float64{1, 1, 1}, fbxTransparentColor)
+		transparency = props.float(0, fbxTransparencyFactor) * (tc[0] + tc[1] + tc[2]) / 3
+	}
+	if transparency < 0 || transparency >= 1 {
+		transparency = 0
+	}
+
+	opacity := texs[fbxTransparentColor]
+	if opacity == nil {
+		opacity = texs[fbxTransparencyFactor]
+	}
+	tex := cv.diffuseTexture(mstMh, texs[fbxDiffuseColor], opacity, diffuse, repete)
+	normal := cv.normalTexture(mstMh, texs[fbxNormalMap], texs[fbxBump], repete)
+
+	lambert := mst.LambertMaterial{
+		TextureMaterial: mst.TextureMaterial{
+			BaseMaterial: mst.BaseMaterial{Color: diffuse, Transparency: float32(transparency)},
+			Texture:      tex,
+			Normal:       normal,
+		},
+		Ambient:  ambient,
+		Diffuse:  diffuse,
+		Emissive: emissive,
+	}
+	if strings.EqualFold(fbxChildProperty(mt.Element(), "ShadingModel"), "lambert") {
+		return &lambert
+	}
+
+	specularFactor := cv.mapFactor(texs[fbxSpecularFactor], props.float(1, fbxSpecularFactor))
+	specular := cv.mapColor(texs[fbxSpecularColor], fbxColor(mt.SpecularColor))
+	reflection := cv.mapColor(texs[fbxReflectionColor], props.color(fbxColor(mt.ReflectionColor), fbxReflectionColor))
+	reflectionFactor := cv.mapFactor(texs[fbxReflectionFactor], props.float(1, fbxReflectionFactor))
+	specularity := specularFactor + reflectionFactor*(reflection[0]+reflection[1]+reflection[2])/3
+	if specularity > 1 {
+		specularity = 1
+	}
+	shininess := mt.ShininessExponent
+	if !props.has("ShininessExponent") {
+		shininess = mt.Shininess
+	}
+	return &mst.PhongMaterial{
+		LambertMaterial: lambert,
+		Specular:        colorToBytes(specular, specularFactor),
+		Shininess:       float32(shininess),
+		Specularity:     float32(specularity),
+	}
+}
+
+// mapColor 颜色贴图的平均颜色，没有贴图或贴图无法读取时返回 cl
+func (cv *FbxToMst) mapColor(tex *fbx.Texture, cl [3]float64) [3]float64 {
+	if tex == nil {
+		return cl
+	}
+	img := cv.textureImage(tex)
+	if img == nil {
+		return cl
+	}
+	if mean, ok := imageMean(img); ok {
+		return mean
+	}
+	return cl
+}
+
+// mapFactor 系数乘以系数贴图的平均亮度，没有贴图或贴图无法读取时返回 f
+func (cv *FbxToMst) mapFactor(tex *fbx.Texture, f float64) float64 {
+	if tex == nil {
+		return f
+	}
+	img := cv.textureImage(tex)
+	if img == nil {
+		return f
+	}
+	if mean, ok := imageMean(img); ok {
+		return f * (mean[0] + mean[1] + mean[2]) / 3
+	}
+	return f
+}
+
+// convertPbrMaterial 转换 Stingray PBS、Arnold 和 3ds Max Physical 材质
+func (cv *FbxToMst) convertPbrMaterial(mstMh *mst.Mesh, mt *fbx.Material, props fbxProps, texs map[string]*fbx.Texture, repete bool) mst.MeshMaterial {
+	pbr := &mst.PbrMaterial{
+		Reflectance:         0.5,
+		AmbientOcclusion:    1,
+		AnisotropyDirection: [3]float32{1, 0, 0},
+	}
+	base := props.color(fbxColor(mt.DiffuseColor), fbxPbrBaseColor...)
+	pbr.Color = colorToBytes(base, props.float(1, fbxPbrBaseWeight...))
+	pbr.Metallic = float32(props.float(fbxDefaultMetallic, fbxPbrMetallic...))
+	roughness := props.float(fbxDefaultRoughness, fbxPbrRoughness...)
+	if props.bool(fbxPbrRoughnessInv) {
+		roughness = 1 - roughness
+	}
+	pbr.Roughness = float32(roughness)
+	pbr.Emissive = colorToBytes(props.color(fbxColor(mt.EmissiveColor), fbxPbrEmissive...), props.float(1, fbxPbrEmission...))
+	pbr.ClearCoat = float32(props.float(0, fbxPbrCoat...))
+	pbr.ClearCoatRoughness = float32(props.float(0, fbxPbrCoatRoughness...))
+	pbr.Anisotropy = float32(props.float(0, fbxPbrAnisotropy...))
+
+	transparency := props.float(0, fbxPbrTransparency...)
+	if props.has(fbxPbrArnoldOpacity) {
+		op := props.color([3]float64{1, 1, 1}, fbxPbrArnoldOpacity)
+		transparency = 1 - (op[0]+op[1]+op[2])/3
+	}
+	if transparency < 0 || transparency >= 1 {
+		transparency = 0
+	}
+	pbr.Transparency = float32(transparency)
+
+	var baseTex, normalTex, opacityTex *fbx.Texture
+	if !props.has(fbxPbrUseColorMap) || props.bool(fbxPbrUseColorMap) {
+		baseTex = fbxFirstTexture(texs, fbxPbrBaseColorMap)
+	}
+	if !props.has(fbxPbrUseNormalMap) || props.bool(fbxPbrUseNormalMap) {
+		normalTex = fbxFirstTexture(texs, fbxPbrNormalMap)
+	}
+	opacityTex = fbxFirstTexture(texs, fbxPbrOpacityMap)
+	pbr.Texture = cv.diffuseTexture(mstMh, baseTex, opacityTex, pbr.Color, repete)
+	pbr.Normal = cv.normalTexture(mstMh, normalTex, texs[fbxBump], repete)
+	return pbr
+}
+
+func fbxFirstTexture(texs map[string]*fbx.Texture, names []string) *fbx.Texture {
+	for _, n := range names {
+		if tex, ok := texs[n]; ok {
+			return tex
+		}
+	}
+	return nil
+}
+
+// diffuseTexture 基础色纹理，存在透明度贴图时合并到 alpha 通道
+func (cv *FbxToMst) diffuseTexture(mstMh *mst.Mesh, base, opacity *fbx.Texture, cl [3]byte, repete bool) *mst.Texture {
+	if opacity == nil {
+		if base == nil {
+			return nil
+		}
+		return cv.cachedTexture(mstMh, fbxTextureKey(base), repete, func() image.Image {
+			return cv.textureImage(base)
+		})
+	}
+	key := "alpha:" + fbxTextureKey(opacity)
+	if base != nil {
+		key = fbxTextureKey(base) + "|" + key
+	}
+	return cv.cachedTexture(mstMh, key, repete, func() image.Image {
+		mask := cv.textureImage(opacity)
+		var img image.Image
+		if base != nil {
+			img = cv.textureImage(base)
+		}
+		if mask == nil {
+			return img
+		}
+		return imageWithAlpha(img, cl, mask)
+	})
+}
+
+// normalTexture 法线贴图，只有凹凸贴图时由高度生成法线
+func (cv *FbxToMst) normalTexture(mstMh *mst.Mesh, normal, bump *fbx.Texture, repete bool) *mst.Texture {
+	if normal != nil {
+		return cv.cachedTexture(mstMh, fbxTextureKey(normal), repete, func() image.Image {
+			return cv.textureImage(normal)
+		})
+	}
+	if bump == nil {
+		return nil
+	}
+	return cv.cachedTexture(mstMh, "bump:"+fbxTextureKey(bump), repete, func() image.Image {
+		img := cv.textureImage(bump)
+		if img == nil {
+			return nil
+		}
+		return heightToNormal(img, fbxBumpNormalScale)
+	})
+}
+
+// cachedTexture 同一个 MST 网格内复用纹理，避免重复解码和写入
+func (cv *FbxToMst) cachedTexture(mstMh *mst.Mesh, key string, repete bool, load func() image.Image) *mst.Texture {
+	cache, ok := cv.texCache[mstMh]
+	if !ok {
+		cache = make(map[string]*mst.Texture)
+		cv.texCache[mstMh] = cache
+	}
+	if tex, ok := cache[key]; ok {
+		if tex != nil {
+			tex.Repeated = tex.Repeated || repete
+		}
+		return tex
+	}
+	var tex *mst.Texture
+	if img := load(); img != nil {
+		tex = imageToTex(img, cv.texId)
+		tex.Repeated = repete
+		cv.texId++
+	}
+	cache[key] = tex
+	return tex
+}
+
+func fbxTextureKey(tex *fbx.Texture) string {
+	abs, rel := fbxTextureNames(tex)
+	return abs + "|" + rel
+}
+
+// fbxTextureNames 纹理中记录的绝对路径和相对路径
+func fbxTextureNames(tex *fbx.Texture) (string, string) {
+	e := tex.Element()
+	if e == nil {
+		return "", ""
+	}
+	abs := strings.ReplaceAll(fbxChildProperty(e, "FileName"), "\\", "/")
+	rel := strings.ReplaceAll(fbxChildProperty(e, "RelativeFilename"), "\\", "/")
+	return abs, rel
+}
+
+// textureImage 依次尝试内嵌的 Video 内容、相对路径、绝对路径和各搜索目录，
+// 找不到时记录诊断信息
+func (cv *FbxToMst) textureImage(tex *fbx.Texture) image.Image {
+	if img, ok := cv.imgCache[tex.ID()]; ok {
+		return img
+	}
+	img := cv.resolveTexture(tex)
+	cv.imgCache[tex.ID()] = img
+	return img
+}
+
+func (cv *FbxToMst) resolveTexture(tex *fbx.Texture) image.Image {
+	abs, rel := fbxTextureNames(tex)
+	if content := cv.conns.videoContent(tex.ID()); len(content) > 0 {
+		img, err := decodeImage(bytes.NewReader(content))
+		if err == nil {
+			return img
+		}
+		cv.Diagnostics.Warnf("fbx: embedded texture %q cannot be decoded: %v", fbxTextureLabel(abs, rel), err)
+	}
+
+	for _, f := range cv.texturePaths(abs, rel) {
+		if _, err := os.Stat(f); err != nil {
+			continue
+		}
+		img, err := loadImage(f)
+		if err == nil {
+			return img
+		}
+		cv.Diagnostics.Warnf("fbx: texture %q cannot be decoded: %v", f, err)
+	}
+	cv.Diagnostics.Warnf("fbx: texture %q not found", fbxTextureLabel(abs, rel))
+	return nil
+}
+
+// texturePaths 纹理文件的候选路径，按查找顺序排列且不重复
+func (cv *FbxToMst) texturePaths(abs, rel string) []string {
+	var paths []string
+	seen := make(map[string]bool)
+	add := func(p string) {
+		if p == "" || seen[p] {
+			return
+		}
+		seen[p] = true
+		paths = append(paths, p)
+	}
+	if rel != "" {
+		add(filepath.Join(cv.baseDir, filepath.FromSlash(rel)))
+	}
+	if abs != "" {
+		add(filepath.FromSlash(abs))
+	}
+	dirs := append([]string{cv.baseDir}, cv.TextureSearchDirs...)
+	for _, dir := range dirs {
+		if rel != "" {
+			add(filepath.Join(dir, filepath.FromSlash(rel)))
+		}
+		for _, name := range []string{rel, abs} {
+			if name != "" {
+				add(filepath.Join(dir, path.Base(name)))
+			}
+		}
+	}
+	return paths
+}
+
+func fbxTextureLabel(abs, rel string) string {
+	if rel != "" {
+		return rel
+	}
+	return abs
+}
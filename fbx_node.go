@@ -0,0 +1,93 @@
+package asset3d
+
+import (
+	mst "github.com/flywave/go-mst"
+	mat4d "github.com/flywave/go3d/float64/mat4"
+	vec3d "github.com/flywave/go3d/float64/vec3"
+
+	fbx "github.com/flywave/ofbx"
+)
+
+// FbxNode 保留层级模式下的场景节点，父节点总是排在子节点之前
+type FbxNode struct {
+	Id     uint64
+	Name   string
+	Parent int     // 父节点在 Hierarchy 中的索引，根节点为 -1
+	Local  mat4d.T // 包含轴心和前后旋转的局部变换
+	Global mat4d.T
+
+	Translation   vec3d.T
+	Rotation      vec3d.T // 欧拉角，单位为度
+	Scaling       vec3d.T
+	RotationOrder int
+	PreRotation   vec3d.T
+	PostRotation  vec3d.T
+
+	RotationOffset vec3d.T
+	RotationPivot  vec3d.T
+	ScalingOffset  vec3d.T
+	ScalingPivot   vec3d.T
+
+	// Meshes 挂在该节点下的 MST 节点，顶点位于节点局部空间，Mat 为节点的全局变换
+	Meshes []*mst.MeshNode
+}
+
+// convertHierarchy 按文件中的顺序收集全部模型节点，变换取自烘焙姿态或静止姿态
+func (cv *FbxToMst) convertHierarchy(pose *fbxPose) []*FbxNode {
+	var nodes []*FbxNode
+	index := make(map[uint64]int)
+	var add func(id uint64) int
+	add = func(id uint64) int {
+		if idx, ok := index[id]; ok {
+			return idx
+		}
+		parent := -1
+		if pid, ok := cv.conns.parentModel(id); ok {
+			parent = add(pid)
+		}
+		nd := pose.node(id)
+		nd.Parent = parent
+		index[id] = len(nodes)
+		nodes = append(nodes, nd)
+		return index[id]
+	}
+	for _, id := range cv.conns.models {
+		add(id)
+	}
+	cv.nodeIndex = index
+	return nodes
+}
+
+// hierarchyNode 返回模型在 Hierarchy 中的节点，不保留层级或模型不在节点树中时返回 nil，
+// 此时网格按不保留层级的方式展开到世界空间
+func (cv *FbxToMst) hierarchyNode(id uint64) *FbxNode {
+	if !cv.PreserveHierarchy {
+		return nil
+	}
+	idx, ok := cv.nodeIndex[id]
+	if !ok || idx >= len(cv.Hierarchy) {
+		return nil
+	}
+	return cv.Hierarchy[idx]
+}
+
+func (p *fbxPose) node(id uint64) *FbxNode {
+	e := p.conns.objects[id]
+	props := fbxElementProps(e)
+	return &FbxNode{
+		Id:             id,
+		Name:           fbxObjectName(e),
+		Local:          p.local(id),
+		Global:         p.global(id),
+		Translation:    p.vec3(id, props, fbx.BoneTranslate, vec3d.T{}),
+		Rotation:       p.vec3(id, props, fbx.BoneRotate, vec3d.T{}),
+		Scaling:        p.vec3(id, props, fbx.BoneScale, vec3d.T{1, 1, 1}),
+		RotationOrder:  int(props.float(0, "RotationOrder")),
+		PreRotation:    p.vec3(id, props, "PreRotation", vec3d.T{}),
+		PostRotation:   p.vec3(id, props, "PostRotation", vec3d.T{}),
+		RotationOffset: p.vec3(id, props, "RotationOffset", vec3d.T{}),
+		RotationPivot:  p.vec3(id, props, "RotationPivot", vec3d.T{}),
+		ScalingOffset:  p.vec3(id, props, "ScalingOffset", vec3d.T{}),
+		ScalingPivot:   p.vec3(id, props, "ScalingPivot", vec3d.T{}),
+	}
+}
@@ -0,0 +1,462 @@
+package asset3d
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	mst "github.com/flywave/go-mst"
+	mat4d "github.com/flywave/go3d/float64/mat4"
+	vec3d "github.com/flywave/go3d/float64/vec3"
+	fbx "github.com/flywave/ofbx"
+)
+
+// fbxTestNode 测试用的二进制 FBX(7400) 节点，属性支持 int32、int64、float64、string、
+// []byte 以及 []int32、[]float64 数组
+type fbxTestNode struct {
+	name     string
+	props    []interface{}
+	children []*fbxTestNode
+}
+
+func fbxN(name string, props []interface{}, children ...*fbxTestNode) *fbxTestNode {
+	return &fbxTestNode{name: name, props: props, children: children}
+}
+
+// fbxP70 Properties70 节点，每个属性为 名称、类型、标签、标志和值
+func fbxP70(props ...[]interface{}) *fbxTestNode {
+	nd := &fbxTestNode{name: "Properties70"}
+	for _, p := range props {
+		nd.children = append(nd.children, fbxN("P", p))
+	}
+	return nd
+}
+
+// fbxObjName 对象名称，FBX 中名称与类名以 "\x00\x01" 分隔
+func fbxObjName(name, class string) string {
+	return name + "\x00\x01" + class
+}
+
+func (n *fbxTestNode) encode(buf *bytes.Buffer) {
+	var props bytes.Buffer
+	le := binary.LittleEndian
+	for _, p := range n.props {
+		switch v := p.(type) {
+		case int32:
+			props.WriteByte('I')
+			binary.Write(&props, le, v)
+		case int64:
+			props.WriteByte('L')
+			binary.Write(&props, le, v)
+		case float32:
+			props.WriteByte('F')
+			binary.Write(&props, le, v)
+		case float64:
+			props.WriteByte('D')
+			binary.Write(&props, le, v)
+		case string:
+			props.WriteByte('S')
+			binary.Write(&props, le, uint32(len(v)))
+			props.WriteString(v)
+		case []byte:
+			props.WriteByte('R')
+			binary.Write(&props, le, uint32(len(v)))
+			props.Write(v)
+		case []int32:
+			props.WriteByte('i')
+			binary.Write(&props, le, [3]uint32{uint32(len(v)), 0, uint32(4 * len(v))})
+			binary.Write(&props, le, v)
+		case []float64:
+			props.WriteByte('d')
+			binary.Write(&props, le, [3]uint32{uint32(len(v)), 0, uint32(8 * len(v))})
+			binary.Write(&props, le, v)
+		default:
+			panic("fbxTestNode: unsupported property")
+		}
+	}
+	start := buf.Len()
+	binary.Write(buf, le, [3]uint32{0, uint32(len(n.props)), uint32(props.Len())})
+	buf.WriteByte(byte(len(n.name)))
+	buf.WriteString(n.name)
+	buf.Write(props.Bytes())
+	for _, c := range n.children {
+		c.encode(buf)
+	}
+	if len(n.children) > 0 {
+		buf.Write(make([]byte, 13))
+	}
+	le.PutUint32(buf.Bytes()[start:], uint32(buf.Len()))
+}
+
+// writeFbxTest 将 Objects 和 Connections 写为二进制 FBX 文件
+func writeFbxTest(t *testing.T, dir string, objects []*fbxTestNode, conns [][]interface{}) string {
+	t.Helper()
+	var buf bytes.Buffer
+	buf.WriteString("Kaydara FBX Binary  \x00\x1a\x00")
+	binary.Write(&buf, binary.LittleEndian, uint32(7400))
+	connNode := &fbxTestNode{name: "Connections"}
+	for _, c := range conns {
+		kind := "OO"
+		if len(c) > 2 {
+			kind = "OP"
+		}
+		connNode.children = append(connNode.children, fbxN("C", append([]interface{}{kind}, c...)))
+	}
+	(&fbxTestNode{name: "Objects", children: objects}).encode(&buf)
+	connNode.encode(&buf)
+	buf.Write(make([]byte, 13))
+	path := filepath.Join(dir, "test.fbx")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// fbxTestTriangle 一个三角形的几何体(100)和模型(200)，模型挂在根节点下
+func fbxTestTriangle() ([]*fbxTestNode, [][]interface{}) {
+	objects := []*fbxTestNode{
+		fbxN("Geometry", []interface{}{int64(100), fbxObjName("Geo", "Geometry"), "Mesh"},
+			fbxN("Vertices", []interface{}{[]float64{0, 0, 0, 1, 0, 0, 0, 1, 0}}),
+			fbxN("PolygonVertexIndex", []interface{}{[]int32{0, 1, -3}})),
+		fbxN("Model", []interface{}{int64(200), fbxObjName("Mesh", "Model"), "Mesh"}, fbxP70()),
+	}
+	conns := [][]interface{}{{int64(100), int64(200)}, {int64(200), int64(0)}}
+	return objects, conns
+}
+
+// fbxTestPng 单色 PNG 图像
+func fbxTestPng(t *testing.T, cl color.NRGBA) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	for i := 0; i < 4; i++ {
+		img.SetNRGBA(i%2, i/2, cl)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFbxToMst_HierarchyNode(t *testing.T) {
+	// 不在节点树中的模型(例如节点树为空)回退到世界空间，不应越界
+	cv := &FbxToMst{PreserveHierarchy: true}
+	if nd := cv.hierarchyNode(1); nd != nil {
+		t.Errorf("空节点树应返回 nil: %+v", nd)
+	}
+	cv.nodeIndex = map[uint64]int{1: 0, 2: 3}
+	cv.Hierarchy = []*FbxNode{{Id: 1}}
+	if nd := cv.hierarchyNode(1); nd == nil || nd.Id != 1 {
+		t.Errorf("节点查找错误: %+v", nd)
+	}
+	if nd := cv.hierarchyNode(2); nd != nil {
+		t.Errorf("越界的索引应返回 nil: %+v", nd)
+	}
+	cv.PreserveHierarchy = false
+	if nd := cv.hierarchyNode(1); nd != nil {
+		t.Errorf("不保留层级时应返回 nil: %+v", nd)
+	}
+}
+
+func TestFbxLayers_Mapping(t *testing.T) {
+	// 两个四边形共用一条边，6 个控制点、8 个多边形顶点
+	g := &fbx.Geometry{Faces: [][]int{{0, 1, 4, 3}, {1, 2, 5, 4}}}
+	for i := 0; i < 6; i++ {
+		g.Vertices = append(g.Vertices, [3]float64{float64(i % 3), float64(i / 3), 0})
+	}
+	l := newFbxLayers(g)
+	tests := []struct {
+		n    int
+		want int
+	}{
+		{0, fbxMappingNone},
+		{8, fbxMappingPolygonVertex},
+		{6, fbxMappingVertex},
+		{2, fbxMappingPolygon},
+		{1, fbxMappingAllSame},
+		{3, fbxMappingNone},
+	}
+	for _, tt := range tests {
+		if got := l.mapping(tt.n); got != tt.want {
+			t.Errorf("mapping(%d) = %d, 期望 %d", tt.n, got, tt.want)
+		}
+		if i := l.index(tt.n, 5, 7, 1); tt.want == fbxMappingNone && i != -1 {
+			t.Errorf("index(%d) = %d, 期望 -1", tt.n, i)
+		}
+	}
+
+	// 按多边形映射的法线使共用边上的控制点拆分为两个顶点，单个颜色映射到全部顶点
+	g.Normals = append(g.Normals, [3]float64{0, 0, 1}, [3]float64{0, 1, 0})
+	g.Colors = append(g.Colors, [4]float64{1, 0, 0, 1})
+	l = newFbxLayers(g)
+	if !l.hasNormals || !l.hasColors || l.hasTangents {
+		t.Fatalf("图层识别错误: %+v", l)
+	}
+	k0, k1 := l.key(1, 1, 0), l.key(1, 4, 1)
+	if k0 == k1 || k0.normal != [3]float64{0, 0, 1} || k1.normal != [3]float64{0, 1, 0} {
+		t.Errorf("按多边形映射的法线错误: %v %v", k0.normal, k1.normal)
+	}
+	if k1.color != [4]float64{1, 0, 0, 1} {
+		t.Errorf("颜色错误: %v", k1.color)
+	}
+
+	// UV 通道不存在时使用第一个存在的通道
+	g.UVs[1] = append(g.UVs[1], [2]float64{0, 0}, [2]float64{2, 0})
+	l = newFbxLayers(g)
+	if ch := l.uvChannel(0); ch != 1 || !l.repeated(ch) {
+		t.Errorf("UV 通道错误: %d", ch)
+	}
+}
+
+func TestFbxToMst_PhongMaps(t *testing.T) {
+	dir := t.TempDir()
+	for name, cl := range map[string]color.NRGBA{"spec.png": {255, 0, 0, 255}, "glow.png": {0, 255, 0, 255}} {
+		if err := os.WriteFile(filepath.Join(dir, name), fbxTestPng(t, cl), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	color3 := func(name string, r, g, b float64) []interface{} {
+		return []interface{}{name, "Color", "", "A", r, g, b}
+	}
+	number := func(name string, v float64) []interface{} {
+		return []interface{}{name, "Number", "", "A", v}
+	}
+	texture := func(id int64, file string) *fbxTestNode {
+		return fbxN("Texture", []interface{}{id, fbxObjName(file, "Texture"), ""},
+			fbxN("FileName", []interface{}{file}), fbxN("RelativeFilename", []interface{}{file}))
+	}
+	objects, conns := fbxTestTriangle()
+	objects = append(objects,
+		fbxN("Material", []interface{}{int64(300), fbxObjName("Mat", "Material"), ""},
+			fbxN("ShadingModel", []interface{}{"phong"}),
+			fbxP70(color3("DiffuseColor", 1, 1, 1), color3("AmbientColor", 0.2, 0.2, 0.2),
+				color3("SpecularColor", 1, 1, 1), number("SpecularFactor", 0.25),
+				color3("ReflectionColor", 1, 1, 1), number("ReflectionFactor", 0.5))),
+		// 环境光贴图不存在
+		texture(400, "spec.png"),
+		texture(402, "glow.png"),
+		texture(403, "missing.png"),
+	)
+	conns = append(conns,
+		[]interface{}{int64(300), int64(200)},
+		[]interface{}{int64(400), int64(300), fbxSpecularColor},
+		[]interface{}{int64(402), int64(300), fbxEmissiveColor},
+		[]interface{}{int64(403), int64(300), fbxAmbientColor},
+	)
+	path := writeFbxTest(t, dir, objects, conns)
+
+	cv := &FbxToMst{}
+	mesh, _, err := cv.Convert(path)
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	if len(mesh.Materials) != 1 {
+		t.Fatalf("材质数量 %d, 期望 1", len(mesh.Materials))
+	}
+	mtl, ok := mesh.Materials[0].(*mst.PhongMaterial)
+	if !ok {
+		t.Fatalf("材质类型错误: %T", mesh.Materials[0])
+	}
+	// 高光颜色取贴图的平均颜色，反射按平均亮度累加到高光强度
+	if mtl.Specular != colorToBytes([3]float64{1, 0, 0}, 0.25) {
+		t.Errorf("高光颜色 %v", mtl.Specular)
+	}
+	if math.Abs(float64(mtl.Specularity)-0.75) > 1e-6 {
+		t.Errorf("高光强度 %f, 期望 0.75", mtl.Specularity)
+	}
+	if mtl.Emissive != [3]byte{0, 255, 0} {
+		t.Errorf("自发光颜色 %v", mtl.Emissive)
+	}
+	if mtl.Ambient != colorToBytes([3]float64{0.2, 0.2, 0.2}, 1) {
+		t.Errorf("贴图不存在时应保留环境光颜色: %v", mtl.Ambient)
+	}
+	if len(cv.Diagnostics) != 1 {
+		t.Errorf("诊断信息错误: %v", cv.Diagnostics)
+	}
+}
+
+func TestFbxToMst_ResolveTexture(t *testing.T) {
+	dir := t.TempDir()
+	search := filepath.Join(dir, "search")
+	red, green, blue := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 255, 0, 255}, color.NRGBA{0, 0, 255, 255}
+	for name, cl := range map[string]color.NRGBA{
+		"tex/rel.png":       green,
+		"search/remote.png": blue,
+		"broken.png":        red,
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, fbxTestPng(t, cl), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	texture := func(id int64, abs, rel string) *fbxTestNode {
+		return fbxN("Texture", []interface{}{id, fbxObjName("Tex", "Texture"), ""},
+			fbxN("FileName", []interface{}{abs}), fbxN("RelativeFilename", []interface{}{rel}))
+	}
+	video := func(id int64, content []byte) *fbxTestNode {
+		return fbxN("Video", []interface{}{id, fbxObjName("Video", "Video"), "Clip"},
+			fbxN("Content", []interface{}{content}))
+	}
+	objects := []*fbxTestNode{
+		fbxN("Material", []interface{}{int64(300), fbxObjName("Mat", "Material"), ""}, fbxP70()),
+		// 内嵌内容优先于文件
+		texture(400, "C:\\nowhere\\embedded.png", ""), video(500, fbxTestPng(t, red)),
+		// 相对路径相对于 FBX 文件
+		texture(401, "C:\\nowhere\\rel.png", "tex\\rel.png"),
+		// 绝对路径不存在时按文件名在搜索目录中查找
+		texture(402, "D:\\art\\remote.png", ""),
+		texture(403, "D:\\art\\missing.png", "missing.png"),
+		// 内嵌内容无法解码时回退到文件
+		texture(404, "broken.png", "broken.png"), video(504, []byte("not an image")),
+	}
+	conns := [][]interface{}{
+		{int64(400), int64(300), "DiffuseColor"}, {int64(500), int64(400)},
+		{int64(401), int64(300), "SpecularColor"},
+		{int64(402), int64(300), "EmissiveColor"},
+		{int64(403), int64(300), "AmbientColor"},
+		{int64(404), int64(300), "Bump"}, {int64(504), int64(404)},
+	}
+	f, err := os.Open(writeFbxTest(t, dir, objects, conns))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scene, err := fbx.Load(f)
+	if err != nil {
+		t.Fatalf("读取失败: %v", err)
+	}
+
+	cv := &FbxToMst{TextureSearchDirs: []string{search}}
+	cv.conns = newFbxConnections(scene)
+	cv.baseDir = dir
+	texs := cv.conns.textures(300)
+	tests := []struct {
+		property string
+		want     *color.NRGBA
+		warnings int
+	}{
+		{"DiffuseColor", &red, 0},
+		{"SpecularColor", &green, 0},
+		{"EmissiveColor", &blue, 0},
+		{"AmbientColor", nil, 1},
+		{"Bump", &red, 1},
+	}
+	for _, tt := range tests {
+		cv.Diagnostics = nil
+		img := cv.resolveTexture(texs[tt.property])
+		if tt.want == nil {
+			if img != nil {
+				t.Errorf("%s: 不存在的纹理应返回 nil", tt.property)
+			}
+		} else if img == nil {
+			t.Errorf("%s: 没有找到纹理", tt.property)
+		} else if mean, _ := imageMean(img); mean != [3]float64{float64(tt.want.R) / 255, float64(tt.want.G) / 255, float64(tt.want.B) / 255} {
+			t.Errorf("%s: 纹理颜色 %v", tt.property, mean)
+		}
+		if len(cv.Diagnostics) != tt.warnings {
+			t.Errorf("%s: 诊断信息 %v", tt.property, cv.Diagnostics)
+		}
+	}
+}
+
+func TestFbxProps_Values(t *testing.T) {
+	objects := []*fbxTestNode{
+		fbxN("Material", []interface{}{int64(700), fbxObjName("Mat", "Material"), ""},
+			fbxP70(
+				[]interface{}{"Opacity", "double", "Number", "", 1.2345678e-7},
+				[]interface{}{"UserValues", "Vector", "", "U", 0.1234567891, float32(0.5), int32(-2)},
+				[]interface{}{"ShadingModel", "KString", "", "", "phong"}),
+			fbxN("Weights", []interface{}{[]float64{1e-9, 0.333333333333}})),
+	}
+	f, err := os.Open(writeFbxTest(t, t.TempDir(), objects, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scene, err := fbx.Load(f)
+	if err != nil {
+		t.Fatalf("读取失败: %v", err)
+	}
+	obj, ok := scene.ObjectMap[700]
+	if !ok {
+		t.Fatal("没有找到材质")
+	}
+
+	// 数值按类型解码，不经过 String 的 6 位小数
+	props := fbxElementProps(obj.Element())
+	if v := props.float(1, "Opacity"); v != 1.2345678e-7 {
+		t.Errorf("双精度值错误: %v", v)
+	}
+	if c := props.values("UserValues"); len(c) != 3 || c[0] != 0.1234567891 || c[1] != 0.5 || c[2] != -2 {
+		t.Errorf("多个值错误: %v", c)
+	}
+	if v := props.values("ShadingModel"); v != nil {
+		t.Errorf("字符串属性不应有数值: %v", v)
+	}
+	if w := fbxChildArray(obj.Element(), "Weights"); len(w) != 2 || w[0] != 1e-9 || w[1] != 0.333333333333 {
+		t.Errorf("数组值错误: %v", w)
+	}
+}
+
+func TestFbxPose_Local(t *testing.T) {
+	vec := func(name string, x, y, z float64) []interface{} {
+		return []interface{}{name, "Vector3D", "Vector", "", x, y, z}
+	}
+	model := func(id int64, props ...[]interface{}) *fbxTestNode {
+		return fbxN("Model", []interface{}{id, fbxObjName("Node", "Model"), "Null"}, fbxP70(props...))
+	}
+	objects := []*fbxTestNode{
+		model(600, vec("Lcl Translation", 10, 0, 0), vec("Lcl Rotation", 0, 0, 90),
+			vec("RotationPivot", 1, 0, 0), vec("Lcl Scaling", 2, 2, 2)),
+		model(601, vec("Lcl Translation", 0, 5, 0), vec("PreRotation", 0, 0, 90),
+			vec("Lcl Scaling", 3, 1, 1), vec("ScalingPivot", 1, 0, 0)),
+		model(602, vec("Lcl Rotation", 90, 0, 90)),
+		model(603, vec("Lcl Rotation", 90, 0, 90), []interface{}{"RotationOrder", "enum", "", "", int32(5)}),
+	}
+	conns := [][]interface{}{{int64(600), int64(0)}, {int64(601), int64(600)}, {int64(602), int64(0)}, {int64(603), int64(0)}}
+	f, err := os.Open(writeFbxTest(t, t.TempDir(), objects, conns))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scene, err := fbx.Load(f)
+	if err != nil {
+		t.Fatalf("读取失败: %v", err)
+	}
+	pose := newFbxPose(newFbxConnections(scene), nil, 0)
+
+	tests := []struct {
+		name   string
+		m      mat4d.T
+		p      vec3d.T
+		expect vec3d.T
+	}{
+		// 缩放后绕轴心旋转再平移
+		{"轴心", pose.local(600), vec3d.T{1, 0, 0}, vec3d.T{11, 1, 0}},
+		{"轴心原点", pose.local(600), vec3d.T{0, 0, 0}, vec3d.T{11, -1, 0}},
+		// 绕缩放轴心缩放，再做预旋转
+		{"预旋转", pose.local(601), vec3d.T{2, 0, 0}, vec3d.T{0, 9, 0}},
+		{"全局", pose.global(601), vec3d.T{2, 0, 0}, vec3d.T{-7, -1, 0}},
+		// 默认 XYZ 顺序先绕 X 旋转，ZYX 先绕 Z 旋转
+		{"XYZ", pose.local(602), vec3d.T{0, 1, 0}, vec3d.T{0, 0, 1}},
+		{"ZYX", pose.local(603), vec3d.T{0, 1, 0}, vec3d.T{-1, 0, 0}},
+	}
+	for _, tt := range tests {
+		got := tt.m.MulVec3W(&tt.p, 1)
+		for i := range got {
+			if math.Abs(got[i]-tt.expect[i]) > 1e-9 {
+				t.Errorf("%s: %v, 期望 %v", tt.name, got, tt.expect)
+				break
+			}
+		}
+	}
+}
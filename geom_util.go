@@ -0,0 +1,33 @@
+package asset3d
+
+import (
+	mat4d "github.com/flywave/go3d/float64/mat4"
+	vec3d "github.com/flywave/go3d/float64/vec3"
+)
+
+// mulMat4 返回 a·b，b 的变换先作用于顶点
+func mulMat4(a, b mat4d.T) mat4d.T {
+	var m mat4d.T
+	m.AssignMul(&a, &b)
+	return m
+}
+
+// transformBox 返回包围盒八个角点变换后的包围盒
+func transformBox(bx *vec3d.Box, m *mat4d.T) *vec3d.Box {
+	res := vec3d.MinBox
+	for i := 0; i < 8; i++ {
+		c := vec3d.T{bx.Min[0], bx.Min[1], bx.Min[2]}
+		if i&1 != 0 {
+			c[0] = bx.Max[0]
+		}
+		if i&2 != 0 {
+			c[1] = bx.Max[1]
+		}
+		if i&4 != 0 {
+			c[2] = bx.Max[2]
+		}
+		c = m.MulVec3(&c)
+		res.Extend(&c)
+	}
+	return &res
+}
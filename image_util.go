@@ -2,12 +2,14 @@ package asset3d
 
 import (
 	"errors"
+	"fmt"
 	"image"
 	"image/color"
 	"image/gif"
 	"image/jpeg"
 	"image/png"
 	"io"
+	"math"
 	"os"
 
 	"github.com/chai2010/tiff"
@@ -17,29 +19,41 @@ import (
 )
 
 func convertTex(path string, texId int) (*mst.Texture, error) {
-	f, err := os.Open(path)
+	img, err := loadImage(path)
 	if err != nil {
 		return nil, err
 	}
-	_, ft, err := image.DecodeConfig(f)
+	return imageToTex(img, texId), nil
+}
+
+func loadImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
-	f.Seek(0, 0)
-	img, err := readImage(f, ft)
+	defer f.Close()
+	return decodeImage(f)
+}
+
+func decodeImage(rd io.ReadSeeker) (image.Image, error) {
+	_, ft, err := image.DecodeConfig(rd)
 	if err != nil {
 		return nil, err
 	}
+	rd.Seek(0, io.SeekStart)
+	return readImage(rd, ft)
+}
+
+func imageToTex(img image.Image, texId int) *mst.Texture {
 	bd := img.Bounds()
 	buf := []byte{}
-	for y := 0; y < bd.Dy(); y++ {
-		for x := 0; x < bd.Dx(); x++ {
+	for y := bd.Min.Y; y < bd.Max.Y; y++ {
+		for x := bd.Min.X; x < bd.Max.X; x++ {
 			cl := img.At(x, y)
 			r, g, b, a := color.RGBAModel.Convert(cl).RGBA()
 			buf = append(buf, byte(r&0xff), byte(g&0xff), byte(b&0xff), byte(a&0xff))
 		}
 	}
-	f.Close()
 
 	t := &mst.Texture{}
 	t.Id = int32(texId)
@@ -47,7 +61,120 @@ func convertTex(path string, texId int) (*mst.Texture, error) {
 	t.Size = [2]uint64{uint64(bd.Dx()), uint64(bd.Dy())}
 	t.Compressed = mst.TEXTURE_COMPRESSED_ZLIB
 	t.Data = mst.CompressImage(buf)
-	return t, nil
+	return t
+}
+
+// texToImage 解压 MST 纹理并还原为图像，与 imageToTex 相反，行顺序保持不变
+func texToImage(tex *mst.Texture) (image.Image, error) {
+	data := tex.Data
+	if tex.Compressed == mst.TEXTURE_COMPRESSED_ZLIB {
+		var err error
+		if data, err = mst.DecompressImage(data); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+	var sz uint64
+	switch tex.Format {
+	case mst.TEXTURE_FORMAT_R:
+		sz = 1
+	case mst.TEXTURE_FORMAT_RGB:
+		sz = 3
+	case mst.TEXTURE_FORMAT_RGBA:
+		sz = 4
+	default:
+		return nil, fmt.Errorf("unsupported texture format %d", tex.Format)
+	}
+	if uint64(len(data)) < tex.Size[0]*tex.Size[1]*sz {
+		return nil, errors.New("texture data is too short")
+	}
+	raw := *tex
+	raw.Data = data
+	raw.Compressed = 0
+	return mst.LoadTexture(&raw, false)
+}
+
+// imageWithAlpha 使用 mask 的灰度作为 base 的透明度，base 为空时使用纯色
+func imageWithAlpha(base image.Image, cl [3]byte, mask image.Image) image.Image {
+	bd := mask.Bounds()
+	if base != nil {
+		bd = base.Bounds()
+	}
+	mbd := mask.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, bd.Dx(), bd.Dy()))
+	for y := 0; y < bd.Dy(); y++ {
+		for x := 0; x < bd.Dx(); x++ {
+			c := color.NRGBA{R: cl[0], G: cl[1], B: cl[2], A: 255}
+			if base != nil {
+				c = color.NRGBAModel.Convert(base.At(bd.Min.X+x, bd.Min.Y+y)).(color.NRGBA)
+			}
+			mx := mbd.Min.X + x*mbd.Dx()/bd.Dx()
+			my := mbd.Min.Y + y*mbd.Dy()/bd.Dy()
+			g := color.GrayModel.Convert(mask.At(mx, my)).(color.Gray)
+			c.A = byte(uint32(c.A) * uint32(g.Y) / 255)
+			out.SetNRGBA(x, y, c)
+		}
+	}
+	return out
+}
+
+// heightToNormal 将凹凸(高度)贴图转换为切线空间法线贴图
+func heightToNormal(img image.Image, strength float64) image.Image {
+	bd := img.Bounds()
+	w, h := bd.Dx(), bd.Dy()
+	heights := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			g := color.Gray16Model.Convert(img.At(bd.Min.X+x, bd.Min.Y+y)).(color.Gray16)
+			heights[y*w+x] = float64(g.Y) / 0xffff
+		}
+	}
+	at := func(x, y int) float64 {
+		x = (x + w) % w
+		y = (y + h) % h
+		return heights[y*w+x]
+	}
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx := (at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1)) - (at(x-1, y-1) + 2*at(x-1, y) + at(x-1, y+1))
+			dy := (at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1)) - (at(x-1, y-1) + 2*at(x, y-1) + at(x+1, y-1))
+			n := [3]float64{-dx * strength, -dy * strength, 1}
+			l := math.Sqrt(n[0]*n[0] + n[1]*n[1] + n[2]*n[2])
+			out.SetNRGBA(x, y, color.NRGBA{
+				R: byte((n[0]/l + 1) * 127.5),
+				G: byte((n[1]/l + 1) * 127.5),
+				B: byte((n[2]/l + 1) * 127.5),
+				A: 255,
+			})
+		}
+	}
+	return out
+}
+
+// imageMean 图像的平均颜色，范围 [0, 1]，大图最多采样约 256x256 个像素
+func imageMean(img image.Image) (mean [3]float64, ok bool) {
+	bd := img.Bounds()
+	step := 1
+	for bd.Dx()/step > 256 || bd.Dy()/step > 256 {
+		step *= 2
+	}
+	n := 0
+	for y := bd.Min.Y; y < bd.Max.Y; y += step {
+		for x := bd.Min.X; x < bd.Max.X; x += step {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			mean[0] += float64(c.R)
+			mean[1] += float64(c.G)
+			mean[2] += float64(c.B)
+			n++
+		}
+	}
+	if n == 0 {
+		return mean, false
+	}
+	for i := range mean {
+		mean[i] /= float64(n) * 255
+	}
+	return mean, true
 }
 
 func readImage(rd io.Reader, ft string) (image.Image, error) {
@@ -1,6 +1,8 @@
 package asset3d
 
 import (
+	"image"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
@@ -13,14 +15,79 @@ import (
 	"github.com/flywave/go3d/vec3"
 )
 
+// ObjSplitMode selects how OBJ faces are distributed over MST nodes.
+type ObjSplitMode int
+
+const (
+	// ObjSplitObject emits one node per `o` statement; faces outside any object are split by `g`.
+	ObjSplitObject ObjSplitMode = iota
+	// ObjSplitGroup emits one node per `g` statement within each object.
+	ObjSplitGroup
+	// ObjSplitMaterial emits one node per `usemtl` material.
+	ObjSplitMaterial
+	// ObjSplitSingle puts every face into a single node.
+	ObjSplitSingle
+)
+
 type ObjToMst struct {
+	// SplitMode selects how faces are grouped into MST nodes
+	SplitMode ObjSplitMode
+	// NodeNames records the object, group or material name of each emitted node
+	NodeNames map[*mst.MeshNode]string
+	// MemoryBudget bounds the memory of ConvertStream in bytes, DefaultObjMemoryBudget when 0
+	MemoryBudget int64
+
 	currentPath string
+	materials   map[string]*gobj.Material
+	mtlMaps     map[string]objMaterialMaps
+	texId       int
+	texCache    map[string]*mst.Texture
+	imgCache    map[string]image.Image
+}
+
+// objAttribSource resolves the 0-based vertex attribute indices of face corners.
+type objAttribSource interface {
+	position(i int) (vec3.T, bool)
+	texCoord(i int) (vec2.T, bool)
+	normal(i int) (vec3.T, bool)
+	color(i int) ([3]byte, bool)
+	hasColor() bool
+}
+
+// objNode collects the faces of one output node.
+type objNode struct {
+	name   string
+	node   *mst.MeshNode
+	index  *objVertexIndex
+	groups map[string]*mst.MeshTriangle
+	// order lists the material groups in order of first use
+	order []*mst.MeshTriangle
+}
+
+// objMeshBuilder distributes faces over output nodes and material groups.
+type objMeshBuilder struct {
+	obj              *ObjToMst
+	src              objAttribSource
+	nodeMap          map[string]*objNode
+	nodes            []*objNode
+	materialIndexMap map[string]int
+	ext              vec3d.Box
+	vertices, faces  int
+}
+
+func (obj *ObjToMst) newMeshBuilder(src objAttribSource) *objMeshBuilder {
+	return &objMeshBuilder{
+		obj:              obj,
+		src:              src,
+		nodeMap:          make(map[string]*objNode),
+		materialIndexMap: make(map[string]int),
+		ext:              vec3d.MinBox,
+	}
 }
 
 func (obj *ObjToMst) Convert(path string) (*mst.Mesh, *[6]float64, error) {
 	obj.currentPath = path
-	ext := vec3d.MinBox
-	reader := &gobj.ObjReader{}
+	obj.NodeNames = make(map[*mst.MeshNode]string)
 
 	file, err := os.Open(path)
 	if err != nil {
@@ -28,66 +95,136 @@ func (obj *ObjToMst) Convert(path string) (*mst.Mesh, *[6]float64, error) {
 	}
 	defer file.Close()
 
-	err = reader.Read(file)
+	reader, err := readObj(file)
 	if err != nil {
 		return nil, nil, err
 	}
+	obj.resetTextures()
+	obj.loadMaterials(obj.mtlPaths(reader.mtllibs))
 
-	mesh := mst.NewMesh()
-	meshNode := &mst.MeshNode{}
+	// Process all faces
+	bd := obj.newMeshBuilder(reader)
+	for fi, face := range reader.F {
+		bd.addFace(face, reader.faces[fi], fi)
+	}
 
-	// Group faces by material
-	materialGroups := make(map[string]*mst.MeshTriangle)
-	materialIndexMap := make(map[string]int)
-	materialCounter := 0
+	mesh, ext := bd.build(obj.NodeNames)
+	return mesh, ext, nil
+}
 
-	// Process all faces
-	for _, face := range reader.F {
-		materialName := face.Material
-		if materialName == "" {
-			materialName = "default"
-		}
+func (obj *ObjToMst) resetTextures() {
+	obj.texId = 0
+	obj.texCache = make(map[string]*mst.Texture)
+	obj.imgCache = make(map[string]image.Image)
+}
 
-		// Get or create material group
-		mtg, exists := materialGroups[materialName]
-		if !exists {
-			mtg = &mst.MeshTriangle{Batchid: int32(materialCounter)}
-			materialGroups[materialName] = mtg
-			materialIndexMap[materialName] = materialCounter
-			materialCounter++
-		}
+// addFace triangulates a face into its node; serial numbers faces so that
+// faces with smoothing off keep separate vertices.
+func (bd *objMeshBuilder) addFace(face gobj.Face, info objFaceInfo, serial int) {
+	obj := bd.obj
+	materialName := face.Material
+	if materialName == "" {
+		materialName = "default"
+	}
+
+	// Get or create the output node; nodes are emitted in order of first appearance
+	key, name := obj.nodeKey(info, materialName)
+	nd, exists := bd.nodeMap[key]
+	if !exists {
+		nd = &objNode{name: name, node: &mst.MeshNode{}, groups: make(map[string]*mst.MeshTriangle)}
+		nd.index = newObjVertexIndex(nd.node)
+		bd.nodeMap[key] = nd
+		bd.nodes = append(bd.nodes, nd)
+	}
+
+	// Get or create material group
+	if _, exists := bd.materialIndexMap[materialName]; !exists {
+		bd.materialIndexMap[materialName] = len(bd.materialIndexMap)
+	}
+	mtg, exists := nd.groups[materialName]
+	if !exists {
+		mtg = &mst.MeshTriangle{Batchid: int32(bd.materialIndexMap[materialName])}
+		nd.groups[materialName] = mtg
+		nd.order = append(nd.order, mtg)
+	}
+
+	// Corners without normals share a vertex only within the same smoothing
+	// group; with smoothing off each face keeps its own vertices.
+	smooth := info.smooth
+	if smooth == 0 {
+		smooth = -(serial + 1)
+	}
+
+	// The diffuse map's -o/-s options are baked into the texture coordinates
+	uv := obj.mtlMaps[materialName]["map_kd"]
+	if !uv.uvTransform() {
+		uv = nil
+	}
 
-		// Process face vertices
-		if len(face.Corners) >= 3 {
-			// Triangulate face
-			triangles := obj.triangulateFace(face)
+	// Process face vertices
+	if len(face.Corners) >= 3 {
+		// Triangulate face
+		triangles := obj.triangulateFace(face, bd.src)
 
-			// Process each triangle
-			for _, triangle := range triangles {
-				obj.processTriangle(mtg, triangle, reader, meshNode, &ext)
+		// Process each triangle
+		before := len(nd.node.Vertices)
+		for _, triangle := range triangles {
+			if obj.processTriangle(mtg, triangle, bd.src, nd.index, smooth, uv, &bd.ext) {
+				bd.faces++
 			}
 		}
+		bd.vertices += len(nd.node.Vertices) - before
 	}
+}
 
-	// Collect all non-empty material groups
-	var faceGroups []*mst.MeshTriangle
-	for _, group := range materialGroups {
-		if len(group.Faces) > 0 {
-			faceGroups = append(faceGroups, group)
+// build assembles the mesh from the collected nodes, recording node names in names.
+func (bd *objMeshBuilder) build(names map[*mst.MeshNode]string) (*mst.Mesh, *[6]float64) {
+	mesh := mst.NewMesh()
+	for _, nd := range bd.nodes {
+		// Collect all non-empty material groups
+		var faceGroups []*mst.MeshTriangle
+		for _, group := range nd.order {
+			if len(group.Faces) > 0 {
+				faceGroups = append(faceGroups, group)
+			}
+		}
+		if len(faceGroups) == 0 {
+			continue
+		}
+		nd.node.FaceGroup = faceGroups
+		nd.index.finish()
+		mesh.Nodes = append(mesh.Nodes, nd.node)
+		if names != nil {
+			names[nd.node] = nd.name
 		}
 	}
-	meshNode.FaceGroup = faceGroups
 
 	// Create materials
-	materials := obj.createMaterials(reader, materialIndexMap)
-	mesh.Materials = materials
-
-	mesh.Nodes = append(mesh.Nodes, meshNode)
+	mesh.Materials = bd.obj.createMaterials(bd.materialIndexMap)
+	return mesh, bd.ext.Array()
+}
 
-	return mesh, ext.Array(), nil
+// nodeKey returns the key identifying the output node of a face and the node's name.
+func (obj *ObjToMst) nodeKey(info objFaceInfo, material string) (string, string) {
+	switch obj.SplitMode {
+	case ObjSplitObject:
+		if info.object != "" {
+			return "o:" + info.object, info.object
+		}
+		return "g:" + info.group, info.group
+	case ObjSplitGroup:
+		name := info.group
+		if name == "" {
+			name = info.object
+		}
+		return info.object + "\x00" + info.group, name
+	case ObjSplitMaterial:
+		return material, material
+	}
+	return "", ""
 }
 
-func (obj *ObjToMst) createMaterials(reader *gobj.ObjReader, materialIndexMap map[string]int) []mst.MeshMaterial {
+func (obj *ObjToMst) createMaterials(materialIndexMap map[string]int) []mst.MeshMaterial {
 	materials := make([]mst.MeshMaterial, len(materialIndexMap))
 
 	// If no materials, create a default one
@@ -97,40 +234,26 @@ func (obj *ObjToMst) createMaterials(reader *gobj.ObjReader, materialIndexMap ma
 		}}
 	}
 
-	// Load materials from MTL file if available
-	var objMaterials map[string]*gobj.Material
-	if reader.MTL != "" {
-		mtlPath := reader.MTL
-		if !strings.HasPrefix(mtlPath, "/") {
-			// Try to find MTL file in same directory as OBJ
-			objDir := filepath.Dir(obj.currentPath)
-			mtlPath = filepath.Join(objDir, reader.MTL)
-		}
-
-		loadedMaterials, err := gobj.ReadMaterials(mtlPath)
-		if err == nil {
-			objMaterials = loadedMaterials
-		}
+	// Convert in first-use order so that texture ids are reproducible
+	names := make([]string, len(materialIndexMap))
+	for name, index := range materialIndexMap {
+		names[index] = name
 	}
 
-	for name, index := range materialIndexMap {
+	for index, name := range names {
 		var material mst.MeshMaterial
 
 		// Get corresponding go-obj material
-		var objMat *gobj.Material
-		if objMaterials != nil {
-			objMat = objMaterials[name]
-		}
+		objMat := obj.materials[name]
 
 		if objMat == nil {
 			// Create default BaseMaterial if no MTL data
 			material = &mst.BaseMaterial{
-				Color:        [3]byte{200, 200, 200},
-				Transparency: 1.0,
+				Color: [3]byte{200, 200, 200},
 			}
 		} else {
 			// Convert based on illumination model and material properties
-			material = obj.convertMaterial(objMat)
+			material = obj.convertMaterial(objMat, obj.mtlMaps[name])
 		}
 
 		materials[index] = material
@@ -139,10 +262,10 @@ func (obj *ObjToMst) createMaterials(reader *gobj.ObjReader, materialIndexMap ma
 	return materials
 }
 
-func (obj *ObjToMst) convertMaterial(objMat *gobj.Material) mst.MeshMaterial {
+func (obj *ObjToMst) convertMaterial(objMat *gobj.Material, maps objMaterialMaps) mst.MeshMaterial {
 	// Determine material type based on properties
 	hasTexture := objMat.DiffuseTexture != "" || objMat.AmbientTexture != "" ||
-		objMat.SpecularTexture != "" || objMat.EmissiveTexture != ""
+		objMat.SpecularTexture != "" || objMat.EmissiveTexture != "" || len(maps) > 0
 
 	// Convert colors from float32[3] to byte[3]
 	diffuseColor := obj.float32ToByteColor(objMat.Diffuse)
@@ -150,18 +273,29 @@ func (obj *ObjToMst) convertMaterial(objMat *gobj.Material) mst.MeshMaterial {
 	specularColor := obj.float32ToByteColor(objMat.Specular)
 	emissiveColor := obj.float32ToByteColor(objMat.Emissive)
 
+	// MST has no emissive texture slot; use the map's average color
+	if mean, ok := obj.textureMean(maps["map_ke"]); ok {
+		emissiveColor = colorToBytes(mean, 1)
+	}
+
+	// Load textures: map_Kd with map_d as alpha, and norm or bump as normal map
+	texture := obj.baseTexture(maps, diffuseColor)
+	normal := obj.normalTexture(maps)
+
 	// Check if this is a PBR material (has metallic/roughness properties)
-	if objMat.Metallic > 0 || objMat.Roughness > 0 {
+	if objMat.Metallic > 0 || objMat.Roughness > 0 || maps["map_pm"] != nil || maps["map_pr"] != nil {
 		pbrMat := &mst.PbrMaterial{
 			TextureMaterial: mst.TextureMaterial{
 				BaseMaterial: mst.BaseMaterial{
 					Color:        diffuseColor,
-					Transparency: float32(objMat.Opacity),
+					Transparency: objTransparency(objMat),
 				},
+				Texture: texture,
+				Normal:  normal,
 			},
 			Emissive:            emissiveColor,
-			Metallic:            objMat.Metallic,
-			Roughness:           objMat.Roughness,
+			Metallic:            obj.scalarMap(objMat.Metallic, maps["map_pm"]),
+			Roughness:           obj.scalarMap(objMat.Roughness, maps["map_pr"]),
 			Reflectance:         0.5,
 			AmbientOcclusion:    1.0,
 			ClearCoat:           objMat.ClearcoatThickness,
@@ -171,18 +305,6 @@ func (obj *ObjToMst) convertMaterial(objMat *gobj.Material) mst.MeshMaterial {
 			SheenColor:          [3]byte{128, 128, 128},
 			SubSurfaceColor:     [3]byte{128, 128, 128},
 		}
-
-		// Load textures using convertTex
-		if objMat.DiffuseTexture != "" {
-			if tex := obj.loadTexture(objMat.DiffuseTexture); tex != nil {
-				pbrMat.Texture = tex
-			}
-		}
-		if objMat.BumpTexture != "" {
-			if tex := obj.loadTexture(objMat.BumpTexture); tex != nil {
-				pbrMat.Normal = tex
-			}
-		}
 		return pbrMat
 	}
 
@@ -193,8 +315,10 @@ func (obj *ObjToMst) convertMaterial(objMat *gobj.Material) mst.MeshMaterial {
 				TextureMaterial: mst.TextureMaterial{
 					BaseMaterial: mst.BaseMaterial{
 						Color:        diffuseColor,
-						Transparency: float32(objMat.Opacity),
+						Transparency: objTransparency(objMat),
 					},
+					Texture: texture,
+					Normal:  normal,
 				},
 				Ambient:  ambientColor,
 				Diffuse:  diffuseColor,
@@ -204,18 +328,6 @@ func (obj *ObjToMst) convertMaterial(objMat *gobj.Material) mst.MeshMaterial {
 			Shininess:   float32(objMat.Shininess * 100), // Convert from OBJ range to typical shininess
 			Specularity: 1.0,
 		}
-
-		// Load textures using convertTex
-		if objMat.DiffuseTexture != "" {
-			if tex := obj.loadTexture(objMat.DiffuseTexture); tex != nil {
-				phongMat.Texture = tex
-			}
-		}
-		if objMat.BumpTexture != "" {
-			if tex := obj.loadTexture(objMat.BumpTexture); tex != nil {
-				phongMat.Normal = tex
-			}
-		}
 		return phongMat
 	}
 
@@ -225,20 +337,15 @@ func (obj *ObjToMst) convertMaterial(objMat *gobj.Material) mst.MeshMaterial {
 			TextureMaterial: mst.TextureMaterial{
 				BaseMaterial: mst.BaseMaterial{
 					Color:        diffuseColor,
-					Transparency: float32(objMat.Opacity),
+					Transparency: objTransparency(objMat),
 				},
+				Texture: texture,
+				Normal:  normal,
 			},
 			Ambient:  ambientColor,
 			Diffuse:  diffuseColor,
 			Emissive: emissiveColor,
 		}
-
-		// Load textures using convertTex
-		if objMat.DiffuseTexture != "" {
-			if tex := obj.loadTexture(objMat.DiffuseTexture); tex != nil {
-				lambertMat.Texture = tex
-			}
-		}
 		return lambertMat
 	}
 
@@ -247,35 +354,105 @@ func (obj *ObjToMst) convertMaterial(objMat *gobj.Material) mst.MeshMaterial {
 		textureMat := &mst.TextureMaterial{
 			BaseMaterial: mst.BaseMaterial{
 				Color:        diffuseColor,
-				Transparency: float32(objMat.Opacity),
+				Transparency: objTransparency(objMat),
 			},
+			Texture: texture,
+			Normal:  normal,
+		}
+		return textureMat
+	}
+
+	// Default to BaseMaterial
+	return &mst.BaseMaterial{
+		Color:        diffuseColor,
+		Transparency: objTransparency(objMat),
+	}
+}
+
+// objTransparency converts the MTL dissolve `d`, which is an opacity, to
+// the MST transparency.
+func objTransparency(objMat *gobj.Material) float32 {
+	t := 1 - objMat.Opacity
+	if t < 0 || math.IsNaN(t) {
+		return 0
+	}
+	if t > 1 {
+		return 1
+	}
+	return float32(t)
+}
+
+// scalarMap multiplies a metallic or roughness value by the mean of its map;
+// without a scalar the map's mean is used directly.
+func (obj *ObjToMst) scalarMap(value float32, m *objTextureMap) float32 {
+	mean, ok := obj.textureMean(m)
+	if !ok {
+		return value
+	}
+	if value <= 0 {
+		value = 1
+	}
+	return value * float32(mean[0])
+}
+
+// mtlPaths resolves every referenced material library relative to the OBJ
+// file. A statement naming several files loads each of them, unless the
+// whole statement is the name of an existing file containing spaces.
+func (obj *ObjToMst) mtlPaths(mtllibs [][]string) []string {
+	objDir := filepath.Dir(obj.currentPath)
+	resolve := func(name string) string {
+		if strings.HasPrefix(name, "/") {
+			return name
 		}
+		// Try to find MTL file in same directory as OBJ
+		return filepath.Join(objDir, name)
+	}
 
-		// Load textures using convertTex
-		if objMat.DiffuseTexture != "" {
-			if tex := obj.loadTexture(objMat.DiffuseTexture); tex != nil {
-				textureMat.Texture = tex
+	var paths []string
+	seen := make(map[string]bool)
+	for _, names := range mtllibs {
+		if len(names) > 1 {
+			joined := resolve(strings.Join(names, " "))
+			if _, err := os.Stat(joined); err == nil {
+				names = []string{strings.Join(names, " ")}
 			}
 		}
-		if objMat.BumpTexture != "" {
-			if tex := obj.loadTexture(objMat.BumpTexture); tex != nil {
-				textureMat.Normal = tex
+		for _, name := range names {
+			p := resolve(name)
+			if !seen[p] {
+				seen[p] = true
+				paths = append(paths, p)
 			}
 		}
-
-		return textureMat
 	}
+	return paths
+}
 
-	// Default to BaseMaterial
-	return &mst.BaseMaterial{
-		Color:        diffuseColor,
-		Transparency: float32(objMat.Opacity),
+// loadMaterials reads all material libraries; a material defined in several
+// libraries keeps its first definition.
+func (obj *ObjToMst) loadMaterials(paths []string) {
+	obj.materials = make(map[string]*gobj.Material)
+	obj.mtlMaps = make(map[string]objMaterialMaps)
+	for _, p := range paths {
+		loaded, err := gobj.ReadMaterials(p)
+		if err != nil {
+			continue
+		}
+		maps, _ := readObjMaterialMaps(p)
+		for name, m := range loaded {
+			if _, ok := obj.materials[name]; !ok {
+				obj.materials[name] = m
+				obj.mtlMaps[name] = maps[name]
+			}
+		}
 	}
 }
 
-func (obj *ObjToMst) loadTexture(texturePath string) *mst.Texture {
+// resolveTexturePath resolves a texture relative to the OBJ file, falling back
+// to its base name; empty when the file does not exist.
+func (obj *ObjToMst) resolveTexturePath(texturePath string) string {
 	if texturePath == "" {
-		return nil
+		return ""
 	}
 
 	// Resolve texture path relative to OBJ file
@@ -285,20 +462,13 @@ func (obj *ObjToMst) loadTexture(texturePath string) *mst.Texture {
 	// Check if file exists
 	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
 		// Try alternative paths
-		baseName := filepath.Base(texturePath)
+		baseName := filepath.Base(strings.ReplaceAll(texturePath, "\\", "/"))
 		fullPath = filepath.Join(objDir, baseName)
 		if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-			return nil
+			return ""
 		}
 	}
-
-	// Use convertTex to load and process the texture
-	texture, err := convertTex(fullPath, 0)
-	if err != nil {
-		return nil
-	}
-
-	return texture
+	return fullPath
 }
 
 func (obj *ObjToMst) float32ToByteColor(color []float32) [3]byte {
@@ -313,88 +483,172 @@ func (obj *ObjToMst) float32ToByteColor(color []float32) [3]byte {
 	return [3]byte{r, g, b}
 }
 
-func (obj *ObjToMst) triangulateFace(face gobj.Face) [][]gobj.FaceCorner {
-	// Simple fan triangulation for convex polygons
+func (obj *ObjToMst) triangulateFace(face gobj.Face, src objAttribSource) [][]gobj.FaceCorner {
+	return triangulateObjCorners(face, src.position)
+}
+
+// triangulateObjFace splits a face into triangles by ear clipping on the polygon's
+// best-fit plane, so concave n-gons do not produce overlapping triangles.
+// Faces whose corners cannot be resolved fall back to a fan.
+func triangulateObjFace(face gobj.Face, v []vec3.T) [][]gobj.FaceCorner {
+	return triangulateObjCorners(face, func(i int) (vec3.T, bool) {
+		if i < 0 || i >= len(v) {
+			return vec3.T{}, false
+		}
+		return v[i], true
+	})
+}
+
+func triangulateObjCorners(face gobj.Face, position func(int) (vec3.T, bool)) [][]gobj.FaceCorner {
+	if len(face.Corners) < 3 {
+		return nil
+	}
 	if len(face.Corners) == 3 {
 		return [][]gobj.FaceCorner{face.Corners}
 	}
 
-	var triangles [][]gobj.FaceCorner
-	for i := 1; i < len(face.Corners)-1; i++ {
-		triangle := []gobj.FaceCorner{
-			face.Corners[0],
-			face.Corners[i],
-			face.Corners[i+1],
+	ring := make([]vec3d.T, len(face.Corners))
+	for i, corner := range face.Corners {
+		if p, ok := position(corner.VertexIndex); ok {
+			ring[i] = vec3d.T{float64(p[0]), float64(p[1]), float64(p[2])}
 		}
-		triangles = append(triangles, triangle)
+	}
+
+	var triangles [][]gobj.FaceCorner
+	for _, tri := range triangulatePolygon(ring, nil) {
+		triangles = append(triangles, []gobj.FaceCorner{
+			face.Corners[tri[0]],
+			face.Corners[tri[1]],
+			face.Corners[tri[2]],
+		})
 	}
 	return triangles
 }
 
-func (obj *ObjToMst) processTriangle(mtg *mst.MeshTriangle, triangle []gobj.FaceCorner, reader *gobj.ObjReader, meshNode *mst.MeshNode, ext *vec3d.Box) {
+// processTriangle adds a triangle to mtg, reporting whether it was kept.
+func (obj *ObjToMst) processTriangle(mtg *mst.MeshTriangle, triangle []gobj.FaceCorner, src objAttribSource, index *objVertexIndex, smooth int, uv *objTextureMap, ext *vec3d.Box) bool {
 	// Ensure we have exactly 3 vertices
 	if len(triangle) != 3 {
-		return
+		return false
 	}
 
-	// Get vertex positions
-	var positions [3]vec3.T
-	var texCoords [3]vec2.T
-	var normals [3]vec3.T
-
+	var face [3]uint32
 	for i, corner := range triangle {
-		// Vertex position
-		if corner.VertexIndex >= 0 && corner.VertexIndex < len(reader.V) {
-			positions[i] = reader.V[corner.VertexIndex]
-		} else {
-			positions[i] = vec3.T{0, 0, 0}
-		}
+		face[i] = index.vertex(corner, src, smooth, uv, ext)
+	}
 
-		// Texture coordinate
-		if corner.TexCoordIndex >= 0 && corner.TexCoordIndex < len(reader.VT) {
-			texCoords[i] = reader.VT[corner.TexCoordIndex]
-		} else {
-			texCoords[i] = vec2.T{0, 0}
-		}
+	// Corners that collapse onto the same vertex give a degenerate face
+	if face[0] == face[1] || face[1] == face[2] || face[2] == face[0] {
+		return false
+	}
+	mtg.Faces = append(mtg.Faces, &mst.Face{Vertex: face})
+	return true
+}
 
-		// Normal
-		if corner.NormalIndex >= 0 && corner.NormalIndex < len(reader.VN) {
-			normals[i] = reader.VN[corner.NormalIndex]
-		} else {
-			// Calculate flat normal
-			normals[i] = obj.calculateNormal(positions[0], positions[1], positions[2])
-		}
+// objVertexKey identifies a unique OBJ face corner; -1 marks a missing vt or vn.
+// smooth separates corners without vn that belong to different smoothing groups.
+// uv separates texture coordinates transformed by different texture options.
+type objVertexKey struct {
+	v, vt, vn int
+	smooth    int
+	uv        *objTextureMap
+}
 
-		// Extend bounding box
-		ext.Extend(&vec3d.T{float64(positions[i][0]), float64(positions[i][1]), float64(positions[i][2])})
-	}
+// objVertexIndex builds an indexed vertex buffer so that corners sharing the
+// same (v, vt, vn) tuple share one MST vertex.
+type objVertexIndex struct {
+	node  *mst.MeshNode
+	index map[objVertexKey]uint32
+	// smooth marks vertices without a vn, whose normal is averaged from the
+	// adjacent faces of the same smoothing group
+	smooth []bool
+}
+
+func newObjVertexIndex(node *mst.MeshNode) *objVertexIndex {
+	return &objVertexIndex{node: node, index: make(map[objVertexKey]uint32)}
+}
 
-	// Add vertices to mesh node
-	baseIndex := uint32(len(meshNode.Vertices))
-	for i := 0; i < 3; i++ {
-		meshNode.Vertices = append(meshNode.Vertices, vec3.T(positions[i]))
-		meshNode.TexCoords = append(meshNode.TexCoords, texCoords[i])
-		meshNode.Normals = append(meshNode.Normals, normals[i])
+func (x *objVertexIndex) vertex(corner gobj.FaceCorner, src objAttribSource, smooth int, uv *objTextureMap, ext *vec3d.Box) uint32 {
+	key := objVertexKey{v: -1, vt: -1, vn: -1}
+	position, okV := src.position(corner.VertexIndex)
+	if okV {
+		key.v = corner.VertexIndex
+	}
+	texCoord, okT := src.texCoord(corner.TexCoordIndex)
+	if okT {
+		key.vt = corner.TexCoordIndex
+		key.uv = uv
+	}
+	normal, okN := src.normal(corner.NormalIndex)
+	if okN {
+		key.vn = corner.NormalIndex
+	} else {
+		key.smooth = smooth
+	}
+	if idx, ok := x.index[key]; ok {
+		return idx
 	}
 
-	// Add face
-	mtg.Faces = append(mtg.Faces, &mst.Face{
-		Vertex: [3]uint32{baseIndex, baseIndex + 1, baseIndex + 2},
-	})
+	if okT && uv != nil {
+		texCoord = vec2.T{
+			texCoord[0]*uv.scale[0] + uv.offset[0],
+			texCoord[1]*uv.scale[1] + uv.offset[1],
+		}
+	}
+	ext.Extend(&vec3d.T{float64(position[0]), float64(position[1]), float64(position[2])})
+
+	idx := uint32(len(x.node.Vertices))
+	x.node.Vertices = append(x.node.Vertices, position)
+	x.node.TexCoords = append(x.node.TexCoords, texCoord)
+	x.node.Normals = append(x.node.Normals, normal)
+	if src.hasColor() {
+		// Colors may start part way through a streamed file
+		for len(x.node.Colors) < int(idx) {
+			x.node.Colors = append(x.node.Colors, [3]byte{})
+		}
+		cl, _ := src.color(corner.VertexIndex)
+		x.node.Colors = append(x.node.Colors, cl)
+	}
+	x.smooth = append(x.smooth, !okN)
+	x.index[key] = idx
+	return idx
 }
 
-func (obj *ObjToMst) calculateNormal(v0, v1, v2 vec3.T) vec3.T {
-	// Calculate cross product for normal
-	e1 := vec3.Sub(&v1, &v0)
-	e2 := vec3.Sub(&v2, &v0)
-	normal := vec3.Cross(&e1, &e2)
+// finish fills in the normals of vertices that had no vn with the
+// area-weighted average of the faces using them.
+func (x *objVertexIndex) finish() {
+	nd := x.node
+	missing := false
+	for _, s := range x.smooth {
+		missing = missing || s
+	}
+	if !missing {
+		return
+	}
 
-	// Normalize
-	length := normal.Length()
-	if length > 0 {
-		return vec3.T{normal[0] / length, normal[1] / length, normal[2] / length}
+	for _, g := range nd.FaceGroup {
+		for _, f := range g.Faces {
+			v0, v1, v2 := nd.Vertices[f.Vertex[0]], nd.Vertices[f.Vertex[1]], nd.Vertices[f.Vertex[2]]
+			e1 := vec3.Sub(&v1, &v0)
+			e2 := vec3.Sub(&v2, &v0)
+			n := vec3.Cross(&e1, &e2)
+			for _, i := range f.Vertex {
+				if x.smooth[i] {
+					nd.Normals[i].Add(&n)
+				}
+			}
+		}
+	}
+	for i, s := range x.smooth {
+		if !s {
+			continue
+		}
+		if nd.Normals[i].Length() > 0 {
+			nd.Normals[i].Normalize()
+		} else {
+			nd.Normals[i] = vec3.T{0, 1, 0} // Default normal
+		}
 	}
-	return vec3.T{0, 1, 0} // Default normal
 }
 
 // Ensure ObjToMst implements FormatConvert interface
@@ -0,0 +1,206 @@
+package asset3d
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	mst "github.com/flywave/go-mst"
+)
+
+// MstToObj exports an MST mesh as an OBJ file with a material library of the
+// same name. Textures are decompressed and written as PNG files next to it.
+// Instances are expanded, giving one object per node and transform.
+//
+// MST transparency is written as `d` = 1 - transparency, the convention of the
+// 3DS, DAE, FBX and glTF converters.
+type MstToObj struct {
+	// NodeNames names the objects; unnamed nodes are numbered
+	NodeNames map[*mst.MeshNode]string
+	// Diagnostics lists the textures that could not be decoded
+	Diagnostics Diagnostics
+}
+
+func (cv *MstToObj) Export(mesh *mst.Mesh, path string) error {
+	cv.Diagnostics = nil
+	em := flattenMesh(mesh, cv.NodeNames)
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	mtlName := base + MTL
+
+	// Only the materials in use are written, in first-use order
+	names := make(map[int]string)
+	var used []int
+	for _, nd := range em.nodes {
+		for _, g := range nd.groups {
+			if _, ok := names[g.material]; ok {
+				continue
+			}
+			names[g.material] = "default"
+			if g.material >= 0 {
+				names[g.material] = "material_" + strconv.Itoa(g.material)
+			}
+			used = append(used, g.material)
+		}
+	}
+	texs := newExportTextures(path, &cv.Diagnostics)
+	if err := writeObjFile(filepath.Join(filepath.Dir(path), mtlName), func(w *bufio.Writer) error {
+		return cv.writeMtl(w, em, used, names, texs)
+	}); err != nil {
+		return err
+	}
+	return writeObjFile(path, func(w *bufio.Writer) error {
+		return cv.writeObj(w, em, mtlName, names)
+	})
+}
+
+func (cv *MstToObj) writeObj(w *bufio.Writer, em *exportMesh, mtlName string, names map[int]string) error {
+	w.WriteString("mtllib " + mtlName + "\n")
+	// Indices are 1-based and global; texture coordinates and normals are
+	// numbered separately since not every node has them
+	var vOff, vtOff, vnOff int
+	for _, nd := range em.nodes {
+		w.WriteString("o " + nd.name + "\n")
+		for i, v := range nd.vertices {
+			if len(nd.colors) > 0 {
+				c := nd.colors[i]
+				objWriteFloats(w, "v", v[0], v[1], v[2], float32(c[0])/255, float32(c[1])/255, float32(c[2])/255)
+			} else {
+				objWriteFloats(w, "v", v[0], v[1], v[2])
+			}
+		}
+		for _, vt := range nd.texCoords {
+			objWriteFloats(w, "vt", vt[0], vt[1])
+		}
+		for _, vn := range nd.normals {
+			objWriteFloats(w, "vn", vn[0], vn[1], vn[2])
+		}
+
+		hasVt, hasVn := len(nd.texCoords) > 0, len(nd.normals) > 0
+		for _, g := range nd.groups {
+			w.WriteString("usemtl " + names[g.material] + "\n")
+			for _, f := range g.faces {
+				w.WriteString("f")
+				for _, idx := range f {
+					w.WriteByte(' ')
+					w.WriteString(strconv.Itoa(vOff + int(idx) + 1))
+					if hasVt || hasVn {
+						w.WriteByte('/')
+					}
+					if hasVt {
+						w.WriteString(strconv.Itoa(vtOff + int(idx) + 1))
+					}
+					if hasVn {
+						w.WriteByte('/')
+						w.WriteString(strconv.Itoa(vnOff + int(idx) + 1))
+					}
+				}
+				w.WriteByte('\n')
+			}
+		}
+		vOff += len(nd.vertices)
+		vtOff += len(nd.texCoords)
+		vnOff += len(nd.normals)
+	}
+	return nil
+}
+
+func (cv *MstToObj) writeMtl(w *bufio.Writer, em *exportMesh, used []int, names map[int]string, texs *exportTextures) error {
+	for i, idx := range used {
+		if i > 0 {
+			w.WriteByte('\n')
+		}
+		w.WriteString("newmtl " + names[idx] + "\n")
+		if idx < 0 {
+			objWriteFloats(w, "Kd", objMtlColor(200), objMtlColor(200), objMtlColor(200))
+			w.WriteString("illum 1\n")
+			continue
+		}
+
+		m := newExportMaterial(em.materials[idx])
+		illum := "1"
+		if m.lighting >= 1 {
+			objWriteColor(w, "Ka", m.ambient)
+		}
+		objWriteColor(w, "Kd", m.diffuse)
+		if m.lighting == 2 {
+			objWriteColor(w, "Ks", m.specular)
+			objWriteFloats(w, "Ns", m.shininess)
+			illum = "2"
+		}
+		if m.emissive != [3]byte{} {
+			objWriteColor(w, "Ke", m.emissive)
+		}
+		if m.lighting == 3 {
+			objWriteFloats(w, "Pm", m.metallic)
+			objWriteFloats(w, "Pr", m.roughness)
+		}
+		objWriteFloats(w, "d", 1-m.transparency)
+		w.WriteString("illum " + illum + "\n")
+
+		for _, tm := range []struct {
+			statement string
+			tex       *mst.Texture
+		}{{"map_Kd", m.texture}, {"norm", m.normal}} {
+			name, err := texs.write(tm.tex)
+			if err != nil {
+				return err
+			}
+			if name == "" {
+				continue
+			}
+			w.WriteString(tm.statement)
+			if !tm.tex.Repeated {
+				w.WriteString(" -clamp on")
+			}
+			w.WriteString(" " + name + "\n")
+		}
+	}
+	return nil
+}
+
+func writeObjFile(path string, write func(w *bufio.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// objWriteFloats writes one statement with float32 arguments in their shortest form.
+func objWriteFloats(w *bufio.Writer, statement string, vals ...float32) {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, statement...)
+	for _, v := range vals {
+		buf = append(buf, ' ')
+		buf = strconv.AppendFloat(buf, float64(v), 'g', -1, 32)
+	}
+	buf = append(buf, '\n')
+	w.Write(buf)
+}
+
+func objWriteColor(w *bufio.Writer, statement string, c [3]byte) {
+	objWriteFloats(w, statement, objMtlColor(c[0]), objMtlColor(c[1]), objMtlColor(c[2]))
+}
+
+// objMtlColor places a material color component a quarter step above its byte
+// value, so that readers which truncate, like ObjToMst, and readers which round
+// both recover it. 0 and 255 are written exactly.
+func objMtlColor(c byte) float32 {
+	switch c {
+	case 0:
+		return 0
+	case 255:
+		return 1
+	}
+	return (float32(c) + 0.25) / 255
+}
@@ -0,0 +1,224 @@
+package asset3d
+
+import (
+	"bufio"
+	"image"
+	"os"
+	"strconv"
+	"strings"
+
+	mst "github.com/flywave/go-mst"
+)
+
+// objBumpNormalScale converts a `bump` height map to a normal map, multiplied by `-bm`
+const objBumpNormalScale = 2.0
+
+// objTextureMap is one MTL texture statement with the options that affect conversion.
+type objTextureMap struct {
+	path   string
+	offset [2]float32 // -o u v
+	scale  [2]float32 // -s u v
+	clamp  bool       // -clamp on
+	bm     float32    // -bm, bump multiplier
+}
+
+// uvTransform reports whether -o or -s change the texture coordinates.
+func (m *objTextureMap) uvTransform() bool {
+	return m != nil && (m.offset != [2]float32{} || m.scale != [2]float32{1, 1})
+}
+
+// objMaterialMaps holds the texture statements of one material, keyed by the
+// lower-case statement name; map_bump is stored as bump and map_opacity as map_d.
+type objMaterialMaps map[string]*objTextureMap
+
+// readObjMaterialMaps collects the texture statements and options of an MTL
+// file, which go-obj drops whenever a statement carries options.
+func readObjMaterialMaps(path string) (map[string]objMaterialMaps, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	res := make(map[string]objMaterialMaps)
+	var cur objMaterialMaps
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if hashPos := strings.IndexByte(line, '#'); hashPos != -1 {
+			line = line[:hashPos]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		key := strings.ToLower(fields[0])
+		switch key {
+		case "newmtl":
+			cur = make(objMaterialMaps)
+			res[strings.Join(fields[1:], " ")] = cur
+			continue
+		case "map_bump":
+			key = "bump"
+		case "map_opacity":
+			key = "map_d"
+		}
+		if cur == nil || (key != "bump" && key != "norm" && !strings.HasPrefix(key, "map_")) {
+			continue
+		}
+		if m := parseObjTextureMap(fields[1:]); m.path != "" {
+			cur[key] = m
+		}
+	}
+	return res, scanner.Err()
+}
+
+// parseObjTextureMap parses `[options] filename`; the remaining fields form the
+// file name so that names containing spaces survive.
+func parseObjTextureMap(fields []string) *objTextureMap {
+	m := &objTextureMap{scale: [2]float32{1, 1}, bm: 1}
+	// floats consumes up to n numeric arguments of an option
+	floats := func(i, n int) ([]float32, int) {
+		var res []float32
+		for ; i < len(fields) && len(res) < n; i++ {
+			v, err := strconv.ParseFloat(fields[i], 32)
+			if err != nil {
+				break
+			}
+			res = append(res, float32(v))
+		}
+		return res, i
+	}
+
+	i := 0
+	for i < len(fields) && strings.HasPrefix(fields[i], "-") && len(fields[i]) > 1 {
+		opt := strings.ToLower(fields[i])
+		i++
+		var vals []float32
+		switch opt {
+		case "-o":
+			vals, i = floats(i, 3)
+			copy(m.offset[:], vals)
+		case "-s":
+			vals, i = floats(i, 3)
+			copy(m.scale[:], vals)
+		case "-bm":
+			if vals, i = floats(i, 1); len(vals) == 1 {
+				m.bm = vals[0]
+			}
+		case "-clamp":
+			if i < len(fields) {
+				m.clamp = strings.EqualFold(fields[i], "on")
+				i++
+			}
+		case "-t":
+			_, i = floats(i, 3)
+		case "-mm":
+			_, i = floats(i, 2)
+		default:
+			// -blendu, -blendv, -boost, -texres, -imfchan, -type, -cc take one argument
+			if i < len(fields)-1 {
+				i++
+			}
+		}
+	}
+	if i < len(fields) {
+		m.path = strings.Join(fields[i:], " ")
+	}
+	return m
+}
+
+// textureImage loads the image of a texture statement, nil when it cannot be read.
+func (obj *ObjToMst) textureImage(m *objTextureMap) image.Image {
+	if m == nil {
+		return nil
+	}
+	if img, ok := obj.imgCache[m.path]; ok {
+		return img
+	}
+	var img image.Image
+	if p := obj.resolveTexturePath(m.path); p != "" {
+		img, _ = loadImage(p)
+	}
+	obj.imgCache[m.path] = img
+	return img
+}
+
+// cachedTexture reuses textures shared by several materials.
+func (obj *ObjToMst) cachedTexture(key string, repeated bool, load func() image.Image) *mst.Texture {
+	if tex, ok := obj.texCache[key]; ok {
+		if tex != nil {
+			tex.Repeated = tex.Repeated || repeated
+		}
+		return tex
+	}
+	var tex *mst.Texture
+	if img := load(); img != nil {
+		tex = imageToTex(img, obj.texId)
+		tex.Repeated = repeated
+		obj.texId++
+	}
+	obj.texCache[key] = tex
+	return tex
+}
+
+// baseTexture returns map_Kd with map_d merged into its alpha channel; with
+// only map_d the diffuse color is used as the base.
+func (obj *ObjToMst) baseTexture(maps objMaterialMaps, cl [3]byte) *mst.Texture {
+	base, alpha := maps["map_kd"], maps["map_d"]
+	switch {
+	case base == nil && alpha == nil:
+		return nil
+	case alpha == nil:
+		return obj.cachedTexture(base.path, !base.clamp, func() image.Image {
+			return obj.textureImage(base)
+		})
+	}
+	key := "alpha:" + alpha.path
+	repeated := !alpha.clamp
+	if base != nil {
+		key = base.path + "|" + key
+		repeated = !base.clamp
+	}
+	return obj.cachedTexture(key, repeated, func() image.Image {
+		img := obj.textureImage(base)
+		mask := obj.textureImage(alpha)
+		if mask == nil {
+			return img
+		}
+		return imageWithAlpha(img, cl, mask)
+	})
+}
+
+// normalTexture returns the `norm` normal map, or a normal map derived from
+// the `bump` height map scaled by its -bm option.
+func (obj *ObjToMst) normalTexture(maps objMaterialMaps) *mst.Texture {
+	if norm := maps["norm"]; norm != nil {
+		return obj.cachedTexture(norm.path, !norm.clamp, func() image.Image {
+			return obj.textureImage(norm)
+		})
+	}
+	bump := maps["bump"]
+	if bump == nil {
+		return nil
+	}
+	key := "bump:" + strconv.FormatFloat(float64(bump.bm), 'g', -1, 32) + ":" + bump.path
+	return obj.cachedTexture(key, !bump.clamp, func() image.Image {
+		img := obj.textureImage(bump)
+		if img == nil {
+			return nil
+		}
+		return heightToNormal(img, objBumpNormalScale*float64(bump.bm))
+	})
+}
+
+// textureMean returns the average color of a texture in [0, 1]; ok is false
+// when the texture is missing. MST materials cannot carry roughness, metallic
+// or emissive maps, so these are reduced to their mean value.
+func (obj *ObjToMst) textureMean(m *objTextureMap) (mean [3]float64, ok bool) {
+	img := obj.textureImage(m)
+	if img == nil {
+		return mean, false
+	}
+	return imageMean(img)
+}
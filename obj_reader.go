@@ -0,0 +1,306 @@
+package asset3d
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	gobj "github.com/flywave/go-obj"
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+)
+
+// objFaceInfo carries the statements go-obj discards for a face:
+// the active object, group and smoothing group (0 means smoothing off).
+type objFaceInfo struct {
+	object string
+	group  string
+	smooth int
+}
+
+// objFile is an OBJ file parsed into a gobj.ObjReader plus the data go-obj
+// cannot represent.
+type objFile struct {
+	*gobj.ObjReader
+	// faces holds the object, group and smoothing group of every face in F
+	faces []objFaceInfo
+	// colors holds the per-position colors of `v x y z r g b` lines, empty when the file has none
+	colors [][3]byte
+	// mtllibs holds the file names of every mtllib statement, one slice per statement
+	mtllibs [][]string
+}
+
+// objParser reads OBJ statements incrementally and hands them to callbacks,
+// tracking the element counts needed to resolve relative indices. Unsupported
+// statements such as free-form geometry are skipped instead of rejected.
+type objParser struct {
+	nv, nvt, nvn int
+
+	onVertex   func(p vec3.T, cl []float32) // cl is nil when the line has no color
+	onTexCoord func(vec2.T)
+	onNormal   func(vec3.T)
+	onFace     func(gobj.Face, objFaceInfo)
+	onLine     func(gobj.Line)
+	onMtllib   func(names []string)
+}
+
+func (p *objParser) parse(r io.Reader) error {
+	var cur objFaceInfo
+	material := ""
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	lno := 0
+	for scanner.Scan() {
+		lno++
+		line := scanner.Text()
+		if hashPos := strings.IndexByte(line, '#'); hashPos != -1 {
+			line = line[:hashPos]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var err error
+		switch strings.ToLower(fields[0]) {
+		case "v":
+			var v []float32
+			if v, err = parseObjFloats(fields[1:], 3); err == nil {
+				p.nv++
+				var cl []float32
+				if len(v) >= 6 {
+					// x y z r g b, or x y z w r g b
+					cl = v[len(v)-3:]
+				}
+				p.onVertex(vec3.T{v[0], v[1], v[2]}, cl)
+			}
+		case "vt":
+			var v []float32
+			if v, err = parseObjFloats(fields[1:], 2); err == nil {
+				p.nvt++
+				p.onTexCoord(vec2.T{v[0], v[1]})
+			}
+		case "vn":
+			var v []float32
+			if v, err = parseObjFloats(fields[1:], 3); err == nil {
+				p.nvn++
+				p.onNormal(vec3.T{v[0], v[1], v[2]})
+			}
+		case "f":
+			var face gobj.Face
+			if face, err = parseObjFace(fields[1:], p.nv, p.nvt, p.nvn); err == nil {
+				face.Material = material
+				p.onFace(face, cur)
+			}
+		case "l":
+			ln := gobj.Line{Material: material}
+			for _, field := range fields[1:] {
+				var idx int
+				if idx, err = parseObjIndex(strings.SplitN(field, "/", 2)[0], p.nv); err != nil {
+					break
+				}
+				ln.Corners = append(ln.Corners, idx)
+			}
+			if err == nil && len(ln.Corners) >= 2 && p.onLine != nil {
+				p.onLine(ln)
+			}
+		case "o":
+			cur.object = strings.Join(fields[1:], " ")
+		case "g":
+			cur.group = strings.Join(fields[1:], " ")
+		case "s":
+			cur.smooth = parseObjSmoothing(fields[1:])
+		case "usemtl":
+			material = strings.Join(fields[1:], " ")
+		case "mtllib":
+			if len(fields) > 1 {
+				p.onMtllib(fields[1:])
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("line #%d: %v ('%s')", lno, err, strings.TrimSpace(line))
+		}
+	}
+	return scanner.Err()
+}
+
+// readObj parses a whole OBJ stream into memory.
+func readObj(r io.Reader) (*objFile, error) {
+	reader := &gobj.ObjReader{}
+	file := &objFile{ObjReader: reader}
+	var colors [][3]float32
+	hasColor := false
+	maxColor := float32(0)
+
+	p := &objParser{
+		onVertex: func(v vec3.T, cl []float32) {
+			reader.V = append(reader.V, v)
+			var c [3]float32
+			if cl != nil {
+				copy(c[:], cl)
+				hasColor = true
+				for _, f := range c {
+					if f > maxColor {
+						maxColor = f
+					}
+				}
+			}
+			colors = append(colors, c)
+		},
+		onTexCoord: func(vt vec2.T) { reader.VT = append(reader.VT, vt) },
+		onNormal:   func(vn vec3.T) { reader.VN = append(reader.VN, vn) },
+		onFace: func(face gobj.Face, info objFaceInfo) {
+			reader.F = append(reader.F, face)
+			file.faces = append(file.faces, info)
+		},
+		onLine:   func(ln gobj.Line) { reader.L = append(reader.L, ln) },
+		onMtllib: func(names []string) { file.mtllibs = append(file.mtllibs, names) },
+	}
+	if err := p.parse(r); err != nil {
+		return nil, err
+	}
+
+	if hasColor {
+		file.colors = objColorBytes(colors, maxColor)
+	}
+	return file, nil
+}
+
+// position, texCoord, normal and color make objFile an objAttribSource.
+func (f *objFile) position(i int) (vec3.T, bool) {
+	if i < 0 || i >= len(f.V) {
+		return vec3.T{}, false
+	}
+	return f.V[i], true
+}
+
+func (f *objFile) texCoord(i int) (vec2.T, bool) {
+	if i < 0 || i >= len(f.VT) {
+		return vec2.T{}, false
+	}
+	return f.VT[i], true
+}
+
+func (f *objFile) normal(i int) (vec3.T, bool) {
+	if i < 0 || i >= len(f.VN) {
+		return vec3.T{}, false
+	}
+	return f.VN[i], true
+}
+
+func (f *objFile) color(i int) ([3]byte, bool) {
+	if i < 0 || i >= len(f.colors) {
+		return [3]byte{}, false
+	}
+	return f.colors[i], true
+}
+
+func (f *objFile) hasColor() bool {
+	return len(f.colors) > 0
+}
+
+// objColorBytes converts vertex colors to bytes. Colors are normally in [0, 1],
+// but some scanners write [0, 255]; the file's largest component decides the range.
+func objColorBytes(colors [][3]float32, maxColor float32) [][3]byte {
+	scale := float32(255)
+	if maxColor > 1 {
+		scale = 1
+	}
+	res := make([][3]byte, len(colors))
+	for i, cl := range colors {
+		res[i] = objColorByte(cl, scale)
+	}
+	return res
+}
+
+func objColorByte(cl [3]float32, scale float32) [3]byte {
+	var res [3]byte
+	for j, c := range cl {
+		c *= scale
+		if c < 0 {
+			c = 0
+		} else if c > 255 {
+			c = 255
+		}
+		res[j] = byte(c + 0.5)
+	}
+	return res
+}
+
+func parseObjFloats(fields []string, n int) ([]float32, error) {
+	if len(fields) < n {
+		return nil, fmt.Errorf("expected at least %d fields, but got %d", n, len(fields))
+	}
+	res := make([]float32, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(f, 32)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = float32(v)
+	}
+	return res, nil
+}
+
+// parseObjIndex converts a 1-based or negative (relative) OBJ index to a 0-based one.
+func parseObjIndex(field string, count int) (int, error) {
+	idx, err := strconv.Atoi(field)
+	switch {
+	case err != nil:
+		return -1, err
+	case idx > 0:
+		return idx - 1, nil
+	case idx < 0:
+		return count + idx, nil
+	}
+	return -1, fmt.Errorf("index 0 is invalid (OBJ uses 1-based indexing)")
+}
+
+func parseObjFace(fields []string, nv, nvt, nvn int) (gobj.Face, error) {
+	if len(fields) < 3 {
+		return gobj.Face{}, fmt.Errorf("expected %d fields, but got %d", 3, len(fields))
+	}
+	face := gobj.Face{Corners: make([]gobj.FaceCorner, len(fields))}
+	for i, field := range fields {
+		corner := gobj.FaceCorner{VertexIndex: -1, NormalIndex: -1, TexCoordIndex: -1}
+		parts := strings.Split(field, "/")
+		if len(parts) > 3 {
+			return gobj.Face{}, fmt.Errorf("face field '%s' is not on a supported format", field)
+		}
+		var err error
+		if corner.VertexIndex, err = parseObjIndex(parts[0], nv); err != nil {
+			return gobj.Face{}, err
+		}
+		if len(parts) > 1 && parts[1] != "" {
+			if corner.TexCoordIndex, err = parseObjIndex(parts[1], nvt); err != nil {
+				return gobj.Face{}, err
+			}
+		}
+		if len(parts) > 2 && parts[2] != "" {
+			if corner.NormalIndex, err = parseObjIndex(parts[2], nvn); err != nil {
+				return gobj.Face{}, err
+			}
+		}
+		face.Corners[i] = corner
+	}
+	return face, nil
+}
+
+// parseObjSmoothing returns 0 for "s off" / "s 0" and the group number otherwise.
+func parseObjSmoothing(fields []string) int {
+	if len(fields) == 0 || strings.EqualFold(fields[0], "off") {
+		return 0
+	}
+	s, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 1
+	}
+	if s < 0 {
+		return 0
+	}
+	return s
+}
@@ -0,0 +1,377 @@
+package asset3d
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	mst "github.com/flywave/go-mst"
+	gobj "github.com/flywave/go-obj"
+	vec3d "github.com/flywave/go3d/float64/vec3"
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+)
+
+// DefaultObjMemoryBudget is the memory budget of ConvertStream when ObjToMst.MemoryBudget is not set.
+const DefaultObjMemoryBudget int64 = 1 << 30
+
+const (
+	// objPageRecords is the number of attributes per page of an objPagedStore
+	objPageRecords = 1 << 16
+	// objVertexCost and objFaceCost estimate the bytes held per vertex and face of a chunk,
+	// including the vertex index map
+	objVertexCost = 112
+	objFaceCost   = 48
+)
+
+// ObjChunk is one mesh emitted by ConvertStream.
+type ObjChunk struct {
+	// Index numbers the chunks from 0 in emission order
+	Index int
+	Mesh  *mst.Mesh
+	BBox  *[6]float64
+}
+
+// ConvertStream converts an OBJ file without loading it into memory. Faces are
+// read incrementally and handed to emit as a series of meshes, each holding the
+// faces read since the previous one; a chunk is emitted when it reaches half of
+// MemoryBudget. Vertex attributes are kept in stores that spill to a temporary
+// file, sharing the other half of the budget.
+//
+// Every chunk carries its own materials. Normals computed for corners without
+// `vn` only see the faces of their chunk, so smooth shading can show seams
+// between chunks. NodeNames is not populated. The returned box covers all chunks.
+func (obj *ObjToMst) ConvertStream(path string, emit func(*ObjChunk) error) (*[6]float64, error) {
+	obj.currentPath = path
+	obj.NodeNames = nil
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	budget := obj.MemoryBudget
+	if budget <= 0 {
+		budget = DefaultObjMemoryBudget
+	}
+
+	src := newObjStreamSource(budget / 2)
+	defer src.close()
+
+	obj.resetTextures()
+	obj.loadMaterials(nil)
+	var mtllibs [][]string
+
+	ext := vec3d.MinBox
+	index := 0
+	serial := 0
+	bd := obj.newMeshBuilder(src)
+	flush := func() error {
+		if bd.faces == 0 {
+			return nil
+		}
+		mesh, bbox := bd.build(nil)
+		bd = obj.newMeshBuilder(src)
+		if len(mesh.Nodes) == 0 {
+			return nil
+		}
+		ext.Join(&vec3d.Box{
+			Min: vec3d.T{bbox[0], bbox[1], bbox[2]},
+			Max: vec3d.T{bbox[3], bbox[4], bbox[5]},
+		})
+		chunk := &ObjChunk{Index: index, Mesh: mesh, BBox: bbox}
+		index++
+		return emit(chunk)
+	}
+
+	var failed error
+	p := &objParser{
+		onVertex: func(v vec3.T, cl []float32) {
+			if failed == nil {
+				failed = src.addVertex(v, cl)
+			}
+		},
+		onTexCoord: func(vt vec2.T) {
+			if failed == nil {
+				failed = src.texCoords.add(vt[:])
+			}
+		},
+		onNormal: func(vn vec3.T) {
+			if failed == nil {
+				failed = src.normals.add(vn[:])
+			}
+		},
+		onFace: func(face gobj.Face, info objFaceInfo) {
+			if failed != nil {
+				return
+			}
+			bd.addFace(face, info, serial)
+			serial++
+			if int64(bd.vertices)*objVertexCost+int64(bd.faces)*objFaceCost >= budget/2 {
+				failed = flush()
+			}
+		},
+		onMtllib: func(names []string) {
+			// Material libraries are normally referenced before their first use
+			mtllibs = append(mtllibs, names)
+			obj.loadMaterials(obj.mtlPaths(mtllibs))
+		},
+	}
+	if err := p.parse(file); err != nil {
+		return nil, err
+	}
+	if failed != nil {
+		return nil, failed
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return ext.Array(), nil
+}
+
+// ConvertStreamFiles runs ConvertStream and writes the chunks to outDir as
+// <name>_<n>.mst, named after the OBJ file. It returns the written paths.
+func (obj *ObjToMst) ConvertStreamFiles(path, outDir string) ([]string, *[6]float64, error) {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	var paths []string
+	ext, err := obj.ConvertStream(path, func(chunk *ObjChunk) error {
+		p := filepath.Join(outDir, fmt.Sprintf("%s_%d.mst", base, chunk.Index))
+		if err := mst.MeshWriteTo(p, chunk.Mesh); err != nil {
+			return err
+		}
+		paths = append(paths, p)
+		return nil
+	})
+	return paths, ext, err
+}
+
+// objStreamSource holds the vertex attributes of a streamed OBJ file in paged stores.
+type objStreamSource struct {
+	positions *objPagedStore
+	texCoords *objPagedStore
+	normals   *objPagedStore
+	// colors holds the raw colors of all positions once a colored vertex has been seen
+	colors   *objPagedStore
+	maxColor float32
+}
+
+func newObjStreamSource(budget int64) *objStreamSource {
+	// Positions and normals are read most often; texture coordinates and colors get a smaller share
+	share := budget / 8
+	return &objStreamSource{
+		positions: newObjPagedStore(3, share*3),
+		texCoords: newObjPagedStore(2, share),
+		normals:   newObjPagedStore(3, share*3),
+		colors:    newObjPagedStore(3, share),
+	}
+}
+
+func (s *objStreamSource) addVertex(v vec3.T, cl []float32) error {
+	if cl != nil {
+		// Pad the positions read before the first colored vertex
+		for s.colors.count < s.positions.count {
+			if err := s.colors.add([]float32{0, 0, 0}); err != nil {
+				return err
+			}
+		}
+		for _, c := range cl {
+			if c > s.maxColor {
+				s.maxColor = c
+			}
+		}
+	}
+	if s.colors.count > 0 {
+		var c [3]float32
+		copy(c[:], cl)
+		if err := s.colors.add(c[:]); err != nil {
+			return err
+		}
+	}
+	return s.positions.add(v[:])
+}
+
+func (s *objStreamSource) position(i int) (vec3.T, bool) {
+	var v vec3.T
+	return v, s.positions.get(i, v[:])
+}
+
+func (s *objStreamSource) texCoord(i int) (vec2.T, bool) {
+	var v vec2.T
+	return v, s.texCoords.get(i, v[:])
+}
+
+func (s *objStreamSource) normal(i int) (vec3.T, bool) {
+	var v vec3.T
+	return v, s.normals.get(i, v[:])
+}
+
+// color converts with the range of the colors read so far, see objColorBytes.
+func (s *objStreamSource) color(i int) ([3]byte, bool) {
+	var c [3]float32
+	if !s.colors.get(i, c[:]) {
+		return [3]byte{}, false
+	}
+	scale := float32(255)
+	if s.maxColor > 1 {
+		scale = 1
+	}
+	return objColorByte(c, scale), true
+}
+
+func (s *objStreamSource) hasColor() bool {
+	return s.colors.count > 0
+}
+
+func (s *objStreamSource) close() {
+	for _, st := range []*objPagedStore{s.positions, s.texCoords, s.normals, s.colors} {
+		st.close()
+	}
+}
+
+// objPagedStore is an append-only array of fixed-width float32 records. Full
+// pages beyond the memory limit are written to a temporary file and read back
+// on demand, least recently used pages being evicted first.
+type objPagedStore struct {
+	width    int
+	count    int
+	maxPages int
+	pages    map[int]*objPage
+	lru      *list.List
+	file     *os.File
+	// onDisk marks the pages written to file; pages are only spilled once full
+	onDisk map[int]bool
+}
+
+type objPage struct {
+	index int
+	data  []float32
+	elem  *list.Element
+}
+
+func newObjPagedStore(width int, limit int64) *objPagedStore {
+	pageBytes := int64(objPageRecords * width * 4)
+	maxPages := int(limit / pageBytes)
+	if maxPages < 2 {
+		maxPages = 2
+	}
+	return &objPagedStore{
+		width:    width,
+		maxPages: maxPages,
+		pages:    make(map[int]*objPage),
+		lru:      list.New(),
+		onDisk:   make(map[int]bool),
+	}
+}
+
+func (s *objPagedStore) add(v []float32) error {
+	idx := s.count / objPageRecords
+	pg, err := s.page(idx)
+	if err != nil {
+		return err
+	}
+	pg.data = append(pg.data, v[:s.width]...)
+	s.count++
+	return nil
+}
+
+// get copies record i into dst, false when i is out of range or cannot be read.
+func (s *objPagedStore) get(i int, dst []float32) bool {
+	if i < 0 || i >= s.count {
+		return false
+	}
+	pg, err := s.page(i / objPageRecords)
+	if err != nil {
+		return false
+	}
+	off := (i % objPageRecords) * s.width
+	copy(dst, pg.data[off:off+s.width])
+	return true
+}
+
+// page returns page idx, loading it from the spill file or starting it when new.
+func (s *objPagedStore) page(idx int) (*objPage, error) {
+	if pg, ok := s.pages[idx]; ok {
+		s.lru.MoveToFront(pg.elem)
+		return pg, nil
+	}
+	for len(s.pages) >= s.maxPages {
+		if err := s.evict(); err != nil {
+			return nil, err
+		}
+	}
+
+	pg := &objPage{index: idx}
+	if s.onDisk[idx] {
+		buf := make([]byte, objPageRecords*s.width*4)
+		if _, err := s.file.ReadAt(buf, s.offset(idx)); err != nil {
+			return nil, err
+		}
+		pg.data = make([]float32, objPageRecords*s.width)
+		for j := range pg.data {
+			pg.data[j] = math.Float32frombits(binary.LittleEndian.Uint32(buf[j*4:]))
+		}
+	} else {
+		pg.data = make([]float32, 0, objPageRecords*s.width)
+	}
+	pg.elem = s.lru.PushFront(pg)
+	s.pages[idx] = pg
+	return pg, nil
+}
+
+// evict drops the least recently used page, writing it out first if it is
+// full and not yet in the spill file. The page being appended to stays in memory.
+func (s *objPagedStore) evict() error {
+	last := s.count / objPageRecords
+	for e := s.lru.Back(); e != nil; e = e.Prev() {
+		pg := e.Value.(*objPage)
+		if pg.index == last {
+			continue
+		}
+		if !s.onDisk[pg.index] {
+			if err := s.spill(pg); err != nil {
+				return err
+			}
+		}
+		s.lru.Remove(e)
+		delete(s.pages, pg.index)
+		return nil
+	}
+	return nil
+}
+
+func (s *objPagedStore) spill(pg *objPage) error {
+	if s.file == nil {
+		f, err := os.CreateTemp("", "obj-stream-*.bin")
+		if err != nil {
+			return err
+		}
+		s.file = f
+	}
+	buf := make([]byte, len(pg.data)*4)
+	for j, f := range pg.data {
+		binary.LittleEndian.PutUint32(buf[j*4:], math.Float32bits(f))
+	}
+	if _, err := s.file.WriteAt(buf, s.offset(pg.index)); err != nil {
+		return err
+	}
+	s.onDisk[pg.index] = true
+	return nil
+}
+
+func (s *objPagedStore) offset(idx int) int64 {
+	return int64(idx) * int64(objPageRecords*s.width*4)
+}
+
+func (s *objPagedStore) close() {
+	if s.file != nil {
+		name := s.file.Name()
+		s.file.Close()
+		os.Remove(name)
+		s.file = nil
+	}
+}
@@ -0,0 +1,533 @@
+package asset3d
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	mst "github.com/flywave/go-mst"
+	"github.com/flywave/go3d/vec3"
+)
+
+// writeObjTest writes the given files into a temporary directory and returns
+// the path of the first one.
+func writeObjTest(t *testing.T, files ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	var first string
+	for i := 0; i+1 < len(files); i += 2 {
+		path := filepath.Join(dir, files[i])
+		if err := os.WriteFile(path, []byte(files[i+1]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if first == "" {
+			first = path
+		}
+	}
+	return first
+}
+
+func objFaces(nd *mst.MeshNode) []*mst.Face {
+	var faces []*mst.Face
+	for _, g := range nd.FaceGroup {
+		faces = append(faces, g.Faces...)
+	}
+	return faces
+}
+
+// --- smoothing groups ---
+
+// objFoldSrc is two quads folded along the edge x=1, without normals.
+const objFoldSrc = `v 0 0 0
+v 1 0 0
+v 1 1 0
+v 0 1 0
+v 1 0 -1
+v 1 1 -1
+`
+
+func TestObjToMst_SmoothingOff(t *testing.T) {
+	for _, src := range []string{
+		objFoldSrc + "f 1 2 3 4\nf 2 5 6 3\n",
+		objFoldSrc + "s off\nf 1 2 3 4\nf 2 5 6 3\n",
+	} {
+		mesh, _, err := (&ObjToMst{}).Convert(writeObjTest(t, "fold.obj", src))
+		if err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+		nd := mesh.Nodes[0]
+		// Each face keeps its own vertices so the normals stay per vertex and flat
+		if len(nd.Vertices) != 8 || len(nd.Normals) != len(nd.Vertices) {
+			t.Fatalf("got %d vertices and %d normals, want 8 each", len(nd.Vertices), len(nd.Normals))
+		}
+		want := []vec3.T{{0, 0, 1}, {0, 0, 1}, {1, 0, 0}, {1, 0, 0}}
+		for i, f := range objFaces(nd) {
+			if f.Normal != nil {
+				t.Errorf("face %d has face normals", i)
+			}
+			for _, v := range f.Vertex {
+				if n := nd.Normals[v]; math.Abs(float64(n[0]-want[i][0])) > 1e-6 || math.Abs(float64(n[2]-want[i][2])) > 1e-6 {
+					t.Errorf("face %d normal = %v, want %v", i, n, want[i])
+				}
+			}
+		}
+	}
+}
+
+func TestObjToMst_SmoothingGroups(t *testing.T) {
+	tests := []struct {
+		src      string
+		vertices int
+	}{
+		// One group shares the fold
+		{objFoldSrc + "s 1\nf 1 2 3 4\nf 2 5 6 3\n", 6},
+		// Different groups split the fold
+		{objFoldSrc + "s 1\nf 1 2 3 4\ns 2\nf 2 5 6 3\n", 8},
+		// Corners with vn are shared regardless of the smoothing group
+		{objFoldSrc + "vn 0 0 1\ns 1\nf 1//1 2//1 3//1 4//1\ns 2\nf 2//1 5//1 6//1 3//1\n", 6},
+	}
+	for _, tt := range tests {
+		mesh, _, err := (&ObjToMst{}).Convert(writeObjTest(t, "fold.obj", tt.src))
+		if err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+		nd := mesh.Nodes[0]
+		if len(nd.Vertices) != tt.vertices {
+			t.Errorf("got %d vertices, want %d:\n%s", len(nd.Vertices), tt.vertices, tt.src)
+		}
+		if len(nd.Normals) != len(nd.Vertices) {
+			t.Errorf("got %d normals for %d vertices", len(nd.Normals), len(nd.Vertices))
+		}
+		for _, f := range objFaces(nd) {
+			if f.Normal != nil {
+				t.Errorf("smoothed face has face normals")
+			}
+		}
+	}
+}
+
+// --- materials ---
+
+func TestObjToMst_Dissolve(t *testing.T) {
+	path := writeObjTest(t,
+		"glass.obj", "mtllib glass.mtl\nv 0 0 0\nv 1 0 0\nv 0 1 0\nusemtl glass\nf 1 2 3\nusemtl plain\nf 1 3 2\n",
+		"glass.mtl", "newmtl glass\nKd 0.5 0.5 0.5\nd 0.25\nnewmtl plain\nKd 1 0 0\n")
+	mesh, _, err := (&ObjToMst{}).Convert(path)
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	// d is an opacity, MST stores transparency
+	for i, want := range []float32{0.75, 0} {
+		if got := mesh.Materials[i].(*mst.LambertMaterial).Transparency; got != want {
+			t.Errorf("material %d transparency = %v, want %v", i, got, want)
+		}
+	}
+
+	// The exporter writes d back unchanged
+	out := filepath.Join(t.TempDir(), "out.obj")
+	if err := (&MstToObj{}).Export(mesh, out); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	again, _, err := (&ObjToMst{}).Convert(out)
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	for i, want := range []float32{0.75, 0} {
+		if got := again.Materials[i].(*mst.LambertMaterial).Transparency; math.Abs(float64(got-want)) > 1e-6 {
+			t.Errorf("round trip material %d transparency = %v, want %v", i, got, want)
+		}
+	}
+
+	// Without a material library the default material is opaque
+	mesh, _, _ = (&ObjToMst{}).Convert(writeObjTest(t, "bare.obj", "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\n"))
+	if got := mesh.Materials[0].(*mst.BaseMaterial).Transparency; got != 0 {
+		t.Errorf("default material transparency = %v, want 0", got)
+	}
+}
+
+// --- objPagedStore ---
+
+func TestObjPagedStore_Spill(t *testing.T) {
+	s := newObjPagedStore(3, 0)
+	defer s.close()
+	n := objPageRecords*4 + 10
+	for i := 0; i < n; i++ {
+		if err := s.add([]float32{float32(i), float32(-i), 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(s.pages) > s.maxPages {
+		t.Errorf("expected at most %d pages in memory, got %d", s.maxPages, len(s.pages))
+	}
+	for _, i := range []int{0, n - 1, objPageRecords + 5, 7, objPageRecords * 3} {
+		var v [3]float32
+		if !s.get(i, v[:]) || v != [3]float32{float32(i), float32(-i), 1} {
+			t.Errorf("record %d: got %v", i, v)
+		}
+	}
+	var v [3]float32
+	if s.get(n, v[:]) || s.get(-1, v[:]) {
+		t.Error("expected out of range records to be missing")
+	}
+}
+
+// --- ConvertStream ---
+
+// objStreamSrc builds numQuads disjoint unit quads along x, alternating between
+// the materials red and blue. blue comes from a second mtllib statement that
+// appears after the first faces.
+func objStreamSrc(numQuads int) string {
+	var b strings.Builder
+	b.WriteString("mtllib red.mtl\n")
+	for i := 0; i < numQuads; i++ {
+		x := float64(2 * i)
+		fmt.Fprintf(&b, "v %g 0 0\nv %g 0 0\nv %g 1 0\nv %g 1 0\n", x, x+1, x+1, x)
+	}
+	for i := 0; i < numQuads; i++ {
+		if i == 1 {
+			b.WriteString("mtllib blue.mtl\n")
+		}
+		if i%2 == 0 {
+			b.WriteString("usemtl red\n")
+		} else {
+			b.WriteString("usemtl blue\n")
+		}
+		fmt.Fprintf(&b, "f %d %d %d %d\n", 4*i+1, 4*i+2, 4*i+3, 4*i+4)
+	}
+	return b.String()
+}
+
+func TestObjToMst_ConvertStream(t *testing.T) {
+	path := writeObjTest(t,
+		"quads.obj", objStreamSrc(40),
+		"red.mtl", "newmtl red\nKd 1 0 0\n",
+		"blue.mtl", "newmtl blue\nKd 0 0 1\n")
+
+	// Each quad adds 4 vertices and 2 faces to a chunk; a chunk is flushed
+	// once it reaches half of the budget, that is after 5 quads
+	quadCost := int64(4*objVertexCost + 2*objFaceCost)
+	cv := &ObjToMst{MemoryBudget: 2 * 5 * quadCost}
+	var chunks []*ObjChunk
+	bbox, err := cv.ConvertStream(path, func(c *ObjChunk) error {
+		chunks = append(chunks, c)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ConvertStream failed: %v", err)
+	}
+	if len(chunks) != 8 {
+		t.Fatalf("got %d chunks, want 8", len(chunks))
+	}
+	for i, c := range chunks {
+		if c.Index != i {
+			t.Errorf("chunk %d has index %d", i, c.Index)
+		}
+		faces, vertices := 0, 0
+		for _, nd := range c.Mesh.Nodes {
+			faces += len(objFaces(nd))
+			vertices += len(nd.Vertices)
+		}
+		if faces != 10 || vertices != 20 {
+			t.Errorf("chunk %d: got %d faces and %d vertices, want 10 and 20", i, faces, vertices)
+		}
+		// Every chunk carries its own materials in first-use order
+		if len(c.Mesh.Materials) != 2 {
+			t.Fatalf("chunk %d: got %d materials, want 2", i, len(c.Mesh.Materials))
+		}
+		first, second := [3]byte{255, 0, 0}, [3]byte{0, 0, 255}
+		if i%2 == 1 {
+			first, second = second, first
+		}
+		if c.Mesh.Materials[0].GetColor() != first || c.Mesh.Materials[1].GetColor() != second {
+			t.Errorf("chunk %d: materials %v %v", i, c.Mesh.Materials[0].GetColor(), c.Mesh.Materials[1].GetColor())
+		}
+		if c.BBox[0] != float64(10*i) || c.BBox[3] != float64(10*i+9) {
+			t.Errorf("chunk %d: bbox %v", i, c.BBox)
+		}
+	}
+	if *bbox != [6]float64{0, 0, 0, 79, 1, 0} {
+		t.Errorf("joined bbox = %v", bbox)
+	}
+
+	// ConvertStreamFiles writes the same chunks as MST files
+	outDir := t.TempDir()
+	paths, bbox, err := cv.ConvertStreamFiles(path, outDir)
+	if err != nil {
+		t.Fatalf("ConvertStreamFiles failed: %v", err)
+	}
+	if len(paths) != 8 || filepath.Base(paths[7]) != "quads_7.mst" || bbox[3] != 79 {
+		t.Fatalf("got paths %v, bbox %v", paths, bbox)
+	}
+	mesh, err := mst.MeshReadFrom(paths[3])
+	if err != nil {
+		t.Fatalf("MeshReadFrom failed: %v", err)
+	}
+	if len(mesh.Nodes) != 1 || len(mesh.Nodes[0].Vertices) != 20 || mesh.Nodes[0].Vertices[0][0] != 30 {
+		t.Errorf("chunk 3 read back wrong: %d nodes", len(mesh.Nodes))
+	}
+}
+
+// --- vertex indexing ---
+
+func TestObjToMst_VertexIndex(t *testing.T) {
+	src := `v 0 0 0
+v 1 0 0
+v 1 1 0
+v 0 1 0
+vt 0 0
+vt 1 0
+vt 1 1
+vt 0 1
+vt 0.5 0.5
+vn 0 0 1
+f 1/1/1 2/2/1 3/3/1
+f 1/1/1 3/3/1 4/4/1
+f 1/5/1 3/3/1 4/4/1
+`
+	mesh, _, err := (&ObjToMst{}).Convert(writeObjTest(t, "quad.obj", src))
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	nd := mesh.Nodes[0]
+	// Corners with the same (v, vt, vn) share a vertex; 1/5/1 differs in vt only
+	if len(nd.Vertices) != 5 {
+		t.Fatalf("got %d vertices, want 5", len(nd.Vertices))
+	}
+	faces := objFaces(nd)
+	if len(faces) != 3 {
+		t.Fatalf("got %d faces, want 3", len(faces))
+	}
+	if faces[0].Vertex[0] != faces[1].Vertex[0] || faces[0].Vertex[2] != faces[1].Vertex[1] {
+		t.Errorf("shared corners were not merged: %v %v", faces[0].Vertex, faces[1].Vertex)
+	}
+	if faces[2].Vertex[0] == faces[1].Vertex[0] {
+		t.Error("corners with different texture coordinates were merged")
+	}
+	if got := nd.TexCoords[faces[2].Vertex[0]]; got[0] != 0.5 || got[1] != 0.5 {
+		t.Errorf("texcoord = %v, want {0.5 0.5}", got)
+	}
+}
+
+// --- objParser ---
+
+func TestParseObjFace(t *testing.T) {
+	// 4 positions, 3 texture coordinates and 2 normals read so far
+	tests := []struct {
+		fields []string
+		want   [][3]int // v, vt, vn per corner, -1 when absent
+		err    bool
+	}{
+		{[]string{"1", "2", "3"}, [][3]int{{0, -1, -1}, {1, -1, -1}, {2, -1, -1}}, false},
+		{[]string{"1/1", "2/2", "3/3"}, [][3]int{{0, 0, -1}, {1, 1, -1}, {2, 2, -1}}, false},
+		{[]string{"1//2", "2//2", "3//1"}, [][3]int{{0, -1, 1}, {1, -1, 1}, {2, -1, 0}}, false},
+		{[]string{"4/3/2", "3/2/1", "2/1/1"}, [][3]int{{3, 2, 1}, {2, 1, 0}, {1, 0, 0}}, false},
+		// Negative indices count back from the last element read
+		{[]string{"-1", "-2", "-4"}, [][3]int{{3, -1, -1}, {2, -1, -1}, {0, -1, -1}}, false},
+		{[]string{"-3/-1/-1", "-2/-2/-2", "-1/-3/-1"}, [][3]int{{1, 2, 1}, {2, 1, 0}, {3, 0, 1}}, false},
+		{[]string{"1", "2"}, nil, true},
+		{[]string{"0", "1", "2"}, nil, true},
+		{[]string{"1/1/1/1", "2", "3"}, nil, true},
+		{[]string{"a", "2", "3"}, nil, true},
+	}
+	for _, tt := range tests {
+		face, err := parseObjFace(tt.fields, 4, 3, 2)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseObjFace(%v): expected an error", tt.fields)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseObjFace(%v) failed: %v", tt.fields, err)
+			continue
+		}
+		for i, c := range face.Corners {
+			got := [3]int{c.VertexIndex, c.TexCoordIndex, c.NormalIndex}
+			if got != tt.want[i] {
+				t.Errorf("parseObjFace(%v) corner %d = %v, want %v", tt.fields, i, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestParseObjSmoothing(t *testing.T) {
+	tests := []struct {
+		fields []string
+		want   int
+	}{
+		{nil, 0},
+		{[]string{"off"}, 0},
+		{[]string{"OFF"}, 0},
+		{[]string{"0"}, 0},
+		{[]string{"-3"}, 0},
+		{[]string{"1"}, 1},
+		{[]string{"12"}, 12},
+		{[]string{"on"}, 1},
+	}
+	for _, tt := range tests {
+		if got := parseObjSmoothing(tt.fields); got != tt.want {
+			t.Errorf("parseObjSmoothing(%v) = %d, want %d", tt.fields, got, tt.want)
+		}
+	}
+}
+
+func TestReadObj_FaceInfo(t *testing.T) {
+	src := `v 0 0 0
+v 1 0 0
+v 0 1 0
+f 1 2 3
+o body
+g left
+s 2
+usemtl red
+f -3 -2 -1
+g right
+s off
+f 1 2 3
+`
+	file, err := readObj(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("readObj failed: %v", err)
+	}
+	want := []objFaceInfo{
+		{},
+		{object: "body", group: "left", smooth: 2},
+		{object: "body", group: "right"},
+	}
+	if len(file.faces) != len(want) {
+		t.Fatalf("got %d faces, want %d", len(file.faces), len(want))
+	}
+	for i, info := range file.faces {
+		if info != want[i] {
+			t.Errorf("face %d info = %+v, want %+v", i, info, want[i])
+		}
+	}
+	if file.F[1].Material != "red" || file.F[2].Material != "red" || file.F[0].Material != "" {
+		t.Errorf("materials = %q %q %q", file.F[0].Material, file.F[1].Material, file.F[2].Material)
+	}
+	if file.F[1].Corners[0].VertexIndex != 0 {
+		t.Errorf("relative index resolved to %d, want 0", file.F[1].Corners[0].VertexIndex)
+	}
+
+	if _, err := readObj(strings.NewReader("v 0 0\n")); err == nil {
+		t.Error("expected an error for a vertex with two coordinates")
+	}
+}
+
+// --- vertex colors ---
+
+func TestReadObj_VertexColors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want [][3]byte
+	}{
+		{"v 0 0 0\nv 1 0 0\nv 0 1 0\n", nil},
+		{"v 0 0 0 1 0 0\nv 1 0 0 0 1 0\nv 0 1 0\n", [][3]byte{{255, 0, 0}, {0, 255, 0}, {0, 0, 0}}},
+		// x y z w r g b
+		{"v 0 0 0 1 0 0 1\nv 1 0 0 1 0.5 0.5 0.5\nv 0 1 0 1 0 0 0\n", [][3]byte{{0, 0, 255}, {128, 128, 128}, {0, 0, 0}}},
+		// Colors above 1 are taken as bytes
+		{"v 0 0 0 255 0 0\nv 1 0 0 0 128 0\nv 0 1 0 0 0 1\n", [][3]byte{{255, 0, 0}, {0, 128, 0}, {0, 0, 1}}},
+	}
+	for i, tt := range tests {
+		file, err := readObj(strings.NewReader(tt.src))
+		if err != nil {
+			t.Fatalf("case %d: readObj failed: %v", i, err)
+		}
+		if file.hasColor() != (tt.want != nil) {
+			t.Errorf("case %d: hasColor = %v", i, file.hasColor())
+			continue
+		}
+		for j, want := range tt.want {
+			if got, _ := file.color(j); got != want {
+				t.Errorf("case %d: color %d = %v, want %v", i, j, got, want)
+			}
+		}
+	}
+
+	src := "v 0 0 0 1 0 0\nv 1 0 0 0 1 0\nv 0 1 0 0 0 1\nf 1 2 3\n"
+	mesh, _, err := (&ObjToMst{}).Convert(writeObjTest(t, "colors.obj", src))
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	nd := mesh.Nodes[0]
+	if len(nd.Colors) != len(nd.Vertices) {
+		t.Fatalf("got %d colors for %d vertices", len(nd.Colors), len(nd.Vertices))
+	}
+	f := objFaces(nd)[0]
+	if nd.Colors[f.Vertex[0]] != [3]byte{255, 0, 0} || nd.Colors[f.Vertex[2]] != [3]byte{0, 0, 255} {
+		t.Errorf("colors = %v", nd.Colors)
+	}
+}
+
+// --- materials ---
+
+func TestObjToMst_MaterialOrder(t *testing.T) {
+	src := `mtllib a.mtl b.mtl
+v 0 0 0
+v 1 0 0
+v 0 1 0
+g first
+usemtl blue
+f 1 2 3
+g second
+usemtl red
+f 1 2 3
+mtllib my lib.mtl
+usemtl green
+f 1 2 3
+g first
+usemtl blue
+f 1 2 3
+`
+	path := writeObjTest(t, "order.obj", src,
+		"a.mtl", "newmtl red\nKd 1 0 0\n",
+		// red is defined twice, the first library wins
+		"b.mtl", "newmtl blue\nKd 0 0 1\nnewmtl red\nKd 0 1 1\n",
+		"my lib.mtl", "newmtl green\nKd 0 1 0\n")
+
+	for run := 0; run < 5; run++ {
+		cv := &ObjToMst{SplitMode: ObjSplitMaterial}
+		mesh, _, err := cv.Convert(path)
+		if err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+		// Materials and nodes follow first use, not map order
+		colors := [][3]byte{{0, 0, 255}, {255, 0, 0}, {0, 255, 0}}
+		if len(mesh.Materials) != len(colors) {
+			t.Fatalf("got %d materials, want %d", len(mesh.Materials), len(colors))
+		}
+		for i, want := range colors {
+			if got := mesh.Materials[i].GetColor(); got != want {
+				t.Errorf("run %d: material %d color = %v, want %v", run, i, got, want)
+			}
+		}
+		names := []string{"blue", "red", "green"}
+		if len(mesh.Nodes) != len(names) {
+			t.Fatalf("got %d nodes, want %d", len(mesh.Nodes), len(names))
+		}
+		for i, nd := range mesh.Nodes {
+			if cv.NodeNames[nd] != names[i] {
+				t.Errorf("run %d: node %d = %q, want %q", run, i, cv.NodeNames[nd], names[i])
+			}
+			if nd.FaceGroup[0].Batchid != int32(i) {
+				t.Errorf("run %d: node %d batch = %d", run, i, nd.FaceGroup[0].Batchid)
+			}
+		}
+	}
+
+	cv := &ObjToMst{SplitMode: ObjSplitGroup}
+	mesh, _, err := cv.Convert(path)
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if len(mesh.Nodes) != 2 || cv.NodeNames[mesh.Nodes[0]] != "first" || cv.NodeNames[mesh.Nodes[1]] != "second" {
+		t.Fatalf("got %d group nodes", len(mesh.Nodes))
+	}
+	// Material groups within a node keep first use as well
+	if g := mesh.Nodes[1].FaceGroup; len(g) != 2 || g[0].Batchid != 1 || g[1].Batchid != 2 {
+		t.Errorf("second node groups are out of order")
+	}
+}
@@ -0,0 +1,393 @@
+package asset3d
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	mst "github.com/flywave/go-mst"
+	vec3d "github.com/flywave/go3d/float64/vec3"
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+)
+
+// PlyToMst 实现从 PLY 到 MST 格式的转换，支持 ASCII 和二进制(小端、大端)格式。
+// 属性按名称识别，与声明顺序和数据类型无关: 顶点的 x/y/z、nx/ny/nz、red/green/blue、
+// s/t(或 u/v、texture_u/texture_v)，面的 vertex_indices、逐角点的 texcoord 和 texnumber，
+// 以及 tristrips 元素。多边形面三角化后输出，其余元素忽略。
+// 没有面的点云只输出顶点，没有面组
+type PlyToMst struct {
+	// Diagnostics 转换过程中的诊断信息，例如找不到的纹理和越界的顶点索引
+	Diagnostics Diagnostics
+
+	baseDir string
+	texId   int
+}
+
+// plyData 读取的顶点和面，面在文件读完后再组装，因此不依赖元素的先后顺序
+type plyData struct {
+	positions []vec3.T
+	normals   []vec3.T
+	texCoords []vec2.T
+	colors    [][3]byte
+
+	// polygons 面的顶点索引，polyStarts 为每个面在 polygons 中的起始位置
+	polygons   []int
+	polyStarts []int
+	// polyUvs 逐角点的纹理坐标，与 polygons 对齐，文件中没有时为空
+	polyUvs []vec2.T
+	// polyTex 每个面的纹理序号，文件中没有时为空
+	polyTex []int32
+}
+
+func (cv *PlyToMst) Convert(path string) (*mst.Mesh, *[6]float64, error) {
+	cv.Diagnostics = nil
+	cv.baseDir = filepath.Dir(path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	header, err := readPlyHeader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	data := &plyData{}
+	readers := make(map[*plyElement]func(rec *plyRecord))
+	for _, el := range header.elements {
+		switch el.name {
+		case "vertex":
+			fn, err := data.vertexReader(el)
+			if err != nil {
+				return nil, nil, err
+			}
+			readers[el] = fn
+		case "face":
+			readers[el] = data.faceReader(el)
+		case "tristrips":
+			readers[el] = data.stripReader(el)
+		}
+	}
+	err = header.readElements(r, func(el *plyElement, rec *plyRecord) error {
+		if fn := readers[el]; fn != nil {
+			fn(rec)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mesh, bbox := cv.buildMesh(data, header.textureFiles)
+	return mesh, bbox, nil
+}
+
+// vertexReader 按属性名查找顶点属性，没有坐标时返回错误
+func (d *plyData) vertexReader(el *plyElement) (func(rec *plyRecord), error) {
+	pos := [3]int{el.property("x"), el.property("y"), el.property("z")}
+	if pos[0] < 0 || pos[1] < 0 || pos[2] < 0 {
+		return nil, fmt.Errorf("ply: vertex element has no x, y, z")
+	}
+	normal := [3]int{el.property("nx", "normal_x"), el.property("ny", "normal_y"), el.property("nz", "normal_z")}
+	hasNormal := normal[0] >= 0 && normal[1] >= 0 && normal[2] >= 0
+	color := [3]int{
+		el.property("red", "diffuse_red", "r"),
+		el.property("green", "diffuse_green", "g"),
+		el.property("blue", "diffuse_blue", "b"),
+	}
+	hasColor := color[0] >= 0 && color[1] >= 0 && color[2] >= 0
+	uv := [2]int{
+		el.property("s", "u", "texture_u", "texture_s"),
+		el.property("t", "v", "texture_v", "texture_t"),
+	}
+	hasUv := uv[0] >= 0 && uv[1] >= 0
+
+	// 整数颜色按类型的最大值换算，浮点颜色的范围为 [0, 1]
+	var colorScale [3]float64
+	if hasColor {
+		for k, pi := range color {
+			colorScale[k] = 255 / el.props[pi].typ.maxValue()
+		}
+	}
+
+	return func(rec *plyRecord) {
+		s := rec.scalars
+		d.positions = append(d.positions, vec3.T{float32(s[pos[0]]), float32(s[pos[1]]), float32(s[pos[2]])})
+		if hasNormal {
+			d.normals = append(d.normals, vec3.T{float32(s[normal[0]]), float32(s[normal[1]]), float32(s[normal[2]])})
+		}
+		if hasUv {
+			d.texCoords = append(d.texCoords, vec2.T{float32(s[uv[0]]), float32(s[uv[1]])})
+		}
+		if hasColor {
+			var cl [3]byte
+			for k, pi := range color {
+				cl[k] = plyColorByte(s[pi] * colorScale[k])
+			}
+			d.colors = append(d.colors, cl)
+		}
+	}, nil
+}
+
+// faceReader 读取面的顶点索引、逐角点纹理坐标(texcoord，每个角点两个值)和纹理序号
+func (d *plyData) faceReader(el *plyElement) func(rec *plyRecord) {
+	indices := el.property("vertex_indices", "vertex_index")
+	texcoord := el.property("texcoord")
+	texnumber := el.property("texnumber")
+	if indices < 0 || !el.props[indices].list {
+		return nil
+	}
+	if texcoord >= 0 && !el.props[texcoord].list {
+		texcoord = -1
+	}
+	if texnumber >= 0 && el.props[texnumber].list {
+		texnumber = -1
+	}
+	return func(rec *plyRecord) {
+		list := rec.lists[indices]
+		if texcoord >= 0 {
+			// 补齐之前没有纹理坐标的面
+			for len(d.polyUvs) < len(d.polygons) {
+				d.polyUvs = append(d.polyUvs, vec2.T{})
+			}
+			uvs := rec.lists[texcoord]
+			for k := range list {
+				var vt vec2.T
+				if 2*k+1 < len(uvs) {
+					vt = vec2.T{float32(uvs[2*k]), float32(uvs[2*k+1])}
+				}
+				d.polyUvs = append(d.polyUvs, vt)
+			}
+		}
+		if texnumber >= 0 {
+			for len(d.polyTex) < len(d.polyStarts) {
+				d.polyTex = append(d.polyTex, 0)
+			}
+			d.polyTex = append(d.polyTex, int32(rec.scalars[texnumber]))
+		}
+		d.addPolygon(list)
+	}
+}
+
+// stripReader 将三角形带转换为三角形，索引 -1 表示开始新的带
+func (d *plyData) stripReader(el *plyElement) func(rec *plyRecord) {
+	indices := el.property("vertex_indices", "vertex_index")
+	if indices < 0 || !el.props[indices].list {
+		return nil
+	}
+	return func(rec *plyRecord) {
+		var strip []float64
+		flush := func() {
+			for k := 0; k+2 < len(strip); k++ {
+				if k%2 == 0 {
+					d.addPolygon(strip[k : k+3])
+				} else {
+					d.addPolygon([]float64{strip[k+1], strip[k], strip[k+2]})
+				}
+			}
+			strip = strip[:0]
+		}
+		for _, idx := range rec.lists[indices] {
+			if idx < 0 {
+				flush()
+				continue
+			}
+			strip = append(strip, idx)
+		}
+		flush()
+	}
+}
+
+func (d *plyData) addPolygon(list []float64) {
+	d.polyStarts = append(d.polyStarts, len(d.polygons))
+	for _, idx := range list {
+		d.polygons = append(d.polygons, int(idx))
+	}
+	if len(d.polyUvs) > 0 {
+		for len(d.polyUvs) < len(d.polygons) {
+			d.polyUvs = append(d.polyUvs, vec2.T{})
+		}
+	}
+}
+
+// polygon 第 i 个面的顶点索引和起始位置
+func (d *plyData) polygon(i int) ([]int, int) {
+	start, end := d.polyStarts[i], len(d.polygons)
+	if i+1 < len(d.polyStarts) {
+		end = d.polyStarts[i+1]
+	}
+	return d.polygons[start:end], start
+}
+
+// buildMesh 组装 MST 网格，纹理序号对应 TextureFile 注释，每个纹理一个材质，
+// 没有纹理的面使用默认材质
+func (cv *PlyToMst) buildMesh(d *plyData, textureFiles []string) (*mst.Mesh, *[6]float64) {
+	mesh := mst.NewMesh()
+	def := &mst.BaseMaterial{Color: [3]byte{200, 200, 200}}
+	if len(d.colors) > 0 {
+		// 顶点颜色与材质颜色相乘，使用白色避免改变顶点颜色
+		def.Color = [3]byte{255, 255, 255}
+	}
+	mesh.Materials = append(mesh.Materials, def)
+
+	bbox := vec3d.MinBox
+	for _, p := range d.positions {
+		v := vec3d.T{float64(p[0]), float64(p[1]), float64(p[2])}
+		bbox.Extend(&v)
+	}
+
+	node := &mst.MeshNode{}
+	mesh.Nodes = append(mesh.Nodes, node)
+	if len(d.polyStarts) == 0 {
+		// 点云
+		node.Vertices = d.positions
+		node.Normals = d.normals
+		node.TexCoords = d.texCoords
+		node.Colors = d.colors
+		return mesh, bbox.Array()
+	}
+
+	// 每个纹理序号对应的材质，纹理无法读取时使用默认材质
+	batches := make(map[int32]int32)
+	batch := func(tex int32) int32 {
+		if b, ok := batches[tex]; ok {
+			return b
+		}
+		b := int32(0)
+		if tex >= 0 && int(tex) < len(textureFiles) {
+			if t := cv.loadTexture(textureFiles[tex]); t != nil {
+				mtl := &mst.TextureMaterial{Texture: t}
+				mtl.Color = def.Color
+				b = int32(len(mesh.Materials))
+				mesh.Materials = append(mesh.Materials, mtl)
+			}
+		}
+		batches[tex] = b
+		return b
+	}
+
+	// 逐角点纹理坐标需要按 (顶点, 纹理坐标) 拆分顶点，否则直接使用文件中的顶点
+	wedge := len(d.polyUvs) > 0
+	type vertexKey struct {
+		index int
+		uv    vec2.T
+	}
+	vertMap := make(map[vertexKey]uint32)
+	vertex := func(idx, corner int) uint32 {
+		if !wedge {
+			return uint32(idx)
+		}
+		key := vertexKey{idx, d.polyUvs[corner]}
+		vi, ok := vertMap[key]
+		if !ok {
+			vi = uint32(len(node.Vertices))
+			vertMap[key] = vi
+			node.Vertices = append(node.Vertices, d.positions[idx])
+			node.TexCoords = append(node.TexCoords, key.uv)
+			if len(d.normals) > 0 {
+				node.Normals = append(node.Normals, d.normals[idx])
+			}
+			if len(d.colors) > 0 {
+				node.Colors = append(node.Colors, d.colors[idx])
+			}
+		}
+		return vi
+	}
+	if !wedge {
+		node.Vertices = d.positions
+		node.Normals = d.normals
+		node.TexCoords = d.texCoords
+		node.Colors = d.colors
+	}
+
+	groups := make(map[int32]*mst.MeshTriangle)
+	invalid := 0
+	ring := []vec3d.T{}
+	for i := range d.polyStarts {
+		poly, start := d.polygon(i)
+		valid := len(poly) >= 3
+		for _, idx := range poly {
+			if idx < 0 || idx >= len(d.positions) {
+				valid = false
+			}
+		}
+		if !valid {
+			invalid++
+			continue
+		}
+
+		tex := int32(0)
+		if len(d.polyTex) > 0 {
+			tex = -1
+			if i < len(d.polyTex) {
+				tex = d.polyTex[i]
+			}
+		}
+		b := batch(tex)
+		tg, ok := groups[b]
+		if !ok {
+			tg = &mst.MeshTriangle{Batchid: b}
+			groups[b] = tg
+			node.FaceGroup = append(node.FaceGroup, tg)
+		}
+
+		tris := [][3]int{{0, 1, 2}}
+		if len(poly) > 3 {
+			ring = ring[:0]
+			for _, idx := range poly {
+				p := d.positions[idx]
+				ring = append(ring, vec3d.T{float64(p[0]), float64(p[1]), float64(p[2])})
+			}
+			tris = triangulatePolygon(ring, nil)
+		}
+		for _, tri := range tris {
+			face := &mst.Face{}
+			for k, c := range tri {
+				face.Vertex[k] = vertex(poly[c], start+c)
+			}
+			tg.Faces = append(tg.Faces, face)
+		}
+	}
+	if invalid > 0 {
+		cv.Diagnostics.Warnf("ply: %d faces with invalid vertex indices skipped", invalid)
+	}
+	if len(node.Normals) == 0 {
+		node.ReComputeNormal()
+	}
+	return mesh, bbox.Array()
+}
+
+// loadTexture 读取 TextureFile 引用的纹理，路径相对 PLY 文件所在目录
+func (cv *PlyToMst) loadTexture(name string) *mst.Texture {
+	p := strings.ReplaceAll(name, "\\", "/")
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(cv.baseDir, p)
+	}
+	img, err := loadImage(p)
+	if err != nil {
+		cv.Diagnostics.Warnf("ply: texture %q cannot be loaded: %v", name, err)
+		return nil
+	}
+	tex := imageToTex(img, cv.texId)
+	tex.Repeated = true
+	cv.texId++
+	return tex
+}
+
+func plyColorByte(v float64) byte {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return byte(v + 0.5)
+}
+
+// Ensure PlyToMst implements FormatConvert interface
+var _ FormatConvert = (*PlyToMst)(nil)
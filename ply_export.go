@@ -0,0 +1,230 @@
+package asset3d
+
+import (
+	"bufio"
+	"encoding/binary"
+	"math"
+	"os"
+	"strconv"
+
+	mst "github.com/flywave/go-mst"
+	"github.com/flywave/go3d/vec2"
+	"github.com/flywave/go3d/vec3"
+)
+
+// MstToPly 将 MST 网格导出为 PLY，所有节点合并为一个网格，实例按每个变换展开。
+// PLY 没有材质: 节点没有顶点颜色时以材质颜色作为顶点颜色；纹理写为导出文件旁的 PNG，
+// 以 TextureFile 注释引用，有多个纹理或部分面没有纹理时面带有 texnumber 属性
+type MstToPly struct {
+	// Ascii 为 true 时写出 ASCII PLY，否则写出小端二进制 PLY
+	Ascii bool
+	// Diagnostics 导出过程中的诊断信息，例如无法解码的纹理
+	Diagnostics Diagnostics
+}
+
+type plyExportVertex struct {
+	pos    vec3.T
+	normal vec3.T
+	uv     vec2.T
+	color  [3]byte
+}
+
+type plyExportFace struct {
+	index [3]uint32
+	tex   int32
+}
+
+func (cv *MstToPly) Export(mesh *mst.Mesh, path string) error {
+	cv.Diagnostics = nil
+	em := flattenMesh(mesh, nil)
+	texs := newExportTextures(path, &cv.Diagnostics)
+
+	var hasNormal, hasUv, hasColor bool
+	for _, nd := range em.nodes {
+		hasNormal = hasNormal || len(nd.normals) > 0
+		hasUv = hasUv || len(nd.texCoords) > 0
+		hasColor = hasColor || len(nd.colors) > 0
+	}
+	hasColor = hasColor || len(em.materials) > 0
+
+	// 每个材质的颜色和纹理序号
+	var texFiles []string
+	texNumbers := make(map[string]int32)
+	mtlColors := make([][3]byte, len(em.materials))
+	mtlTex := make([]int32, len(em.materials))
+	for i, m := range em.materials {
+		mtl := newExportMaterial(m)
+		mtlColors[i] = mtl.color
+		mtlTex[i] = -1
+		name, err := texs.write(mtl.texture)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			continue
+		}
+		n, ok := texNumbers[name]
+		if !ok {
+			n = int32(len(texFiles))
+			texNumbers[name] = n
+			texFiles = append(texFiles, name)
+		}
+		mtlTex[i] = n
+	}
+
+	var verts []plyExportVertex
+	var faces []plyExportFace
+	for _, nd := range em.nodes {
+		// 没有顶点颜色时同一顶点在不同材质中的颜色不同，按 (顶点, 材质) 拆分
+		index := make(map[[2]int]uint32)
+		for _, g := range nd.groups {
+			cl, tex := [3]byte{255, 255, 255}, int32(-1)
+			if g.material >= 0 {
+				cl, tex = mtlColors[g.material], mtlTex[g.material]
+			}
+			key := g.material
+			if len(nd.colors) > 0 {
+				key = 0
+			}
+			for _, f := range g.faces {
+				pf := plyExportFace{tex: tex}
+				for k, idx := range f {
+					vi, ok := index[[2]int{int(idx), key}]
+					if !ok {
+						vi = uint32(len(verts))
+						index[[2]int{int(idx), key}] = vi
+						v := plyExportVertex{pos: nd.vertices[idx], color: cl}
+						if len(nd.normals) > 0 {
+							v.normal = nd.normals[idx]
+						}
+						if len(nd.texCoords) > 0 {
+							v.uv = nd.texCoords[idx]
+						}
+						if len(nd.colors) > 0 {
+							v.color = nd.colors[idx]
+						}
+						verts = append(verts, v)
+					}
+					pf.index[k] = vi
+				}
+				faces = append(faces, pf)
+			}
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	// 只有一个纹理且所有面都使用它时不需要 texnumber
+	hasTex := len(texFiles) > 1
+	for _, pf := range faces {
+		hasTex = hasTex || (len(texFiles) > 0 && pf.tex < 0)
+	}
+
+	format := "binary_little_endian"
+	if cv.Ascii {
+		format = "ascii"
+	}
+	w.WriteString("ply\nformat " + format + " 1.0\n")
+	for _, name := range texFiles {
+		w.WriteString("comment TextureFile " + name + "\n")
+	}
+	w.WriteString("element vertex " + strconv.Itoa(len(verts)) + "\n")
+	w.WriteString("property float x\nproperty float y\nproperty float z\n")
+	if hasNormal {
+		w.WriteString("property float nx\nproperty float ny\nproperty float nz\n")
+	}
+	if hasUv {
+		w.WriteString("property float s\nproperty float t\n")
+	}
+	if hasColor {
+		w.WriteString("property uchar red\nproperty uchar green\nproperty uchar blue\n")
+	}
+	w.WriteString("element face " + strconv.Itoa(len(faces)) + "\n")
+	w.WriteString("property list uchar int vertex_indices\n")
+	if hasTex {
+		w.WriteString("property int texnumber\n")
+	}
+	w.WriteString("end_header\n")
+
+	enc := &plyEncoder{w: w, ascii: cv.Ascii}
+	for i := range verts {
+		v := &verts[i]
+		enc.floats(v.pos[:]...)
+		if hasNormal {
+			enc.floats(v.normal[:]...)
+		}
+		if hasUv {
+			enc.floats(v.uv[:]...)
+		}
+		if hasColor {
+			enc.bytes(v.color[:]...)
+		}
+		enc.end()
+	}
+	for _, pf := range faces {
+		enc.bytes(3)
+		enc.ints(int32(pf.index[0]), int32(pf.index[1]), int32(pf.index[2]))
+		if hasTex {
+			enc.ints(pf.tex)
+		}
+		enc.end()
+	}
+
+	err = w.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// plyEncoder 按 ASCII 或小端二进制写出 PLY 的属性值，ASCII 时每个元素一行
+type plyEncoder struct {
+	w     *bufio.Writer
+	ascii bool
+	buf   []byte
+}
+
+func (e *plyEncoder) floats(vals ...float32) {
+	for _, v := range vals {
+		if e.ascii {
+			e.buf = strconv.AppendFloat(e.buf, float64(v), 'g', -1, 32)
+			e.buf = append(e.buf, ' ')
+		} else {
+			e.buf = binary.LittleEndian.AppendUint32(e.buf, math.Float32bits(v))
+		}
+	}
+}
+
+func (e *plyEncoder) ints(vals ...int32) {
+	for _, v := range vals {
+		if e.ascii {
+			e.buf = strconv.AppendInt(e.buf, int64(v), 10)
+			e.buf = append(e.buf, ' ')
+		} else {
+			e.buf = binary.LittleEndian.AppendUint32(e.buf, uint32(v))
+		}
+	}
+}
+
+func (e *plyEncoder) bytes(vals ...byte) {
+	for _, v := range vals {
+		if e.ascii {
+			e.buf = strconv.AppendUint(e.buf, uint64(v), 10)
+			e.buf = append(e.buf, ' ')
+		} else {
+			e.buf = append(e.buf, v)
+		}
+	}
+}
+
+// end 结束一个元素，ASCII 时去掉末尾的空格并换行
+func (e *plyEncoder) end() {
+	if e.ascii && len(e.buf) > 0 {
+		e.buf[len(e.buf)-1] = '\n'
+	}
+	e.w.Write(e.buf)
+	e.buf = e.buf[:0]
+}
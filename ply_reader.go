@@ -0,0 +1,320 @@
+package asset3d
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// plyFormat PLY 数据的存储格式
+type plyFormat int
+
+const (
+	plyAscii plyFormat = iota
+	plyBinaryLittleEndian
+	plyBinaryBigEndian
+)
+
+// plyType PLY 属性的数据类型
+type plyType int
+
+const (
+	plyInt8 plyType = iota
+	plyUint8
+	plyInt16
+	plyUint16
+	plyInt32
+	plyUint32
+	plyFloat32
+	plyFloat64
+)
+
+// plyTypeNames 类型名，包括 PLY 1.0 的旧名称和常见的带位数的名称
+var plyTypeNames = map[string]plyType{
+	"char": plyInt8, "int8": plyInt8,
+	"uchar": plyUint8, "uint8": plyUint8,
+	"short": plyInt16, "int16": plyInt16,
+	"ushort": plyUint16, "uint16": plyUint16,
+	"int": plyInt32, "int32": plyInt32,
+	"uint": plyUint32, "uint32": plyUint32,
+	"float": plyFloat32, "float32": plyFloat32,
+	"double": plyFloat64, "float64": plyFloat64,
+}
+
+func (t plyType) size() int {
+	switch t {
+	case plyInt8, plyUint8:
+		return 1
+	case plyInt16, plyUint16:
+		return 2
+	case plyInt32, plyUint32, plyFloat32:
+		return 4
+	}
+	return 8
+}
+
+func (t plyType) isFloat() bool {
+	return t == plyFloat32 || t == plyFloat64
+}
+
+// maxValue 整数类型的最大值，用于把整数颜色换算到 [0, 1]
+func (t plyType) maxValue() float64 {
+	switch t {
+	case plyInt8:
+		return math.MaxInt8
+	case plyUint8:
+		return math.MaxUint8
+	case plyInt16:
+		return math.MaxInt16
+	case plyUint16:
+		return math.MaxUint16
+	case plyInt32:
+		return math.MaxInt32
+	case plyUint32:
+		return math.MaxUint32
+	}
+	return 1
+}
+
+// plyProperty 元素的一个属性，list 为 true 时先读取 countType 类型的元素个数
+type plyProperty struct {
+	name      string
+	typ       plyType
+	list      bool
+	countType plyType
+}
+
+// plyElement 头部声明的元素，属性按声明顺序排列
+type plyElement struct {
+	name  string
+	count int
+	props []*plyProperty
+}
+
+// property 按名称查找属性的序号，不存在时返回 -1
+func (el *plyElement) property(names ...string) int {
+	for _, name := range names {
+		for i, p := range el.props {
+			if p.name == name {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// plyHeader PLY 文件头
+type plyHeader struct {
+	format   plyFormat
+	elements []*plyElement
+	// textureFiles "comment TextureFile" 注释引用的纹理，按出现顺序即为面的 texnumber
+	textureFiles []string
+}
+
+// plyRecord 一个元素实例的属性值，scalars 和 lists 按属性序号索引，读取下一个实例时复用
+type plyRecord struct {
+	scalars []float64
+	lists   [][]float64
+}
+
+// readPlyHeader 读取 end_header 之前的文件头，r 停在数据的开始处
+func readPlyHeader(r *bufio.Reader) (*plyHeader, error) {
+	line, err := r.ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != "ply" {
+		return nil, errors.New("ply: missing magic number")
+	}
+	h := &plyHeader{format: -1}
+	var cur *plyElement
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("ply: unexpected end of header: %v", err)
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "format":
+			if len(fields) < 2 {
+				return nil, errors.New("ply: invalid format line")
+			}
+			switch fields[1] {
+			case "ascii":
+				h.format = plyAscii
+			case "binary_little_endian":
+				h.format = plyBinaryLittleEndian
+			case "binary_big_endian":
+				h.format = plyBinaryBigEndian
+			default:
+				return nil, fmt.Errorf("ply: unknown format %q", fields[1])
+			}
+		case "comment":
+			if len(fields) >= 3 && strings.EqualFold(fields[1], "TextureFile") {
+				// 文件名可能含有空格，取关键字之后的整行
+				rest := strings.TrimSpace(line)
+				rest = strings.TrimSpace(rest[len("comment"):])
+				h.textureFiles = append(h.textureFiles, strings.TrimSpace(rest[len(fields[1]):]))
+			}
+		case "element":
+			if len(fields) < 3 {
+				return nil, errors.New("ply: invalid element line")
+			}
+			count, err := strconv.Atoi(fields[2])
+			if err != nil || count < 0 {
+				return nil, fmt.Errorf("ply: invalid element count %q", fields[2])
+			}
+			cur = &plyElement{name: fields[1], count: count}
+			h.elements = append(h.elements, cur)
+		case "property":
+			if cur == nil {
+				return nil, errors.New("ply: property outside of element")
+			}
+			p, err := parsePlyProperty(fields[1:])
+			if err != nil {
+				return nil, err
+			}
+			cur.props = append(cur.props, p)
+		case "end_header":
+			if h.format < 0 {
+				return nil, errors.New("ply: missing format")
+			}
+			return h, nil
+		}
+	}
+}
+
+func parsePlyProperty(fields []string) (*plyProperty, error) {
+	if len(fields) >= 4 && fields[0] == "list" {
+		ct, ok1 := plyTypeNames[fields[1]]
+		it, ok2 := plyTypeNames[fields[2]]
+		if !ok1 || !ok2 || ct.isFloat() {
+			return nil, fmt.Errorf("ply: invalid list property %q", strings.Join(fields, " "))
+		}
+		return &plyProperty{name: fields[3], typ: it, list: true, countType: ct}, nil
+	}
+	if len(fields) < 2 {
+		return nil, errors.New("ply: invalid property line")
+	}
+	t, ok := plyTypeNames[fields[0]]
+	if !ok {
+		return nil, fmt.Errorf("ply: unknown property type %q", fields[0])
+	}
+	return &plyProperty{name: fields[1], typ: t}, nil
+}
+
+// readElements 按头部声明的顺序读取所有元素，每个元素实例调用一次 fn
+func (h *plyHeader) readElements(r *bufio.Reader, fn func(el *plyElement, rec *plyRecord) error) error {
+	var dec plyDecoder
+	switch h.format {
+	case plyAscii:
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64*1024), 1024*1024)
+		sc.Split(bufio.ScanWords)
+		dec = &plyAsciiDecoder{sc: sc}
+	case plyBinaryLittleEndian:
+		dec = &plyBinaryDecoder{r: r, order: binary.LittleEndian}
+	default:
+		dec = &plyBinaryDecoder{r: r, order: binary.BigEndian}
+	}
+
+	for _, el := range h.elements {
+		rec := &plyRecord{
+			scalars: make([]float64, len(el.props)),
+			lists:   make([][]float64, len(el.props)),
+		}
+		for i := 0; i < el.count; i++ {
+			for pi, p := range el.props {
+				if !p.list {
+					v, err := dec.value(p.typ)
+					if err != nil {
+						return plyReadError(el, i, err)
+					}
+					rec.scalars[pi] = v
+					continue
+				}
+				n, err := dec.value(p.countType)
+				if err != nil {
+					return plyReadError(el, i, err)
+				}
+				if n < 0 {
+					return plyReadError(el, i, fmt.Errorf("negative list size %v", n))
+				}
+				list := rec.lists[pi][:0]
+				for k := 0; k < int(n); k++ {
+					v, err := dec.value(p.typ)
+					if err != nil {
+						return plyReadError(el, i, err)
+					}
+					list = append(list, v)
+				}
+				rec.lists[pi] = list
+			}
+			if err := fn(el, rec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func plyReadError(el *plyElement, i int, err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("ply: element %s %d: %v", el.name, i, err)
+}
+
+// plyDecoder 按类型读取一个属性值
+type plyDecoder interface {
+	value(t plyType) (float64, error)
+}
+
+type plyAsciiDecoder struct {
+	sc *bufio.Scanner
+}
+
+func (d *plyAsciiDecoder) value(t plyType) (float64, error) {
+	if !d.sc.Scan() {
+		if err := d.sc.Err(); err != nil {
+			return 0, err
+		}
+		return 0, io.EOF
+	}
+	return strconv.ParseFloat(d.sc.Text(), 64)
+}
+
+type plyBinaryDecoder struct {
+	r     io.Reader
+	order binary.ByteOrder
+	buf   [8]byte
+}
+
+func (d *plyBinaryDecoder) value(t plyType) (float64, error) {
+	b := d.buf[:t.size()]
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return 0, err
+	}
+	switch t {
+	case plyInt8:
+		return float64(int8(b[0])), nil
+	case plyUint8:
+		return float64(b[0]), nil
+	case plyInt16:
+		return float64(int16(d.order.Uint16(b))), nil
+	case plyUint16:
+		return float64(d.order.Uint16(b)), nil
+	case plyInt32:
+		return float64(int32(d.order.Uint32(b))), nil
+	case plyUint32:
+		return float64(d.order.Uint32(b)), nil
+	case plyFloat32:
+		return float64(math.Float32frombits(d.order.Uint32(b))), nil
+	}
+	return math.Float64frombits(d.order.Uint64(b)), nil
+}
@@ -0,0 +1,187 @@
+package asset3d
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flywave/go3d/vec2"
+)
+
+func TestPlyToMst_Ascii(t *testing.T) {
+	// 属性顺序任意，包含未识别的属性和元素，四边形面需要三角化
+	src := `ply
+format ascii 1.0
+comment made by hand
+element vertex 4
+property float confidence
+property float z
+property float y
+property float x
+property uchar red
+property uchar green
+property uchar blue
+element face 1
+property list uchar int vertex_indices
+element edge 1
+property int vertex1
+property int vertex2
+end_header
+0.5 0 0 0 255 0 0
+0.5 0 0 1 0 255 0
+0.5 0 1 1 0 0 255
+0.5 0 1 0 255 255 255
+4 0 1 2 3
+0 1
+`
+	path := filepath.Join(t.TempDir(), "quad.ply")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cv := &PlyToMst{}
+	mesh, bbox, err := cv.Convert(path)
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	nd := mesh.Nodes[0]
+	if len(nd.Vertices) != 4 || len(nd.FaceGroup) != 1 || len(nd.FaceGroup[0].Faces) != 2 {
+		t.Fatalf("期望4个顶点和2个三角形，实际%d个顶点", len(nd.Vertices))
+	}
+	if nd.Vertices[2][0] != 1 || nd.Vertices[2][1] != 1 || nd.Vertices[2][2] != 0 {
+		t.Errorf("顶点坐标错误: %v", nd.Vertices[2])
+	}
+	if nd.Colors[1] != [3]byte{0, 255, 0} {
+		t.Errorf("顶点颜色错误: %v", nd.Colors[1])
+	}
+	if len(nd.Normals) != 4 {
+		t.Errorf("没有计算法线")
+	}
+	if bbox[3] != 1 || bbox[4] != 1 || bbox[5] != 0 {
+		t.Errorf("包围盒错误: %v", bbox)
+	}
+}
+
+func TestPlyToMst_BinaryBigEndian(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("ply\nformat binary_big_endian 1.0\n" +
+		"element vertex 3\nproperty double x\nproperty double y\nproperty double z\n" +
+		"property ushort red\nproperty ushort green\nproperty ushort blue\n" +
+		"element face 1\nproperty list uint uint vertex_indices\nend_header\n")
+	for _, v := range [][3]float64{{0, 0, 0}, {2, 0, 0}, {0, 3, 0}} {
+		for _, c := range v {
+			binary.Write(&buf, binary.BigEndian, math.Float64bits(c))
+		}
+		binary.Write(&buf, binary.BigEndian, []uint16{65535, 0, 32768})
+	}
+	binary.Write(&buf, binary.BigEndian, []uint32{3, 0, 1, 2})
+
+	path := filepath.Join(t.TempDir(), "tri.ply")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cv := &PlyToMst{}
+	mesh, bbox, err := cv.Convert(path)
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	nd := mesh.Nodes[0]
+	if nd.Vertices[1][0] != 2 || nd.Vertices[2][1] != 3 {
+		t.Errorf("顶点坐标错误: %v", nd.Vertices)
+	}
+	if nd.Colors[0] != [3]byte{255, 0, 128} {
+		t.Errorf("顶点颜色错误: %v", nd.Colors[0])
+	}
+	if bbox[3] != 2 || bbox[4] != 3 {
+		t.Errorf("包围盒错误: %v", bbox)
+	}
+}
+
+func TestPlyToMst_PointCloud(t *testing.T) {
+	src := "ply\nformat ascii 1.0\nelement vertex 2\nproperty float x\nproperty float y\nproperty float z\n" +
+		"property float nx\nproperty float ny\nproperty float nz\nend_header\n0 0 0 0 0 1\n1 2 3 0 1 0\n"
+	path := filepath.Join(t.TempDir(), "points.ply")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cv := &PlyToMst{}
+	mesh, _, err := cv.Convert(path)
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	nd := mesh.Nodes[0]
+	if len(nd.Vertices) != 2 || len(nd.Normals) != 2 || len(nd.FaceGroup) != 0 {
+		t.Errorf("点云转换错误: %d个顶点，%d个面组", len(nd.Vertices), len(nd.FaceGroup))
+	}
+}
+
+func TestPlyToMst_WedgeTexture(t *testing.T) {
+	dir := t.TempDir()
+	// 借用导出器写出纹理文件
+	exp := newExportTextures(filepath.Join(dir, "tex.ply"), nil)
+	name, err := exp.write(exportTestMesh().Materials[0].GetTexture())
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := "ply\nformat ascii 1.0\ncomment TextureFile " + name + "\ncomment TextureFile missing.png\n" +
+		"element vertex 4\nproperty float x\nproperty float y\nproperty float z\n" +
+		"element face 2\nproperty list uchar int vertex_indices\nproperty list uchar float texcoord\nproperty int texnumber\n" +
+		"end_header\n0 0 0\n1 0 0\n1 1 0\n0 1 0\n" +
+		"3 0 1 2 6 0 0 1 0 1 1 0\n3 0 2 3 6 0.5 0.5 1 1 0 1 1\n"
+	path := filepath.Join(dir, "tex.ply")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cv := &PlyToMst{}
+	mesh, _, err := cv.Convert(path)
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	nd := mesh.Nodes[0]
+	// 顶点 0 的两个角点纹理坐标不同，拆分为两个顶点
+	if len(nd.Vertices) != 5 {
+		t.Errorf("期望5个顶点，实际%d个", len(nd.Vertices))
+	}
+	if nd.TexCoords[3] != (vec2.T{0.5, 0.5}) {
+		t.Errorf("纹理坐标错误: %v", nd.TexCoords)
+	}
+	if len(mesh.Materials) != 2 || !mesh.Materials[1].HasTexture() {
+		t.Fatalf("纹理材质错误: %d个材质", len(mesh.Materials))
+	}
+	// 找不到的纹理使用默认材质并记录诊断信息
+	if len(nd.FaceGroup) != 2 || nd.FaceGroup[0].Batchid != 1 || nd.FaceGroup[1].Batchid != 0 {
+		t.Errorf("面组错误")
+	}
+	if len(cv.Diagnostics) != 1 {
+		t.Errorf("期望1条诊断信息，实际%v", cv.Diagnostics)
+	}
+}
+
+func TestPlyToMst_RoundTrip(t *testing.T) {
+	for _, ascii := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "quads.ply")
+		if err := (&MstToPly{Ascii: ascii}).Export(exportTestMesh(), path); err != nil {
+			t.Fatalf("导出失败: %v", err)
+		}
+		cv := &PlyToMst{}
+		mesh, bbox, err := cv.Convert(path)
+		if err != nil {
+			t.Fatalf("转换失败: %v", err)
+		}
+		faces := 0
+		for _, fg := range mesh.Nodes[0].FaceGroup {
+			faces += len(fg.Faces)
+		}
+		if faces != 6 || bbox[3] != 5 {
+			t.Errorf("ascii=%v: %d个三角形，包围盒%v", ascii, faces, bbox)
+		}
+		if len(mesh.Materials) != 2 || !mesh.Materials[1].HasTexture() {
+			t.Errorf("ascii=%v: 纹理丢失", ascii)
+		}
+		if c := mesh.Nodes[0].Colors[len(mesh.Nodes[0].Colors)-1]; c != [3]byte{255, 0, 0} {
+			t.Errorf("ascii=%v: 顶点颜色错误 %v", ascii, c)
+		}
+	}
+}
@@ -11,6 +11,29 @@ import (
 type RvmToMst struct {
 	// 可以添加一些配置选项
 	options *RvmToMstOptions
+
+	// Hierarchy 属性文件中的组层次(SITE/ZONE/EQUI/BRAN 等)和属性，没有读取属性文件时为 nil
+	Hierarchy *RvmHierarchy
+	// NodeNames 导出的网格节点所属的 RVM 组名，导出器不提供组名时为空
+	NodeNames map[*mst.MeshNode]string
+	// Groups 网格节点对应的属性文件中的组。MST 网格没有存放属性的位置，
+	// 按节点查找位号、类型和属性，多个细节层次的节点都在其中
+	Groups map[*mst.MeshNode]*RvmGroup
+	// Diagnostics go-rvm 在解析、连接和细分时输出的日志
+	Diagnostics Diagnostics
+}
+
+// RvmTessellation RVM 参数化几何体(圆柱、圆环、球面等)的细分参数，零值使用默认值
+type RvmTessellation struct {
+	// Tolerance 细分容差，曲面与三角面之间的最大距离(模型单位)，默认 0.1
+	Tolerance float32
+	// MaxSamples 圆周方向的最大分段数，默认 100。go-rvm 的细分器只接受上限，
+	// 最少分段数由细分器固定
+	MaxSamples uint32
+	// CullLeafThreshold 包围盒尺寸小于该值的叶节点不输出，不大于 0 时不剔除
+	CullLeafThreshold float32
+	// CullGeometryThreshold 包围盒尺寸小于该值的几何体不输出，不大于 0 时不剔除
+	CullGeometryThreshold float32
 }
 
 // RvmToMstOptions RVM到MST转换器的选项
@@ -20,18 +43,37 @@ type RvmToMstOptions struct {
 	IncludeAttributes bool // 是否包含属性
 	MergeGeometries   bool // 是否合并几何体
 	Anchors           bool // 是否包含锚点
+
+	// AttributeFile PDMS/E3D 导出的属性文件，为空且 IncludeAttributes 为真时在每个 RVM 文件旁查找同名的 .att 或 .txt 文件
+	AttributeFile string
+
+	// RvmTessellation Convert 使用的细分参数
+	RvmTessellation
+	// Lods ConvertLods 输出的各细节层次，为空时只输出 RvmTessellation 一个层次
+	Lods []RvmTessellation
+}
+
+// RvmLodMesh ConvertLods 输出的一个细节层次
+type RvmLodMesh struct {
+	Tessellation RvmTessellation
+	Mesh         *mst.Mesh
+	BBox         *[6]float64
 }
 
 // NewRvmToMst 创建新的RVM到MST转换器
 func NewRvmToMst() *RvmToMst {
 	return &RvmToMst{
-		options: &RvmToMstOptions{
-			CenterModel:       false,
-			RotateZToY:        false,
-			IncludeAttributes: false,
-			MergeGeometries:   false,
-			Anchors:           true,
-		},
+		options: defaultRvmToMstOptions(),
+	}
+}
+
+func defaultRvmToMstOptions() *RvmToMstOptions {
+	return &RvmToMstOptions{
+		CenterModel:       false,
+		RotateZToY:        false,
+		IncludeAttributes: false,
+		MergeGeometries:   false,
+		Anchors:           true,
 	}
 }
 
@@ -44,86 +86,204 @@ func NewRvmToMstWithOptions(options *RvmToMstOptions) *RvmToMst {
 
 // Convert 将RVM文件转换为MST网格格式
 func (cv *RvmToMst) Convert(inputFilename string) (*mst.Mesh, *[6]float64, error) {
-	// 创建RVM存储
-	store := rvm.NewStore()
+	return cv.ConvertFiles([]string{inputFilename})
+}
 
-	// 创建日志记录器
-	logger := func(level int, format string, args ...interface{}) {
-		// 可以根据需要处理日志
-		// 目前我们只是简单地忽略日志
-		_ = level
-		_ = format
-		_ = args
+// ConvertFiles 将同一工厂的多个RVM文件解析到一个存储中，连接、对齐后一起导出，
+// 各文件旁的属性文件合并到 Hierarchy
+func (cv *RvmToMst) ConvertFiles(inputFilenames []string) (*mst.Mesh, *[6]float64, error) {
+	store, err := cv.parse(inputFilenames)
+	if err != nil {
+		return nil, nil, err
 	}
+	mesh, bbox := cv.export(store, cv.opts().RvmTessellation)
+	return mesh, bbox, nil
+}
 
-	// 解析RVM文件
-	parsed, err := rvm.ParseFile(store, logger, inputFilename)
+// ConvertLods 只解析一次RVM文件，按 Lods 中的细分参数依次细分并导出，
+// 例如粗略的网页预览和精细的碰撞检查可以来自同一次解析
+func (cv *RvmToMst) ConvertLods(inputFilename string) ([]*RvmLodMesh, error) {
+	return cv.ConvertLodsFiles([]string{inputFilename})
+}
+
+// ConvertLodsFiles 与 ConvertFiles 相同，按 Lods 输出各细节层次
+func (cv *RvmToMst) ConvertLodsFiles(inputFilenames []string) ([]*RvmLodMesh, error) {
+	store, err := cv.parse(inputFilenames)
 	if err != nil {
-		return nil, nil, fmt.Errorf("解析RVM文件失败: %v", err)
-	}
-	if !parsed {
-		return nil, nil, fmt.Errorf("不支持的RVM文件格式: %s", inputFilename)
+		return nil, err
 	}
+	return cv.exportLods(store, func() (*rvm.Store, error) {
+		return cv.parseStore(inputFilenames)
+	})
+}
 
-	// 连接和对齐几何体
-	rvm.Connect(store, logger, true)
-	rvm.Align(store, logger)
+// ConvertFromStore 直接从RVM存储转换
+func (cv *RvmToMst) ConvertFromStore(store *rvm.Store) (*mst.Mesh, *[6]float64, error) {
+	cv.reset()
+	cv.prepare(store)
+	mesh, bbox := cv.export(store, cv.opts().RvmTessellation)
+	return mesh, bbox, nil
+}
 
-	// 细分几何体（使用默认容差）
-	tessellator := rvm.NewTessellator(logger, 0.1, -1, -1, 100)
-	store.Apply(tessellator)
+// ConvertLodsFromStore 直接从RVM存储按 Lods 输出各细节层次，
+// 存储无法重新解析，第一个之后的层次不应启用剔除
+func (cv *RvmToMst) ConvertLodsFromStore(store *rvm.Store) ([]*RvmLodMesh, error) {
+	cv.reset()
+	cv.prepare(store)
+	return cv.exportLods(store, nil)
+}
 
-	// 创建MST导出器
-	exporter := rvm.NewExportMST(logger)
+func (cv *RvmToMst) opts() *RvmToMstOptions {
+	if cv.options == nil {
+		// FormatFactory 创建的转换器没有选项
+		cv.options = defaultRvmToMstOptions()
+	}
+	return cv.options
+}
 
-	// 设置导出选项
-	exporter.SetCenterModel(cv.options.CenterModel)
-	exporter.SetRotateZToY(cv.options.RotateZToY)
-	exporter.SetIncludeAttributes(cv.options.IncludeAttributes)
-	exporter.SetMergeGeometries(cv.options.MergeGeometries)
-	exporter.SetAnchors(cv.options.Anchors)
-	exporter.SetPrimitiveBoundingBoxes(true) // 默认启用基本边界框
+// logger 将 go-rvm 的日志记录到 Diagnostics，级别 0、1、2 分别为信息、警告和错误
+func (cv *RvmToMst) logger() rvm.Logger {
+	return func(level int, format string, args ...interface{}) {
+		switch {
+		case level <= 0:
+			cv.Diagnostics.Infof(format, args...)
+		case level == 1:
+			cv.Diagnostics.Warnf(format, args...)
+		default:
+			cv.Diagnostics.Errorf(format, args...)
+		}
+	}
+}
 
-	// 初始化导出器
-	exporter.Init(store)
+// reset 清除上一次转换的诊断信息、属性和节点对应关系
+func (cv *RvmToMst) reset() {
+	cv.Diagnostics = nil
+	cv.Hierarchy = nil
+	cv.NodeNames = make(map[*mst.MeshNode]string)
+	cv.Groups = make(map[*mst.MeshNode]*RvmGroup)
+}
 
-	// 应用访问者模式导出几何体
-	store.Apply(exporter)
+// parse 读取属性文件，再将所有RVM文件解析到同一个存储中
+func (cv *RvmToMst) parse(inputFilenames []string) (*rvm.Store, error) {
+	cv.reset()
+	if len(inputFilenames) == 0 {
+		return nil, fmt.Errorf("没有RVM文件")
+	}
+	cv.loadAttributes(inputFilenames)
+	return cv.parseStore(inputFilenames)
+}
 
-	// 获取转换后的网格和边界框
-	mesh := exporter.GetMesh()
-	bbox := exporter.GetBoundingBox()
+// parseStore 将所有RVM文件解析到一个新的存储中并连接、对齐几何体
+func (cv *RvmToMst) parseStore(inputFilenames []string) (*rvm.Store, error) {
+	// 创建RVM存储
+	store := rvm.NewStore()
 
-	return mesh, bbox, nil
+	// 解析RVM文件
+	for _, inputFilename := range inputFilenames {
+		parsed, err := rvm.ParseFile(store, cv.logger(), inputFilename)
+		if err != nil {
+			return nil, fmt.Errorf("解析RVM文件失败: %s: %v", inputFilename, err)
+		}
+		if !parsed {
+			return nil, fmt.Errorf("不支持的RVM文件格式: %s", inputFilename)
+		}
+	}
+
+	cv.prepare(store)
+	return store, nil
 }
 
-// ConvertFromStore 直接从RVM存储转换
-func (cv *RvmToMst) ConvertFromStore(store *rvm.Store) (*mst.Mesh, *[6]float64, error) {
-	// 创建日志记录器
-	logger := func(level int, format string, args ...interface{}) {
-		// 可以根据需要处理日志
-		_ = level
-		_ = format
-		_ = args
+// loadAttributes 在 IncludeAttributes 为真或指定了 AttributeFile 时读取属性文件，得到组层次并按组名查找属性，
+// 多个文件的层次依次合并。属性文件只是附加信息，读取失败记录为警告，不影响几何体的转换
+func (cv *RvmToMst) loadAttributes(inputFilenames []string) {
+	opts := cv.opts()
+	var paths []string
+	if opts.AttributeFile != "" {
+		paths = append(paths, opts.AttributeFile)
+	} else if opts.IncludeAttributes {
+		seen := make(map[string]bool)
+		for _, inputFilename := range inputFilenames {
+			path := findRvmAttributeFile(inputFilename)
+			if path == "" {
+				cv.Diagnostics.Infof("没有找到RVM属性文件: %s", inputFilename)
+				continue
+			}
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	for _, path := range paths {
+		h, err := readRvmAttributes(path)
+		if err != nil {
+			cv.Diagnostics.Warnf("解析RVM属性文件失败: %v", err)
+			continue
+		}
+		if cv.Hierarchy == nil {
+			cv.Hierarchy = h
+		} else {
+			cv.Hierarchy.merge(h)
+		}
+	}
+}
+
+// Group 按组名(位号)查找属性文件中的组，没有属性文件或找不到时返回 nil
+func (cv *RvmToMst) Group(name string) *RvmGroup {
+	if cv.Hierarchy == nil {
+		return nil
 	}
+	return cv.Hierarchy.Group(name)
+}
 
-	// 连接和对齐几何体
+// prepare 连接和对齐几何体，与细分参数无关，多个细节层次只需执行一次
+func (cv *RvmToMst) prepare(store *rvm.Store) {
+	logger := cv.logger()
 	rvm.Connect(store, logger, true)
 	rvm.Align(store, logger)
+}
 
-	// 细分几何体（使用默认容差）
-	tessellator := rvm.NewTessellator(logger, 0.1, -1, -1, 100)
+// exportLods 依次按 Lods 细分并导出。被剔除的几何体不会重新细分，为避免沿用上一个层次的三角网，
+// 第一个之后启用剔除的层次使用 fresh 重新解析的存储，fresh 为 nil 时仍使用 store
+func (cv *RvmToMst) exportLods(store *rvm.Store, fresh func() (*rvm.Store, error)) ([]*RvmLodMesh, error) {
+	lods := cv.opts().Lods
+	if len(lods) == 0 {
+		lods = []RvmTessellation{cv.opts().RvmTessellation}
+	}
+	res := make([]*RvmLodMesh, 0, len(lods))
+	for i, lod := range lods {
+		s := store
+		if i > 0 && lod.culls() && fresh != nil {
+			var err error
+			if s, err = fresh(); err != nil {
+				return nil, err
+			}
+		}
+		mesh, bbox := cv.export(s, lod)
+		res = append(res, &RvmLodMesh{Tessellation: lod, Mesh: mesh, BBox: bbox})
+	}
+	return res, nil
+}
+
+// export 按细分参数细分几何体并导出，再次细分会替换未被剔除的几何体上一次的三角网
+func (cv *RvmToMst) export(store *rvm.Store, tess RvmTessellation) (*mst.Mesh, *[6]float64) {
+	logger := cv.logger()
+
+	// 细分几何体
+	tolerance, cullLeaf, cullGeometry, maxSamples := tess.params()
+	tessellator := rvm.NewTessellator(logger, tolerance, cullLeaf, cullGeometry, maxSamples)
 	store.Apply(tessellator)
 
 	// 创建MST导出器
 	exporter := rvm.NewExportMST(logger)
 
 	// 设置导出选项
-	exporter.SetCenterModel(cv.options.CenterModel)
-	exporter.SetRotateZToY(cv.options.RotateZToY)
-	exporter.SetIncludeAttributes(cv.options.IncludeAttributes)
-	exporter.SetMergeGeometries(cv.options.MergeGeometries)
-	exporter.SetAnchors(cv.options.Anchors)
+	options := cv.opts()
+	exporter.SetCenterModel(options.CenterModel)
+	exporter.SetRotateZToY(options.RotateZToY)
+	exporter.SetIncludeAttributes(options.IncludeAttributes)
+	exporter.SetMergeGeometries(options.MergeGeometries)
+	exporter.SetAnchors(options.Anchors)
 	exporter.SetPrimitiveBoundingBoxes(true) // 默认启用基本边界框
 
 	// 初始化导出器
@@ -134,9 +294,68 @@ func (cv *RvmToMst) ConvertFromStore(store *rvm.Store) (*mst.Mesh, *[6]float64,
 
 	// 获取转换后的网格和边界框
 	mesh := exporter.GetMesh()
-	bbox := exporter.GetBoundingBox()
+	cv.mapNodes(exporter, mesh)
+	return mesh, exporter.GetBoundingBox()
+}
 
-	return mesh, bbox, nil
+// rvmNodeNamer 能给出网格节点所属 RVM 组名的导出器
+type rvmNodeNamer interface {
+	GetNodeNames() map[*mst.MeshNode]string
+}
+
+// mapNodes 记录网格节点的组名，并按组名对应到属性文件中的组。
+// 导出器不提供组名且读取了属性文件时记录警告，属性只能通过 Group 按组名查找
+func (cv *RvmToMst) mapNodes(exporter interface{}, mesh *mst.Mesh) {
+	if cv.NodeNames == nil {
+		cv.NodeNames = make(map[*mst.MeshNode]string)
+	}
+	if cv.Groups == nil {
+		cv.Groups = make(map[*mst.MeshNode]*RvmGroup)
+	}
+	namer, ok := exporter.(rvmNodeNamer)
+	if !ok {
+		if cv.Hierarchy != nil {
+			cv.Diagnostics.Warnf("RVM导出器没有提供节点的组名，属性无法对应到网格节点")
+		}
+		return
+	}
+	if mesh == nil {
+		return
+	}
+	names := namer.GetNodeNames()
+	for _, nd := range mesh.Nodes {
+		name, ok := names[nd]
+		if !ok {
+			continue
+		}
+		cv.NodeNames[nd] = name
+		if g := cv.Group(name); g != nil {
+			cv.Groups[nd] = g
+		}
+	}
+}
+
+// culls 是否启用了叶节点或几何体剔除
+func (t RvmTessellation) culls() bool {
+	return t.CullLeafThreshold > 0 || t.CullGeometryThreshold > 0
+}
+
+// params 填充默认值后的细分器参数，剔除阈值为 -1 表示不剔除
+func (t RvmTessellation) params() (tolerance, cullLeaf, cullGeometry float32, maxSamples uint32) {
+	tolerance, cullLeaf, cullGeometry, maxSamples = t.Tolerance, t.CullLeafThreshold, t.CullGeometryThreshold, t.MaxSamples
+	if tolerance <= 0 {
+		tolerance = 0.1
+	}
+	if cullLeaf <= 0 {
+		cullLeaf = -1
+	}
+	if cullGeometry <= 0 {
+		cullGeometry = -1
+	}
+	if maxSamples == 0 {
+		maxSamples = 100
+	}
+	return
 }
 
 // Ensure RvmToMst implements FormatConvert interface
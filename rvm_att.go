@@ -0,0 +1,258 @@
+package asset3d
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RvmGroup PDMS/E3D 属性文件中的一个组(SITE、ZONE、EQUI、BRAN 等)，名称与 RVM 文件中的组名相同
+type RvmGroup struct {
+	Name string
+	// Type 组的类型，即 TYPE 属性，例如 SITE、ZONE、EQUI、BRAN
+	Type string
+	// Attributes 组的属性，Keys 为属性在文件中的顺序
+	Attributes map[string]string
+	Keys       []string
+	Parent     *RvmGroup
+	Children   []*RvmGroup
+}
+
+// Path 从根组到本组的名称
+func (g *RvmGroup) Path() []string {
+	var path []string
+	for p := g; p != nil; p = p.Parent {
+		path = append([]string{p.Name}, path...)
+	}
+	return path
+}
+
+// Ancestor 向上查找第一个指定类型的组，例如管道所属的 ZONE，找不到时返回 nil
+func (g *RvmGroup) Ancestor(typ string) *RvmGroup {
+	for p := g.Parent; p != nil; p = p.Parent {
+		if strings.EqualFold(p.Type, typ) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Walk 先序遍历本组及其子组，fn 返回 false 时不再进入该组的子组
+func (g *RvmGroup) Walk(fn func(g *RvmGroup) bool) {
+	if !fn(g) {
+		return
+	}
+	for _, c := range g.Children {
+		c.Walk(fn)
+	}
+}
+
+// RvmHierarchy 属性文件中的组层次
+type RvmHierarchy struct {
+	// Paths 属性文件的路径，多个文件合并时按读取顺序排列
+	Paths []string
+	Roots []*RvmGroup
+	// byName 按名称索引，同名的组保留第一个
+	byName map[string]*RvmGroup
+}
+
+// Group 按名称(位号)查找组，名称的前导 "/" 可以省略
+func (h *RvmHierarchy) Group(name string) *RvmGroup {
+	if g, ok := h.byName[name]; ok {
+		return g
+	}
+	if strings.HasPrefix(name, "/") {
+		return h.byName[name[1:]]
+	}
+	return h.byName["/"+name]
+}
+
+// GroupsByType 按文件顺序返回指定类型的所有组，类型不区分大小写
+func (h *RvmHierarchy) GroupsByType(typ string) []*RvmGroup {
+	var res []*RvmGroup
+	h.Walk(func(g *RvmGroup) bool {
+		if strings.EqualFold(g.Type, typ) {
+			res = append(res, g)
+		}
+		return true
+	})
+	return res
+}
+
+// Walk 先序遍历所有组
+func (h *RvmHierarchy) Walk(fn func(g *RvmGroup) bool) {
+	for _, r := range h.Roots {
+		r.Walk(fn)
+	}
+}
+
+// rvmAttSyntax 属性文件首行声明的关键字，未声明时使用默认值
+type rvmAttSyntax struct {
+	start, end, nameEnd, sep string
+}
+
+// findRvmAttributeFile 在 RVM 文件旁查找同名的 .att 或 .txt 属性文件，文件名不区分大小写
+func findRvmAttributeFile(rvmPath string) string {
+	dir := filepath.Dir(rvmPath)
+	base := strings.TrimSuffix(filepath.Base(rvmPath), filepath.Ext(rvmPath))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, ext := range []string{".att", ".txt"} {
+		for _, e := range entries {
+			if !e.IsDir() && strings.EqualFold(e.Name(), base+ext) {
+				return filepath.Join(dir, e.Name())
+			}
+		}
+	}
+	return ""
+}
+
+// readRvmAttributes 读取属性文件
+func readRvmAttributes(path string) (*RvmHierarchy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h, err := parseRvmAttributes(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	h.Paths = []string{path}
+	return h, nil
+}
+
+// merge 将另一个文件的组层次追加到本层次，同名的组保留先读取的
+func (h *RvmHierarchy) merge(o *RvmHierarchy) {
+	h.Paths = append(h.Paths, o.Paths...)
+	h.Roots = append(h.Roots, o.Roots...)
+	for name, g := range o.byName {
+		if _, ok := h.byName[name]; !ok {
+			h.byName[name] = g
+		}
+	}
+}
+
+// parseRvmAttributes 解析属性文件，格式为
+//
+//	CADC_Attributes_File v1.0 , start: NEW , end: END , name_end: := , sep: &end&
+//	NEW /SITE-A
+//	TYPE:=SITE &end&
+//	NEW /ZONE-A
+//	...
+//	END
+//	END
+//
+// NEW 与 END 嵌套表示组的层次，属性行为 "名称 := 值"，同一行可以有多个以 sep 分隔的属性
+func parseRvmAttributes(r io.Reader) (*RvmHierarchy, error) {
+	h := &RvmHierarchy{byName: make(map[string]*RvmGroup)}
+	syn := rvmAttSyntax{start: "NEW", end: "END", nameEnd: ":=", sep: "&end&"}
+
+	var stack []*RvmGroup
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lno := 0
+	for scanner.Scan() {
+		lno++
+		line := strings.TrimSpace(scanner.Text())
+		if lno == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+			if strings.HasPrefix(line, "CADC_Attributes_File") {
+				syn.parse(line)
+				continue
+			}
+		}
+		if line == "" {
+			continue
+		}
+
+		if word, rest := rvmAttKeyword(line); word == syn.start {
+			g := &RvmGroup{Name: rest, Attributes: make(map[string]string)}
+			if len(stack) > 0 {
+				g.Parent = stack[len(stack)-1]
+				g.Parent.Children = append(g.Parent.Children, g)
+			} else {
+				h.Roots = append(h.Roots, g)
+			}
+			if _, ok := h.byName[g.Name]; !ok {
+				h.byName[g.Name] = g
+			}
+			stack = append(stack, g)
+			continue
+		} else if word == syn.end && rest == "" {
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("line %d: unbalanced %s", lno, syn.end)
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		if len(stack) == 0 {
+			return nil, fmt.Errorf("line %d: attribute outside of a group", lno)
+		}
+		g := stack[len(stack)-1]
+		for _, item := range strings.Split(line, syn.sep) {
+			i := strings.Index(item, syn.nameEnd)
+			if i < 0 {
+				continue
+			}
+			key := strings.TrimSpace(item[:i])
+			value := strings.TrimSpace(item[i+len(syn.nameEnd):])
+			if key == "" {
+				continue
+			}
+			if _, ok := g.Attributes[key]; !ok {
+				g.Keys = append(g.Keys, key)
+			}
+			g.Attributes[key] = value
+			if strings.EqualFold(key, "TYPE") {
+				g.Type = value
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("group %q is not closed", stack[len(stack)-1].Name)
+	}
+	return h, nil
+}
+
+// parse 读取首行中 "start: NEW , end: END , name_end: := , sep: &end&" 形式的声明
+func (s *rvmAttSyntax) parse(line string) {
+	for _, part := range strings.Split(line, ",") {
+		i := strings.Index(part, ":")
+		if i < 0 {
+			continue
+		}
+		key, value := strings.TrimSpace(part[:i]), strings.TrimSpace(part[i+1:])
+		if value == "" {
+			continue
+		}
+		switch key {
+		case "start":
+			s.start = value
+		case "end":
+			s.end = value
+		case "name_end":
+			s.nameEnd = value
+		case "sep":
+			s.sep = value
+		}
+	}
+}
+
+// rvmAttKeyword 拆分行首的单词和其余部分
+func rvmAttKeyword(line string) (string, string) {
+	i := strings.IndexAny(line, " \t")
+	if i < 0 {
+		return line, ""
+	}
+	return line[:i], strings.TrimSpace(line[i+1:])
+}
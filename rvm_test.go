@@ -1,7 +1,13 @@
 package asset3d
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	mst "github.com/flywave/go-mst"
+	rvm "github.com/flywave/go-rvm"
 )
 
 func TestRvmToMst(t *testing.T) {
@@ -36,4 +42,201 @@ func TestRvmToMst(t *testing.T) {
 	}
 
 	t.Log("RVM到MST转换器测试通过")
-}
\ No newline at end of file
+}
+
+func TestRvmTessellation_Params(t *testing.T) {
+	// 零值使用默认细分参数，剔除阈值不大于 0 时不剔除
+	tolerance, cullLeaf, cullGeometry, maxSamples := RvmTessellation{}.params()
+	if tolerance != 0.1 || cullLeaf != -1 || cullGeometry != -1 || maxSamples != 100 {
+		t.Errorf("默认参数错误: %v %v %v %v", tolerance, cullLeaf, cullGeometry, maxSamples)
+	}
+
+	lod := RvmTessellation{Tolerance: 5, MaxSamples: 12, CullLeafThreshold: 20, CullGeometryThreshold: 10}
+	tolerance, cullLeaf, cullGeometry, maxSamples = lod.params()
+	if tolerance != 5 || cullLeaf != 20 || cullGeometry != 10 || maxSamples != 12 {
+		t.Errorf("参数错误: %v %v %v %v", tolerance, cullLeaf, cullGeometry, maxSamples)
+	}
+
+	// FormatFactory 创建的转换器没有选项时使用默认选项
+	cv := FormatFactory(RVM).(*RvmToMst)
+	if opts := cv.opts(); !opts.Anchors || len(opts.Lods) != 0 {
+		t.Errorf("默认选项错误: %+v", opts)
+	}
+}
+
+func TestRvmToMst_Lods(t *testing.T) {
+	fine := RvmTessellation{Tolerance: 0.01, MaxSamples: 64}
+	coarse := RvmTessellation{Tolerance: 1, MaxSamples: 8, CullGeometryThreshold: 50}
+	preview := RvmTessellation{Tolerance: 5, MaxSamples: 6}
+	cv := NewRvmToMstWithOptions(&RvmToMstOptions{Lods: []RvmTessellation{fine, coarse, preview}})
+
+	// 第一个之后启用剔除的层次使用重新解析的存储，其余层次共用一次解析
+	reparsed := 0
+	lods, err := cv.exportLods(rvm.NewStore(), func() (*rvm.Store, error) {
+		reparsed++
+		return rvm.NewStore(), nil
+	})
+	if err != nil {
+		t.Fatalf("导出失败: %v", err)
+	}
+	if len(lods) != 3 || reparsed != 1 {
+		t.Fatalf("层次数量 %d, 重新解析 %d 次", len(lods), reparsed)
+	}
+	for i, want := range []RvmTessellation{fine, coarse, preview} {
+		if lods[i].Tessellation != want {
+			t.Errorf("层次 %d 的细分参数错误: %+v", i, lods[i].Tessellation)
+		}
+	}
+
+	// 没有 Lods 时只输出 RvmTessellation 一个层次
+	cv = NewRvmToMstWithOptions(&RvmToMstOptions{RvmTessellation: coarse})
+	lods, err = cv.exportLods(rvm.NewStore(), nil)
+	if err != nil || len(lods) != 1 || lods[0].Tessellation != coarse {
+		t.Errorf("默认层次错误: %v %v", lods, err)
+	}
+}
+
+func TestRvmAttributes(t *testing.T) {
+	src := "CADC_Attributes_File v1.0 , start: NEW , end: END , name_end: := , sep: &end&\n" +
+		"NEW /SITE-A\nTYPE:=SITE &end&\n" +
+		"NEW /ZONE-A\nTYPE:=ZONE &end&\nOWNER:=/SITE-A &end&\n" +
+		"NEW /P-100\nTYPE:=PIPE &end&\nPSPEC:=A1B &end& DESC:=Cooling water &end&\n" +
+		"NEW /P-100/B1\nTYPE:=BRAN &end&\nEND\nEND\n" +
+		"NEW /E-200\nTYPE:=EQUI &end&\nEND\nEND\nEND\n"
+	dir := t.TempDir()
+	rvmPath := filepath.Join(dir, "plant.rvm")
+	if err := os.WriteFile(filepath.Join(dir, "PLANT.ATT"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 自动查找同名属性文件，文件名不区分大小写
+	path := findRvmAttributeFile(rvmPath)
+	if filepath.Base(path) != "PLANT.ATT" {
+		t.Fatalf("没有找到属性文件: %q", path)
+	}
+	h, err := readRvmAttributes(path)
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	if len(h.Roots) != 1 || h.Roots[0].Type != "SITE" {
+		t.Fatalf("层次错误: %+v", h.Roots)
+	}
+
+	pipe := h.Group("P-100")
+	if pipe == nil || pipe.Attributes["PSPEC"] != "A1B" || pipe.Attributes["DESC"] != "Cooling water" {
+		t.Fatalf("属性错误: %+v", pipe)
+	}
+	if zone := pipe.Ancestor("zone"); zone == nil || zone.Name != "/ZONE-A" {
+		t.Errorf("上级组错误: %+v", zone)
+	}
+	if p := h.Group("/P-100/B1").Path(); len(p) != 4 || p[0] != "/SITE-A" {
+		t.Errorf("路径错误: %v", p)
+	}
+	if equi := h.GroupsByType("EQUI"); len(equi) != 1 || equi[0].Parent.Name != "/ZONE-A" {
+		t.Errorf("按类型查找错误: %v", equi)
+	}
+
+	if _, err := parseRvmAttributes(strings.NewReader("NEW /A\nTYPE:=SITE &end&\n")); err == nil {
+		t.Errorf("未闭合的组应返回错误")
+	}
+}
+
+type rvmTestNamer map[*mst.MeshNode]string
+
+func (n rvmTestNamer) GetNodeNames() map[*mst.MeshNode]string { return n }
+
+func TestRvmToMst_NodeGroups(t *testing.T) {
+	h, err := parseRvmAttributes(strings.NewReader("NEW /SITE-A\nTYPE:=SITE &end&\n" +
+		"NEW /P-100\nTYPE:=PIPE &end&\nPSPEC:=A1B &end&\nEND\nEND\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipe, other := &mst.MeshNode{}, &mst.MeshNode{}
+	mesh := mst.NewMesh()
+	mesh.Nodes = []*mst.MeshNode{pipe, other, {}}
+
+	// 导出器给出的组名按位号对应到属性文件中的组，属性文件中没有的组只记录组名
+	cv := NewRvmToMst()
+	cv.Hierarchy = h
+	cv.mapNodes(rvmTestNamer{pipe: "/P-100", other: "/STRU-1"}, mesh)
+	if g := cv.Groups[pipe]; g == nil || g.Attributes["PSPEC"] != "A1B" {
+		t.Fatalf("节点对应的组错误: %+v", g)
+	}
+	if cv.NodeNames[other] != "/STRU-1" || cv.Groups[other] != nil || len(cv.NodeNames) != 2 {
+		t.Errorf("节点组名错误: %v", cv.NodeNames)
+	}
+
+	// 导出器不提供组名时记录警告
+	cv.Groups, cv.NodeNames = nil, nil
+	cv.mapNodes(struct{}{}, mesh)
+	if len(cv.Groups) != 0 || len(cv.Diagnostics.Filter(DiagnosticWarning)) != 1 {
+		t.Errorf("应记录警告: %v", cv.Diagnostics)
+	}
+
+	// 从存储转换时没有属性文件，清除上一次的属性和对应关系
+	cv.Groups[pipe] = cv.Group("P-100")
+	if _, _, err := cv.ConvertFromStore(rvm.NewStore()); err != nil {
+		t.Fatal(err)
+	}
+	if cv.Hierarchy != nil || cv.Groups[pipe] != nil {
+		t.Errorf("上一次的属性没有清除")
+	}
+}
+
+func TestRvmToMst_MultipleFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, src string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("area1.att", "NEW /SITE-1\nTYPE:=SITE &end&\nNEW /E-1\nTYPE:=EQUI &end&\nEND\nEND\n")
+	write("area2.txt", "NEW /SITE-2\nTYPE:=SITE &end&\nNEW /E-2\nTYPE:=EQUI &end&\nEND\nEND\n")
+
+	// 每个RVM文件旁的属性文件合并为一个层次，没有属性文件的RVM文件跳过
+	inputs := []string{filepath.Join(dir, "area1.rvm"), filepath.Join(dir, "area2.rvm"), filepath.Join(dir, "area3.rvm")}
+	cv := NewRvmToMst()
+	cv.loadAttributes(inputs)
+	if cv.Hierarchy != nil {
+		t.Fatalf("IncludeAttributes 为假时不应读取属性文件")
+	}
+
+	cv = NewRvmToMstWithOptions(&RvmToMstOptions{IncludeAttributes: true})
+	cv.loadAttributes(inputs)
+	h := cv.Hierarchy
+	if h == nil || len(h.Paths) != 2 || len(h.Roots) != 2 {
+		t.Fatalf("合并层次错误: %+v", h)
+	}
+	if cv.Group("E-2") == nil || len(h.GroupsByType("EQUI")) != 2 {
+		t.Errorf("按位号查找错误")
+	}
+
+	if len(cv.Diagnostics) != 1 || cv.Diagnostics[0].Level != DiagnosticInfo {
+		t.Errorf("缺少属性文件应记录为信息: %v", cv.Diagnostics)
+	}
+
+	// 属性文件解析失败只记录警告
+	write("broken.att", "NEW /SITE-3\nTYPE:=SITE &end&\n")
+	cv = NewRvmToMstWithOptions(&RvmToMstOptions{AttributeFile: filepath.Join(dir, "broken.att")})
+	cv.loadAttributes(inputs)
+	if cv.Hierarchy != nil || len(cv.Diagnostics.Filter(DiagnosticWarning)) != 1 {
+		t.Errorf("解析失败应记录警告: %v", cv.Diagnostics)
+	}
+
+	// go-rvm 的日志按级别记录到 Diagnostics
+	cv.Diagnostics = nil
+	logger := cv.logger()
+	logger(0, "parsed %d chunks", 3)
+	logger(1, "unknown chunk %s", "XXXX")
+	logger(2, "truncated file")
+	if len(cv.Diagnostics) != 3 || cv.Diagnostics[0].Message != "parsed 3 chunks" {
+		t.Fatalf("诊断信息错误: %v", cv.Diagnostics)
+	}
+	if w := cv.Diagnostics.Filter(DiagnosticWarning); len(w) != 2 || w[1].Level != DiagnosticError {
+		t.Errorf("诊断级别错误: %v", w)
+	}
+
+	if _, _, err := cv.ConvertFiles(nil); err == nil {
+		t.Errorf("没有输入文件时应返回错误")
+	}
+}
@@ -9,11 +9,23 @@ import (
 	mst "github.com/flywave/go-mst"
 	mat4d "github.com/flywave/go3d/float64/mat4"
 	vec3d "github.com/flywave/go3d/float64/vec3"
+	"github.com/flywave/go3d/vec3"
 )
 
 // StlToMst 实现从STL到MST格式的转换
 // 基于现有的FbxToMst结构，提供STL文件的转换功能
 type StlToMst struct {
+	// WeldTolerance 焊接顶点的距离容差，0 表示只焊接坐标完全相同的顶点，
+	// 小于 0 时不焊接，每个三角形保留独立的三个顶点
+	WeldTolerance float64
+	// CreaseAngle 折痕角(度)，相邻三角形法线夹角超过该值时不共享顶点法线，
+	// 0 表示使用默认的 StlDefaultCreaseAngle，小于 0 时每个三角形只使用自身的法线
+	CreaseAngle float64
+	// Report 最近一次转换的拓扑检查结果，多个固体时为合计，不焊接时为 nil
+	Report *StlMeshReport
+	// NodeNames 记录每个节点对应的 solid 名称
+	NodeNames map[*mst.MeshNode]string
+
 	baseDir string
 	texId   int
 }
@@ -21,18 +33,25 @@ type StlToMst struct {
 // NewStlToMst 创建一个新的STL转换器实例
 func NewStlToMst() *StlToMst {
 	return &StlToMst{
-		texId: 0,
+		texId:       0,
+		CreaseAngle: StlDefaultCreaseAngle,
 	}
 }
 
+// creaseAngle 填充默认值后的折痕角
+func (cv *StlToMst) creaseAngle() float64 {
+	if cv.CreaseAngle == 0 {
+		return StlDefaultCreaseAngle
+	}
+	return cv.CreaseAngle
+}
+
 // Convert 将STL文件转换为MST网格格式
 // inputFilename: STL文件路径
 // 返回转换后的MST网格和边界框
 func (cv *StlToMst) Convert(inputFilename string) (*mst.Mesh, *[6]float64, error) {
-	mesh := mst.NewMesh()
-
 	// 读取STL文件
-	solid, err := stl.ReadFile(inputFilename)
+	solids, err := readStlFile(inputFilename)
 	if err != nil {
 		return nil, nil, fmt.Errorf("读取STL文件失败: %v", err)
 	}
@@ -40,161 +59,169 @@ func (cv *StlToMst) Convert(inputFilename string) (*mst.Mesh, *[6]float64, error
 	// 设置基础目录
 	cv.baseDir = filepath.Dir(inputFilename)
 
+	mesh, bbox := cv.convertSolids(solids)
+	return mesh, bbox, nil
+}
+
+// convertSolids 将STL固体转换为网格，每个固体一个节点，带颜色的三角形按颜色分组，
+// 每种颜色一个材质，没有颜色的三角形使用默认材质
+func (cv *StlToMst) convertSolids(solids []*stl.Solid) (*mst.Mesh, *[6]float64) {
+	mesh := mst.NewMesh()
+
 	// 创建默认材质
 	defaultMaterial := &mst.BaseMaterial{
 		Color: [3]byte{200, 200, 200}, // 默认灰色
 	}
 	mesh.Materials = append(mesh.Materials, defaultMaterial)
 
-	// 创建网格节点
-	meshNode := &mst.MeshNode{}
-
-	// 转换所有三角形
-	bbox := cv.convertSolidToMeshNode(solid, meshNode)
+	cv.Report = nil
+	cv.NodeNames = make(map[*mst.MeshNode]string)
+	colorMap := make(map[[3]byte]int32)
+	bbox := vec3d.MinBox
+	for _, solid := range solids {
+		// 创建网格节点
+		meshNode := &mst.MeshNode{}
 
-	// 计算法线
-	meshNode.ReComputeNormal()
+		// 转换所有三角形
+		bx := cv.convertSolidToMeshNode(solid, meshNode, mesh, colorMap)
+		bbox.Join(bx)
 
-	// 添加到网格
-	mesh.Nodes = append(mesh.Nodes, meshNode)
+		// 添加到网格
+		mesh.Nodes = append(mesh.Nodes, meshNode)
+		cv.NodeNames[meshNode] = solid.Name
+	}
 
-	return mesh, bbox.Array(), nil
+	return mesh, bbox.Array()
 }
 
-// convertSolidToMeshNode 将STL固体转换为MST网格节点
-func (cv *StlToMst) convertSolidToMeshNode(solid *stl.Solid, meshNode *mst.MeshNode) *vec3d.Box {
+// convertSolidToMeshNode 将STL固体转换为MST网格节点，焊接顶点后按折痕角生成法线
+func (cv *StlToMst) convertSolidToMeshNode(solid *stl.Solid, meshNode *mst.MeshNode, mesh *mst.Mesh, colorMap map[[3]byte]int32) *vec3d.Box {
 	bbox := vec3d.MinBox
-
-	// 为每个三角形创建面组
-	faceGroup := &mst.MeshTriangle{
-		Batchid: int32(0), // 使用默认材质的索引
-	}
-
-	// 处理所有三角形
 	for _, triangle := range solid.Triangles {
-		// 添加三个顶点
 		for _, vertex := range triangle.Vertices {
-			meshNode.Vertices = append(meshNode.Vertices, vertex)
-
-			// 转换为vec3d并扩展边界框
-			v3d := vec3d.T{float64(vertex[0]), float64(vertex[1]), float64(vertex[2])}
+			v3d := stlVec3d(vertex)
 			bbox.Extend(&v3d)
 		}
+	}
+
+	// 按材质创建面组
+	decoder := newStlColorDecoder(solid.BinaryHeader)
+	groups := make(map[int32]*mst.MeshTriangle)
+	faceGroup := func(ti int) *mst.MeshTriangle {
+		batch := int32(0) // 默认材质的索引
+		if cl, ok := decoder.color(solid.Triangles[ti].Attributes); ok {
+			idx, ok := colorMap[cl]
+			if !ok {
+				idx = int32(len(mesh.Materials))
+				colorMap[cl] = idx
+				mesh.Materials = append(mesh.Materials, &mst.BaseMaterial{Color: cl})
+			}
+			batch = idx
+		}
+		tg, ok := groups[batch]
+		if !ok {
+			tg = &mst.MeshTriangle{Batchid: batch}
+			groups[batch] = tg
+			meshNode.FaceGroup = append(meshNode.FaceGroup, tg)
+		}
+		return tg
+	}
+
+	if cv.WeldTolerance < 0 {
+		// 不焊接时每个三角形使用独立的三个顶点
+		for ti, triangle := range solid.Triangles {
+			baseIdx := uint32(len(meshNode.Vertices))
+			meshNode.Vertices = append(meshNode.Vertices, triangle.Vertices[:]...)
+			tg := faceGroup(ti)
+			tg.Faces = append(tg.Faces, &mst.Face{
+				Vertex: [3]uint32{baseIdx, baseIdx + 1, baseIdx + 2},
+			})
+		}
+		meshNode.ReComputeNormal()
+		return &bbox
+	}
 
-		// 添加面（三角形）
-		baseIdx := uint32(len(meshNode.Vertices) - 3)
-		faceGroup.Faces = append(faceGroup.Faces, &mst.Face{
-			Vertex: [3]uint32{baseIdx, baseIdx + 1, baseIdx + 2},
-		})
+	welded := weldTriangles(solid.Triangles, cv.WeldTolerance)
+	if cv.Report == nil {
+		cv.Report = &StlMeshReport{}
 	}
+	cv.Report.merge(welded.report)
+	normals := welded.cornerNormals(cv.creaseAngle())
 
-	// 设置面组
-	meshNode.FaceGroup = append(meshNode.FaceGroup, faceGroup)
+	// 折痕两侧的角点法线不同，需要拆分顶点
+	type vertexKey struct {
+		index  uint32
+		normal vec3.T
+	}
+	vertMap := make(map[vertexKey]uint32)
+	for fi, f := range welded.faces {
+		face := &mst.Face{}
+		for k, idx := range f {
+			key := vertexKey{idx, normals[fi][k]}
+			vi, ok := vertMap[key]
+			if !ok {
+				vi = uint32(len(meshNode.Vertices))
+				vertMap[key] = vi
+				meshNode.Vertices = append(meshNode.Vertices, welded.positions[idx])
+				meshNode.Normals = append(meshNode.Normals, key.normal)
+			}
+			face.Vertex[k] = vi
+		}
+		tg := faceGroup(welded.triangles[fi])
+		tg.Faces = append(tg.Faces, face)
+	}
 
 	return &bbox
 }
 
 // ConvertWithScale 转换STL文件并应用缩放
 func (cv *StlToMst) ConvertWithScale(inputFilename string, scale float64) (*mst.Mesh, *[6]float64, error) {
-	mesh := mst.NewMesh()
-
 	// 读取STL文件
-	solid, err := stl.ReadFile(inputFilename)
+	solids, err := readStlFile(inputFilename)
 	if err != nil {
 		return nil, nil, fmt.Errorf("读取STL文件失败: %v", err)
 	}
 
 	// 应用缩放
 	if scale != 1.0 {
-		solid.Scale(scale)
+		for _, solid := range solids {
+			solid.Scale(scale)
+		}
 	}
 
 	// 设置基础目录
 	cv.baseDir = filepath.Dir(inputFilename)
 
-	// 创建默认材质
-	defaultMaterial := &mst.BaseMaterial{
-		Color: [3]byte{200, 200, 200}, // 默认灰色
-	}
-	mesh.Materials = append(mesh.Materials, defaultMaterial)
-
-	// 创建网格节点
-	meshNode := &mst.MeshNode{}
-
-	// 转换所有三角形
-	bbox := cv.convertSolidToMeshNode(solid, meshNode)
-
-	// 计算法线
-	meshNode.ReComputeNormal()
-
-	// 添加到网格
-	mesh.Nodes = append(mesh.Nodes, meshNode)
-
-	return mesh, bbox.Array(), nil
+	mesh, bbox := cv.convertSolids(solids)
+	return mesh, bbox, nil
 }
 
 // ConvertWithTransform 转换STL文件并应用变换矩阵
 func (cv *StlToMst) ConvertWithTransform(inputFilename string, transform *mat4d.T) (*mst.Mesh, *[6]float64, error) {
-	mesh := mst.NewMesh()
-
 	// 读取STL文件
-	solid, err := stl.ReadFile(inputFilename)
+	solids, err := readStlFile(inputFilename)
 	if err != nil {
 		return nil, nil, fmt.Errorf("读取STL文件失败: %v", err)
 	}
 
 	// 应用变换
 	if transform != nil {
-		solid.Transform(transform)
+		for _, solid := range solids {
+			solid.Transform(transform)
+		}
 	}
 
 	// 设置基础目录
 	cv.baseDir = filepath.Dir(inputFilename)
 
-	// 创建默认材质
-	defaultMaterial := &mst.BaseMaterial{
-		Color: [3]byte{200, 200, 200}, // 默认灰色
-	}
-	mesh.Materials = append(mesh.Materials, defaultMaterial)
-
-	// 创建网格节点
-	meshNode := &mst.MeshNode{}
-
-	// 转换所有三角形
-	bbox := cv.convertSolidToMeshNode(solid, meshNode)
-
-	// 计算法线
-	meshNode.ReComputeNormal()
-
-	// 添加到网格
-	mesh.Nodes = append(mesh.Nodes, meshNode)
-
-	return mesh, bbox.Array(), nil
+	mesh, bbox := cv.convertSolids(solids)
+	return mesh, bbox, nil
 }
 
 // ConvertFromSolid 直接从STL固体对象转换
 func (cv *StlToMst) ConvertFromSolid(solid *stl.Solid) (*mst.Mesh, *[6]float64, error) {
-	mesh := mst.NewMesh()
-
-	// 创建默认材质
-	defaultMaterial := &mst.BaseMaterial{
-		Color: [3]byte{200, 200, 200}, // 默认灰色
-	}
-	mesh.Materials = append(mesh.Materials, defaultMaterial)
-
-	// 创建网格节点
-	meshNode := &mst.MeshNode{}
-
-	// 转换所有三角形
-	bbox := cv.convertSolidToMeshNode(solid, meshNode)
-
-	// 计算法线
-	meshNode.ReComputeNormal()
-
-	// 添加到网格
-	mesh.Nodes = append(mesh.Nodes, meshNode)
-
-	return mesh, bbox.Array(), nil
+	mesh, bbox := cv.convertSolids([]*stl.Solid{solid})
+	return mesh, bbox, nil
 }
 
 // Ensure StlToMst implements FormatConvert interface
@@ -0,0 +1,96 @@
+package asset3d
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/flywave/go-stl"
+
+	mst "github.com/flywave/go-mst"
+	"github.com/flywave/go3d/vec3"
+)
+
+// stlExportHeader 二进制 STL 的头部，不能以 "solid" 开头，也不含 "COLOR="，颜色按 VisCAM 方式写出
+const stlExportHeader = "binary STL exported from MST"
+
+// MstToStl 将 MST 网格导出为 STL，实例按每个变换展开为独立的三角形。
+// 二进制格式在属性字段中按 VisCAM 方式写出面颜色: 节点有顶点颜色时取三个顶点的平均值，
+// 否则取材质颜色
+type MstToStl struct {
+	// Ascii 为 true 时写出 ASCII STL，每个节点为一个 solid，否则写出二进制 STL
+	Ascii bool
+	// NodeNames 节点名，ASCII 格式中用作 solid 名
+	NodeNames map[*mst.MeshNode]string
+}
+
+func (cv *MstToStl) Export(mesh *mst.Mesh, path string) error {
+	em := flattenMesh(mesh, cv.NodeNames)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if cv.Ascii {
+		for _, nd := range em.nodes {
+			solid := &stl.Solid{Name: nd.name, IsAscii: true, Triangles: em.stlTriangles(nd, false)}
+			if err = solid.WriteAll(w); err != nil {
+				break
+			}
+		}
+	} else {
+		header := make([]byte, stlBinaryHeaderSize-4)
+		copy(header, stlExportHeader)
+		solid := &stl.Solid{BinaryHeader: header}
+		for _, nd := range em.nodes {
+			solid.Triangles = append(solid.Triangles, em.stlTriangles(nd, true)...)
+		}
+		err = solid.WriteAll(w)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// stlTriangles 节点的三角形，法线由顶点重新计算
+func (em *exportMesh) stlTriangles(nd *exportNode, color bool) []stl.Triangle {
+	var tris []stl.Triangle
+	for _, g := range nd.groups {
+		var mtlAttr uint16
+		if color && g.material >= 0 && em.materials[g.material] != nil {
+			mtlAttr = stlColorAttr(em.materials[g.material].GetColor())
+		}
+		for _, f := range g.faces {
+			t := stl.Triangle{Vertices: [3]vec3.T{nd.vertices[f[0]], nd.vertices[f[1]], nd.vertices[f[2]]}}
+			e1 := vec3.Sub(&t.Vertices[1], &t.Vertices[0])
+			e2 := vec3.Sub(&t.Vertices[2], &t.Vertices[0])
+			t.Normal = vec3.Cross(&e1, &e2)
+			if t.Normal.LengthSqr() > 0 {
+				t.Normal.Normalize()
+			}
+			if color {
+				t.Attributes = mtlAttr
+				if len(nd.colors) > 0 {
+					var sum [3]int
+					for _, idx := range f {
+						for k := range sum {
+							sum[k] += int(nd.colors[idx][k])
+						}
+					}
+					t.Attributes = stlColorAttr([3]byte{byte(sum[0] / 3), byte(sum[1] / 3), byte(sum[2] / 3)})
+				}
+			}
+			tris = append(tris, t)
+		}
+	}
+	return tris
+}
+
+// stlColorAttr 按 VisCAM 格式编码颜色，与 stlColorDecoder 相反
+func stlColorAttr(c [3]byte) uint16 {
+	return 0x8000 | uint16(c[0]>>3)<<10 | uint16(c[1]>>3)<<5 | uint16(c[2]>>3)
+}
@@ -0,0 +1,177 @@
+package asset3d
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/flywave/go-stl"
+	"github.com/flywave/go3d/vec3"
+)
+
+const (
+	stlBinaryHeaderSize   = 84
+	stlBinaryTriangleSize = 50
+)
+
+// readStlFile 读取STL文件，ASCII 文件中的每个 solid 块对应一个返回的固体，
+// 二进制文件只有一个固体
+func readStlFile(path string) ([]*stl.Solid, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	binaryFile, err := isStlBinary(f)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if binaryFile {
+		solid, err := stl.ReadAll(f)
+		if err != nil {
+			return nil, err
+		}
+		return []*stl.Solid{solid}, nil
+	}
+	return readStlASCII(f)
+}
+
+// isStlBinary 与 go-stl 相同，按头部记录的三角形数量与文件大小是否吻合判断二进制格式
+func isStlBinary(r io.ReadSeeker) (bool, error) {
+	var header [stlBinaryHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, err
+	}
+	count := binary.LittleEndian.Uint32(header[stlBinaryHeaderSize-4:])
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return false, err
+	}
+	return int64(count)*stlBinaryTriangleSize+stlBinaryHeaderSize == size, nil
+}
+
+// readStlASCII 解析 ASCII STL，支持多个 solid 块，solid 块之外的三角形归入一个无名固体
+func readStlASCII(r io.Reader) ([]*stl.Solid, error) {
+	var solids []*stl.Solid
+	var cur *stl.Solid
+	var tri stl.Triangle
+	nv := -1 // 当前三角形已读取的顶点数，-1 表示不在 facet 中
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lno := 0
+	for scanner.Scan() {
+		lno++
+		fields := strings.Fields(string(bytes.TrimSpace(scanner.Bytes())))
+		if len(fields) == 0 {
+			continue
+		}
+		var err error
+		switch strings.ToLower(fields[0]) {
+		case "solid":
+			name := strings.TrimSpace(strings.TrimSpace(scanner.Text())[len(fields[0]):])
+			cur = &stl.Solid{Name: name, IsAscii: true}
+			solids = append(solids, cur)
+		case "endsolid":
+			cur = nil
+		case "facet":
+			tri = stl.Triangle{}
+			nv = 0
+			if len(fields) >= 5 && strings.EqualFold(fields[1], "normal") {
+				tri.Normal, err = parseStlVector(fields[2:5])
+			}
+		case "vertex":
+			if nv < 0 || nv >= 3 || len(fields) < 4 {
+				err = fmt.Errorf("unexpected vertex")
+				break
+			}
+			tri.Vertices[nv], err = parseStlVector(fields[1:4])
+			nv++
+		case "endfacet":
+			if nv != 3 {
+				err = fmt.Errorf("facet has %d vertices", nv)
+				break
+			}
+			if cur == nil {
+				cur = &stl.Solid{IsAscii: true}
+				solids = append(solids, cur)
+			}
+			cur.Triangles = append(cur.Triangles, tri)
+			nv = -1
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lno, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(solids) == 0 {
+		return nil, fmt.Errorf("no solid found")
+	}
+	return solids, nil
+}
+
+func parseStlVector(fields []string) (vec3.T, error) {
+	var v vec3.T
+	for i := range v {
+		f, err := strconv.ParseFloat(fields[i], 32)
+		if err != nil {
+			return v, err
+		}
+		v[i] = float32(f)
+	}
+	return v, nil
+}
+
+// stlColorDecoder 解码二进制 STL 属性字段中的颜色。
+// VisCAM/SolidView: 第 15 位为 1 时有效，0-4 位为蓝、5-9 位为绿、10-14 位为红；
+// Materialise Magics: 头部含 "COLOR=" 及默认 RGBA，第 15 位为 0 时使用面颜色，
+// 0-4 位为红、5-9 位为绿、10-14 位为蓝，否则使用默认颜色
+type stlColorDecoder struct {
+	materialise bool
+	// def Materialise 头部中的默认颜色
+	def [3]byte
+}
+
+func newStlColorDecoder(header []byte) *stlColorDecoder {
+	d := &stlColorDecoder{}
+	if i := bytes.Index(header, []byte("COLOR=")); i >= 0 && i+10 <= len(header) {
+		d.materialise = true
+		copy(d.def[:], header[i+6:i+9])
+	}
+	return d
+}
+
+// color 返回属性字段表示的颜色，没有颜色时返回 false
+func (d *stlColorDecoder) color(attr uint16) ([3]byte, bool) {
+	flag := attr&0x8000 != 0
+	if d.materialise {
+		if flag {
+			return d.def, true
+		}
+		return [3]byte{stlColor5(attr), stlColor5(attr >> 5), stlColor5(attr >> 10)}, true
+	}
+	if !flag {
+		return [3]byte{}, false
+	}
+	return [3]byte{stlColor5(attr >> 10), stlColor5(attr >> 5), stlColor5(attr)}, true
+}
+
+// stlColor5 将 5 位颜色分量扩展到 8 位
+func stlColor5(v uint16) byte {
+	c := byte(v & 0x1f)
+	return c<<3 | c>>2
+}
@@ -2,6 +2,7 @@ package asset3d
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
 	mst "github.com/flywave/go-mst"
@@ -69,9 +70,10 @@ func TestStlToMst_Convert(t *testing.T) {
 		t.Errorf("期望1个节点，实际%d个", len(mesh.Nodes))
 	}
 
+	// 两个共面三角形焊接后共享两个顶点
 	node := mesh.Nodes[0]
-	if len(node.Vertices) != 6 {
-		t.Errorf("期望6个顶点，实际%d个", len(node.Vertices))
+	if len(node.Vertices) != 4 {
+		t.Errorf("期望4个顶点，实际%d个", len(node.Vertices))
 	}
 
 	if len(node.FaceGroup) != 1 {
@@ -202,3 +204,230 @@ func TestStlToMst_ConvertFromSolid(t *testing.T) {
 		}
 	}
 }
+
+// stlCube 边长为 1 的立方体，12 个三角形均朝外
+func stlCube() []stl.Triangle {
+	p := func(i int) vec3.T {
+		return vec3.T{float32(i & 1), float32((i >> 1) & 1), float32((i >> 2) & 1)}
+	}
+	quads := [][4]int{
+		{0, 2, 3, 1}, {4, 5, 7, 6}, {0, 1, 5, 4},
+		{2, 6, 7, 3}, {0, 4, 6, 2}, {1, 3, 7, 5},
+	}
+	var tris []stl.Triangle
+	for _, q := range quads {
+		tris = append(tris,
+			stl.Triangle{Vertices: [3]vec3.T{p(q[0]), p(q[1]), p(q[2])}},
+			stl.Triangle{Vertices: [3]vec3.T{p(q[0]), p(q[2]), p(q[3])}},
+		)
+	}
+	return tris
+}
+
+func TestStlToMst_WeldCreaseAngle(t *testing.T) {
+	converter := NewStlToMst()
+	mesh, _, err := converter.ConvertFromSolid(&stl.Solid{Triangles: stlCube()})
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+
+	// 90 度的棱超过默认折痕角，每个面使用独立的 4 个顶点
+	node := mesh.Nodes[0]
+	if len(node.Vertices) != 24 {
+		t.Errorf("期望24个顶点，实际%d个", len(node.Vertices))
+	}
+	for i, n := range node.Normals {
+		if abs := n[0]*n[0] + n[1]*n[1] + n[2]*n[2]; abs < 0.99 || abs > 1.01 {
+			t.Errorf("法线[%d] = %v 不是单位向量", i, n)
+		}
+	}
+
+	report := converter.Report
+	if report == nil {
+		t.Fatal("缺少拓扑检查结果")
+	}
+	if report.Vertices != 8 || report.Triangles != 12 {
+		t.Errorf("焊接结果 = %d 顶点 %d 三角形, 期望 8 和 12", report.Vertices, report.Triangles)
+	}
+	if !report.Watertight() || report.FlippedEdges != 0 {
+		t.Errorf("立方体应当封闭: %+v", report)
+	}
+
+	// 折痕角大于 90 度时顶点共享平均法线
+	converter.CreaseAngle = 100
+	mesh, _, _ = converter.ConvertFromSolid(&stl.Solid{Triangles: stlCube()})
+	if len(mesh.Nodes[0].Vertices) != 8 {
+		t.Errorf("期望8个顶点，实际%d个", len(mesh.Nodes[0].Vertices))
+	}
+
+	// 零值的转换器使用默认折痕角，折起约 8 度的两个三角形共享顶点
+	fold := []stl.Triangle{
+		{Vertices: [3]vec3.T{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}}},
+		{Vertices: [3]vec3.T{{1, 0, 0}, {1, 1, 0.1}, {0, 1, 0}}},
+	}
+	zero := &StlToMst{}
+	mesh, _, _ = zero.ConvertFromSolid(&stl.Solid{Triangles: fold})
+	if len(mesh.Nodes[0].Vertices) != 4 {
+		t.Errorf("零值转换器期望4个顶点，实际%d个", len(mesh.Nodes[0].Vertices))
+	}
+
+	// 折痕角小于 0 时总是拆分
+	zero.CreaseAngle = -1
+	mesh, _, _ = zero.ConvertFromSolid(&stl.Solid{Triangles: fold})
+	if len(mesh.Nodes[0].Vertices) != 6 {
+		t.Errorf("总是拆分时期望6个顶点，实际%d个", len(mesh.Nodes[0].Vertices))
+	}
+
+	// FormatFactory 创建的转换器使用默认折痕角
+	if cv := FormatFactory(STL).(*StlToMst); cv.CreaseAngle != StlDefaultCreaseAngle {
+		t.Errorf("FormatFactory 的折痕角为%v，期望%v", cv.CreaseAngle, StlDefaultCreaseAngle)
+	}
+}
+
+func TestStlToMst_WeldTolerance(t *testing.T) {
+	tris := []stl.Triangle{
+		{Vertices: [3]vec3.T{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}}},
+		{Vertices: [3]vec3.T{{1.0001, 0, 0}, {1, 1, 0}, {0, 1.0001, 0}}},
+	}
+
+	converter := NewStlToMst()
+	mesh, _, _ := converter.ConvertFromSolid(&stl.Solid{Triangles: tris})
+	if len(mesh.Nodes[0].Vertices) != 6 {
+		t.Errorf("精确焊接期望6个顶点，实际%d个", len(mesh.Nodes[0].Vertices))
+	}
+
+	converter.WeldTolerance = 0.001
+	mesh, _, _ = converter.ConvertFromSolid(&stl.Solid{Triangles: tris})
+	if len(mesh.Nodes[0].Vertices) != 4 {
+		t.Errorf("容差焊接期望4个顶点，实际%d个", len(mesh.Nodes[0].Vertices))
+	}
+	if n := len(converter.Report.BoundaryEdges); n != 4 {
+		t.Errorf("期望4条开放边，实际%d条", n)
+	}
+
+	converter.WeldTolerance = -1
+	mesh, _, _ = converter.ConvertFromSolid(&stl.Solid{Triangles: tris})
+	if len(mesh.Nodes[0].Vertices) != 6 || converter.Report != nil {
+		t.Errorf("不焊接时期望6个顶点，实际%d个", len(mesh.Nodes[0].Vertices))
+	}
+}
+
+func TestStlToMst_NonManifold(t *testing.T) {
+	// 三个三角形共用一条边
+	tris := []stl.Triangle{
+		{Vertices: [3]vec3.T{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}}},
+		{Vertices: [3]vec3.T{{1, 0, 0}, {0, 0, 0}, {0, -1, 0}}},
+		{Vertices: [3]vec3.T{{0, 0, 0}, {1, 0, 0}, {0, 0, 1}}},
+		{Vertices: [3]vec3.T{{0, 0, 0}, {0, 0, 0}, {0, 0, 1}}},
+	}
+	converter := NewStlToMst()
+	if _, _, err := converter.ConvertFromSolid(&stl.Solid{Triangles: tris}); err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	report := converter.Report
+	if len(report.NonManifoldEdges) != 1 || report.NonManifoldEdges[0].Faces != 3 {
+		t.Errorf("期望1条非流形边，实际 %+v", report.NonManifoldEdges)
+	}
+	if report.DegenerateTriangles != 1 {
+		t.Errorf("期望1个退化三角形，实际%d个", report.DegenerateTriangles)
+	}
+	if report.Watertight() {
+		t.Error("非流形网格不应被视为封闭")
+	}
+}
+
+func TestStlToMst_MultipleSolids(t *testing.T) {
+	data := `solid part_a
+  facet normal 0 0 1
+    outer loop
+      vertex 0 0 0
+      vertex 1 0 0
+      vertex 0 1 0
+    endloop
+  endfacet
+endsolid part_a
+solid part_b
+  facet normal 0 0 1
+    outer loop
+      vertex 0 0 1
+      vertex 1 0 1
+      vertex 0 1 1
+    endloop
+  endfacet
+  facet normal 0 0 1
+    outer loop
+      vertex 1 0 1
+      vertex 1 1 1
+      vertex 0 1 1
+    endloop
+  endfacet
+endsolid part_b
+`
+	tempFile := filepath.Join(t.TempDir(), "multi.stl")
+	if err := os.WriteFile(tempFile, []byte(data), 0o644); err != nil {
+		t.Fatalf("无法创建测试STL文件: %v", err)
+	}
+
+	converter := NewStlToMst()
+	mesh, bbox, err := converter.Convert(tempFile)
+	if err != nil {
+		t.Fatalf("转换失败: %v", err)
+	}
+	if len(mesh.Nodes) != 2 {
+		t.Fatalf("期望2个节点，实际%d个", len(mesh.Nodes))
+	}
+	for i, name := range []string{"part_a", "part_b"} {
+		if got := converter.NodeNames[mesh.Nodes[i]]; got != name {
+			t.Errorf("节点[%d]名称 = %q, 期望 %q", i, got, name)
+		}
+	}
+	if n := len(mesh.Nodes[1].FaceGroup[0].Faces); n != 2 {
+		t.Errorf("期望2个面，实际%d个", n)
+	}
+	if bbox[5] != 1 {
+		t.Errorf("边界框 = %v", bbox)
+	}
+}
+
+func TestStlToMst_FacetColor(t *testing.T) {
+	tris := func(attrs ...uint16) []stl.Triangle {
+		var res []stl.Triangle
+		for i, a := range attrs {
+			x := float32(i * 2)
+			res = append(res, stl.Triangle{
+				Vertices:   [3]vec3.T{{x, 0, 0}, {x + 1, 0, 0}, {x, 1, 0}},
+				Attributes: a,
+			})
+		}
+		return res
+	}
+
+	// VisCAM/SolidView: 最高位表示颜色有效，红色在高位
+	converter := NewStlToMst()
+	mesh, _, _ := converter.ConvertFromSolid(&stl.Solid{Triangles: tris(0x8000|0x1f<<10, 0, 0x8000|0x1f<<10)})
+	if len(mesh.Materials) != 2 {
+		t.Fatalf("期望2个材质，实际%d个", len(mesh.Materials))
+	}
+	if cl := mesh.Materials[1].(*mst.BaseMaterial).Color; cl != [3]byte{255, 0, 0} {
+		t.Errorf("颜色 = %v, 期望红色", cl)
+	}
+	groups := mesh.Nodes[0].FaceGroup
+	if len(groups) != 2 || len(groups[0].Faces) != 2 || groups[0].Batchid != 1 || groups[1].Batchid != 0 {
+		t.Errorf("面组划分错误: %d", len(groups))
+	}
+
+	// Materialise: 头部记录默认颜色，最高位为 0 时使用面颜色，红色在低位
+	header := make([]byte, 80)
+	copy(header, "COLOR=")
+	copy(header[6:], []byte{0, 0, 255, 255})
+	mesh, _, _ = converter.ConvertFromSolid(&stl.Solid{BinaryHeader: header, Triangles: tris(0x1f, 0x8000)})
+	if len(mesh.Materials) != 3 {
+		t.Fatalf("期望3个材质，实际%d个", len(mesh.Materials))
+	}
+	if cl := mesh.Materials[1].(*mst.BaseMaterial).Color; cl != [3]byte{255, 0, 0} {
+		t.Errorf("面颜色 = %v, 期望红色", cl)
+	}
+	if cl := mesh.Materials[2].(*mst.BaseMaterial).Color; cl != [3]byte{0, 0, 255} {
+		t.Errorf("默认颜色 = %v, 期望蓝色", cl)
+	}
+}
@@ -0,0 +1,231 @@
+package asset3d
+
+import (
+	"math"
+
+	"github.com/flywave/go-stl"
+
+	vec3d "github.com/flywave/go3d/float64/vec3"
+	"github.com/flywave/go3d/vec3"
+)
+
+// StlDefaultCreaseAngle NewStlToMst 使用的默认折痕角(度)
+const StlDefaultCreaseAngle = 30.0
+
+// StlEdge 焊接后网格中的一条边，顶点为焊接后的位置
+type StlEdge struct {
+	A, B vec3.T
+	// Faces 使用该边的三角形数量
+	Faces int
+}
+
+// StlMeshReport 焊接后网格的拓扑检查结果，用于 3D 打印前的质量检查
+type StlMeshReport struct {
+	// Vertices 焊接后的顶点数
+	Vertices int
+	// Triangles 有效三角形数
+	Triangles int
+	// DegenerateTriangles 焊接后有重合顶点而被丢弃的三角形数
+	DegenerateTriangles int
+	// NonManifoldEdges 被三个及以上三角形共用的边
+	NonManifoldEdges []StlEdge
+	// BoundaryEdges 只属于一个三角形的边，即开放边界
+	BoundaryEdges []StlEdge
+	// FlippedEdges 两个相邻三角形以相同方向使用的边，说明其中一个三角形朝向相反
+	FlippedEdges int
+}
+
+// Watertight 网格是否封闭且为流形
+func (r *StlMeshReport) Watertight() bool {
+	return len(r.NonManifoldEdges) == 0 && len(r.BoundaryEdges) == 0
+}
+
+func (r *StlMeshReport) merge(o *StlMeshReport) {
+	r.Vertices += o.Vertices
+	r.Triangles += o.Triangles
+	r.DegenerateTriangles += o.DegenerateTriangles
+	r.NonManifoldEdges = append(r.NonManifoldEdges, o.NonManifoldEdges...)
+	r.BoundaryEdges = append(r.BoundaryEdges, o.BoundaryEdges...)
+	r.FlippedEdges += o.FlippedEdges
+}
+
+// stlWelder 按距离容差合并位置相同的顶点，容差为 0 时只合并完全相同的坐标
+type stlWelder struct {
+	tolerance float64
+	positions []vec3.T
+	exact     map[vec3.T]uint32
+	grid      map[[3]int64][]uint32
+}
+
+func newStlWelder(tolerance float64) *stlWelder {
+	w := &stlWelder{tolerance: tolerance}
+	if tolerance > 0 {
+		w.grid = make(map[[3]int64][]uint32)
+	} else {
+		w.exact = make(map[vec3.T]uint32)
+	}
+	return w
+}
+
+func (w *stlWelder) cell(p vec3.T) [3]int64 {
+	return [3]int64{
+		int64(math.Floor(float64(p[0]) / w.tolerance)),
+		int64(math.Floor(float64(p[1]) / w.tolerance)),
+		int64(math.Floor(float64(p[2]) / w.tolerance)),
+	}
+}
+
+// weld 返回 p 焊接后的顶点索引
+func (w *stlWelder) weld(p vec3.T) uint32 {
+	if w.exact != nil {
+		if idx, ok := w.exact[p]; ok {
+			return idx
+		}
+		idx := uint32(len(w.positions))
+		w.positions = append(w.positions, p)
+		w.exact[p] = idx
+		return idx
+	}
+
+	// 容差不超过网格尺寸，只需检查相邻的 27 个单元
+	c := w.cell(p)
+	tol2 := w.tolerance * w.tolerance
+	for dx := int64(-1); dx <= 1; dx++ {
+		for dy := int64(-1); dy <= 1; dy++ {
+			for dz := int64(-1); dz <= 1; dz++ {
+				for _, idx := range w.grid[[3]int64{c[0] + dx, c[1] + dy, c[2] + dz}] {
+					q := w.positions[idx]
+					d0, d1, d2 := float64(p[0]-q[0]), float64(p[1]-q[1]), float64(p[2]-q[2])
+					if d0*d0+d1*d1+d2*d2 <= tol2 {
+						return idx
+					}
+				}
+			}
+		}
+	}
+	idx := uint32(len(w.positions))
+	w.positions = append(w.positions, p)
+	w.grid[c] = append(w.grid[c], idx)
+	return idx
+}
+
+// stlWeldedMesh 焊接后的索引三角形
+type stlWeldedMesh struct {
+	positions []vec3.T
+	faces     [][3]uint32
+	// triangles 每个有效三角形在原 STL 中的序号
+	triangles []int
+	report    *StlMeshReport
+}
+
+// weldTriangles 焊接 STL 三角形的顶点并检查拓扑
+func weldTriangles(tris []stl.Triangle, tolerance float64) *stlWeldedMesh {
+	w := newStlWelder(tolerance)
+	res := &stlWeldedMesh{report: &StlMeshReport{}}
+	for ti := range tris {
+		var f [3]uint32
+		for k, v := range tris[ti].Vertices {
+			f[k] = w.weld(v)
+		}
+		if f[0] == f[1] || f[1] == f[2] || f[2] == f[0] {
+			res.report.DegenerateTriangles++
+			continue
+		}
+		res.faces = append(res.faces, f)
+		res.triangles = append(res.triangles, ti)
+	}
+	res.positions = w.positions
+	res.report.Vertices = len(w.positions)
+	res.report.Triangles = len(res.faces)
+	res.checkEdges()
+	return res
+}
+
+// checkEdges 统计每条无向边被使用的次数和方向
+func (m *stlWeldedMesh) checkEdges() {
+	type edgeUse struct {
+		faces, forward int
+	}
+	edges := make(map[[2]uint32]*edgeUse)
+	var order [][2]uint32
+	for _, f := range m.faces {
+		for k := 0; k < 3; k++ {
+			a, b := f[k], f[(k+1)%3]
+			key := [2]uint32{a, b}
+			forward := 1
+			if a > b {
+				key = [2]uint32{b, a}
+				forward = 0
+			}
+			e, ok := edges[key]
+			if !ok {
+				e = &edgeUse{}
+				edges[key] = e
+				order = append(order, key)
+			}
+			e.faces++
+			e.forward += forward
+		}
+	}
+	for _, key := range order {
+		e := edges[key]
+		edge := StlEdge{A: m.positions[key[0]], B: m.positions[key[1]], Faces: e.faces}
+		switch {
+		case e.faces == 1:
+			m.report.BoundaryEdges = append(m.report.BoundaryEdges, edge)
+		case e.faces > 2:
+			m.report.NonManifoldEdges = append(m.report.NonManifoldEdges, edge)
+		case e.forward != 1:
+			// 流形边的两个三角形应以相反方向使用该边
+			m.report.FlippedEdges++
+		}
+	}
+}
+
+// cornerNormals 计算每个三角形角点的法线: 累加共享该顶点且与本面法线夹角不超过
+// creaseAngle(度) 的相邻面法线，按面积加权，creaseAngle 小于 0 时只使用本面法线
+func (m *stlWeldedMesh) cornerNormals(creaseAngle float64) [][3]vec3.T {
+	faceNormals := make([]vec3d.T, len(m.faces))
+	units := make([]vec3d.T, len(m.faces))
+	adj := make([][]int, len(m.positions))
+	for fi, f := range m.faces {
+		a, b, c := stlVec3d(m.positions[f[0]]), stlVec3d(m.positions[f[1]]), stlVec3d(m.positions[f[2]])
+		e1 := vec3d.Sub(&b, &a)
+		e2 := vec3d.Sub(&c, &a)
+		faceNormals[fi] = vec3d.Cross(&e1, &e2)
+		units[fi] = faceNormals[fi]
+		if units[fi].LengthSqr() > 0 {
+			units[fi].Normalize()
+		}
+		for _, idx := range f {
+			adj[idx] = append(adj[idx], fi)
+		}
+	}
+
+	cosCrease := math.Cos(creaseAngle * math.Pi / 180)
+	if creaseAngle < 0 {
+		cosCrease = 2
+	}
+	res := make([][3]vec3.T, len(m.faces))
+	for fi, f := range m.faces {
+		for k, idx := range f {
+			n := vec3d.T{}
+			for _, g := range adj[idx] {
+				if g == fi || vec3d.Dot(&units[fi], &units[g]) >= cosCrease-1e-9 {
+					n.Add(&faceNormals[g])
+				}
+			}
+			if n.LengthSqr() > 0 {
+				n.Normalize()
+			} else {
+				n = units[fi]
+			}
+			res[fi][k] = vec3.T{float32(n[0]), float32(n[1]), float32(n[2])}
+		}
+	}
+	return res
+}
+
+func stlVec3d(v vec3.T) vec3d.T {
+	return vec3d.T{float64(v[0]), float64(v[1]), float64(v[2])}
+}
@@ -265,7 +265,7 @@ func (t *TilesObjToMst) processObjFile(objPath string, mesh *mst.Mesh, ext *vec3
 		}
 
 		if len(face.Corners) >= 3 {
-			triangles := t.triangulateFace(face)
+			triangles := t.triangulateFace(face, reader)
 			for _, triangle := range triangles {
 				t.processTriangle(mtg, triangle, reader, meshNode, ext, objPath)
 			}
@@ -396,21 +396,8 @@ func (t *TilesObjToMst) float32ToByteColor(color []float32) [3]byte {
 	return [3]byte{r, g, b}
 }
 
-func (t *TilesObjToMst) triangulateFace(face gobj.Face) [][]gobj.FaceCorner {
-	if len(face.Corners) == 3 {
-		return [][]gobj.FaceCorner{face.Corners}
-	}
-
-	var triangles [][]gobj.FaceCorner
-	for i := 1; i < len(face.Corners)-1; i++ {
-		triangle := []gobj.FaceCorner{
-			face.Corners[0],
-			face.Corners[i],
-			face.Corners[i+1],
-		}
-		triangles = append(triangles, triangle)
-	}
-	return triangles
+func (t *TilesObjToMst) triangulateFace(face gobj.Face, reader *gobj.ObjReader) [][]gobj.FaceCorner {
+	return triangulateObjFace(face, reader.V)
 }
 
 func (t *TilesObjToMst) processTriangle(mtg *mst.MeshTriangle, triangle []gobj.FaceCorner, reader *gobj.ObjReader, meshNode *mst.MeshNode, ext *vec3d.Box, objPath string) {
@@ -91,7 +91,7 @@ func TestFloat32ToByteColor_Overshoot(t *testing.T) {
 func TestTriangulateFace_Triangle(t *testing.T) {
 	c := &TilesObjToMst{}
 	face := gobj.Face{Corners: []gobj.FaceCorner{{}, {}, {}}}
-	tris := c.triangulateFace(face)
+	tris := c.triangulateFace(face, &gobj.ObjReader{})
 	if len(tris) != 1 {
 		t.Errorf("triangle face: got %d triangles, want 1", len(tris))
 	}
@@ -100,7 +100,7 @@ func TestTriangulateFace_Triangle(t *testing.T) {
 func TestTriangulateFace_Quad(t *testing.T) {
 	c := &TilesObjToMst{}
 	face := gobj.Face{Corners: []gobj.FaceCorner{{}, {}, {}, {}}}
-	tris := c.triangulateFace(face)
+	tris := c.triangulateFace(face, &gobj.ObjReader{})
 	if len(tris) != 2 {
 		t.Errorf("quad face: got %d triangles, want 2", len(tris))
 	}
@@ -109,7 +109,7 @@ func TestTriangulateFace_Quad(t *testing.T) {
 func TestTriangulateFace_Pentagon(t *testing.T) {
 	c := &TilesObjToMst{}
 	face := gobj.Face{Corners: []gobj.FaceCorner{{}, {}, {}, {}, {}}}
-	tris := c.triangulateFace(face)
+	tris := c.triangulateFace(face, &gobj.ObjReader{})
 	if len(tris) != 3 {
 		t.Errorf("pentagon face: got %d triangles, want 3", len(tris))
 	}
@@ -118,12 +118,43 @@ func TestTriangulateFace_Pentagon(t *testing.T) {
 func TestTriangulateFace_LessThan3(t *testing.T) {
 	c := &TilesObjToMst{}
 	face := gobj.Face{Corners: []gobj.FaceCorner{{}, {}}}
-	tris := c.triangulateFace(face)
+	tris := c.triangulateFace(face, &gobj.ObjReader{})
 	if len(tris) != 0 {
 		t.Errorf("line face: got %d triangles, want 0", len(tris))
 	}
 }
 
+func TestTriangulateFace_Concave(t *testing.T) {
+	c := &TilesObjToMst{}
+	// L-shaped hexagon whose fan from corner 0 would leave the polygon
+	reader := &gobj.ObjReader{}
+	reader.V = []vec3.T{
+		{2, 1, 0}, {1, 1, 0}, {1, 2, 0}, {0, 2, 0}, {0, 0, 0}, {2, 0, 0},
+	}
+	face := gobj.Face{Corners: []gobj.FaceCorner{
+		{VertexIndex: 0}, {VertexIndex: 1}, {VertexIndex: 2},
+		{VertexIndex: 3}, {VertexIndex: 4}, {VertexIndex: 5},
+	}}
+	tris := c.triangulateFace(face, reader)
+	if len(tris) != 4 {
+		t.Fatalf("concave face: got %d triangles, want 4", len(tris))
+	}
+	area := 0.0
+	for _, tri := range tris {
+		a := reader.V[tri[0].VertexIndex]
+		b := reader.V[tri[1].VertexIndex]
+		d := reader.V[tri[2].VertexIndex]
+		cross := float64((b[0]-a[0])*(d[1]-a[1]) - (b[1]-a[1])*(d[0]-a[0]))
+		if cross <= 0 {
+			t.Errorf("triangle %v is flipped or degenerate", tri)
+		}
+		area += cross / 2
+	}
+	if math.Abs(area-3) > 1e-6 {
+		t.Errorf("triangulated area = %f, want 3", area)
+	}
+}
+
 // --- parseMetadata ---
 
 func TestTilesObj_ParseMetadata_FileNotFound(t *testing.T) {
@@ -0,0 +1,234 @@
+package asset3d
+
+import (
+	"math"
+	"sort"
+
+	vec3d "github.com/flywave/go3d/float64/vec3"
+)
+
+// triangulatePolygon 使用耳切法对可能带洞的平面多边形做三角化。
+// 返回的索引指向 outer 与各个 holes 依次拼接后的顶点序列，三角形保持 outer 的绕序
+func triangulatePolygon(outer []vec3d.T, holes [][]vec3d.T) [][3]int {
+	if len(outer) < 3 {
+		return nil
+	}
+	if len(outer) == 3 && len(holes) == 0 {
+		return [][3]int{{0, 1, 2}}
+	}
+
+	normal := polygonNormal(outer)
+	if normal.Length() == 0 {
+		return fanTriangles(len(outer))
+	}
+	ax, ay := projectionAxes(normal)
+
+	var pts [][2]float64
+	project := func(ring []vec3d.T) []int {
+		idx := make([]int, len(ring))
+		for i, p := range ring {
+			idx[i] = len(pts)
+			pts = append(pts, [2]float64{p[ax], p[ay]})
+		}
+		return idx
+	}
+
+	ring := project(outer)
+	flip := signedArea(pts, ring) < 0
+	if flip {
+		reverseInts(ring)
+	}
+
+	var holeRings [][]int
+	for _, h := range holes {
+		hr := project(h)
+		if len(hr) < 3 {
+			continue
+		}
+		if signedArea(pts, hr) > 0 {
+			reverseInts(hr)
+		}
+		holeRings = append(holeRings, hr)
+	}
+	ring = bridgeHoles(pts, ring, holeRings)
+
+	tris := earClip(pts, ring)
+	if flip {
+		for i := range tris {
+			tris[i][1], tris[i][2] = tris[i][2], tris[i][1]
+		}
+	}
+	return tris
+}
+
+func fanTriangles(n int) [][3]int {
+	var tris [][3]int
+	for i := 1; i+1 < n; i++ {
+		tris = append(tris, [3]int{0, i, i + 1})
+	}
+	return tris
+}
+
+// polygonNormal 按 Newell 方法计算多边形法线，对非凸和轻微非平面的多边形也稳定
+func polygonNormal(ring []vec3d.T) vec3d.T {
+	var n vec3d.T
+	for i := range ring {
+		a, b := ring[i], ring[(i+1)%len(ring)]
+		n[0] += (a[1] - b[1]) * (a[2] + b[2])
+		n[1] += (a[2] - b[2]) * (a[0] + b[0])
+		n[2] += (a[0] - b[0]) * (a[1] + b[1])
+	}
+	return n
+}
+
+// projectionAxes 丢弃法线分量最大的坐标轴，返回投影平面的两个轴
+func projectionAxes(n vec3d.T) (int, int) {
+	x, y, z := math.Abs(n[0]), math.Abs(n[1]), math.Abs(n[2])
+	switch {
+	case x >= y && x >= z:
+		return 1, 2
+	case y >= x && y >= z:
+		return 2, 0
+	}
+	return 0, 1
+}
+
+func signedArea(pts [][2]float64, ring []int) float64 {
+	var a float64
+	for i := range ring {
+		p, q := pts[ring[i]], pts[ring[(i+1)%len(ring)]]
+		a += p[0]*q[1] - q[0]*p[1]
+	}
+	return a / 2
+}
+
+func reverseInts(s []int) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+}
+
+func cross2(o, a, b [2]float64) float64 {
+	return (a[0]-o[0])*(b[1]-o[1]) - (a[1]-o[1])*(b[0]-o[0])
+}
+
+// bridgeHoles 按最大 x 坐标从右到左，用一条往返的桥边把每个洞接入外环
+func bridgeHoles(pts [][2]float64, ring []int, holes [][]int) []int {
+	maxX := func(h []int) int {
+		m := 0
+		for i := range h {
+			if pts[h[i]][0] > pts[h[m]][0] {
+				m = i
+			}
+		}
+		return m
+	}
+	sort.SliceStable(holes, func(i, j int) bool {
+		return pts[holes[i][maxX(holes[i])]][0] > pts[holes[j][maxX(holes[j])]][0]
+	})
+
+	for hi, h := range holes {
+		m := maxX(h)
+		mp := pts[h[m]]
+
+		order := make([]int, len(ring))
+		for i := range order {
+			order[i] = i
+		}
+		dist := func(i int) float64 {
+			p := pts[ring[i]]
+			return (p[0]-mp[0])*(p[0]-mp[0]) + (p[1]-mp[1])*(p[1]-mp[1])
+		}
+		sort.SliceStable(order, func(i, j int) bool { return dist(order[i]) < dist(order[j]) })
+
+		bridge := order[0]
+		for _, i := range order {
+			// 当前洞也参与判断，否则桥边可能穿过洞本身
+			if bridgeVisible(pts, mp, pts[ring[i]], ring, holes[hi:]) {
+				bridge = i
+				break
+			}
+		}
+
+		merged := make([]int, 0, len(ring)+len(h)+2)
+		merged = append(merged, ring[:bridge+1]...)
+		for k := 0; k <= len(h); k++ {
+			merged = append(merged, h[(m+k)%len(h)])
+		}
+		merged = append(merged, ring[bridge])
+		merged = append(merged, ring[bridge+1:]...)
+		ring = merged
+	}
+	return ring
+}
+
+// bridgeVisible 判断线段 a-b 是否不与外环、当前洞或尚未处理的洞的任何边相交
+func bridgeVisible(pts [][2]float64, a, b [2]float64, ring []int, holes [][]int) bool {
+	rings := append([][]int{ring}, holes...)
+	for _, r := range rings {
+		for i := range r {
+			p, q := pts[r[i]], pts[r[(i+1)%len(r)]]
+			if p == a || p == b || q == a || q == b {
+				continue
+			}
+			if segmentsCross(a, b, p, q) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func segmentsCross(a, b, c, d [2]float64) bool {
+	d1 := cross2(c, d, a)
+	d2 := cross2(c, d, b)
+	d3 := cross2(a, b, c)
+	d4 := cross2(a, b, d)
+	return ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0))
+}
+
+// earClip 对逆时针的简单环做耳切，找不到耳朵时强制切除当前顶点以保证终止
+func earClip(pts [][2]float64, ring []int) [][3]int {
+	idx := append([]int(nil), ring...)
+	var tris [][3]int
+	miss := 0
+	for i := 0; len(idx) > 3; {
+		n := len(idx)
+		i %= n
+		prev, cur, next := idx[(i+n-1)%n], idx[i], idx[(i+1)%n]
+		if isEar(pts, idx, prev, cur, next) || miss >= n {
+			if cross2(pts[prev], pts[cur], pts[next]) != 0 || miss >= n {
+				tris = append(tris, [3]int{prev, cur, next})
+			}
+			idx = append(idx[:i], idx[i+1:]...)
+			miss = 0
+			continue
+		}
+		i++
+		miss++
+	}
+	if len(idx) == 3 && cross2(pts[idx[0]], pts[idx[1]], pts[idx[2]]) != 0 {
+		tris = append(tris, [3]int{idx[0], idx[1], idx[2]})
+	}
+	return tris
+}
+
+func isEar(pts [][2]float64, idx []int, prev, cur, next int) bool {
+	a, b, c := pts[prev], pts[cur], pts[next]
+	if cross2(a, b, c) <= 0 {
+		return false
+	}
+	for _, k := range idx {
+		if k == prev || k == cur || k == next {
+			continue
+		}
+		p := pts[k]
+		if p == a || p == b || p == c {
+			continue
+		}
+		if cross2(a, b, p) >= 0 && cross2(b, c, p) >= 0 && cross2(c, a, p) >= 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package asset3d
+
+import (
+	"math"
+	"testing"
+
+	vec3d "github.com/flywave/go3d/float64/vec3"
+)
+
+// triangleAreaSum 三角形面积之和，三角形重叠或缺失时与多边形面积不同
+func triangleAreaSum(pts []vec3d.T, tris [][3]int) float64 {
+	var sum float64
+	for _, t := range tris {
+		a, b, c := pts[t[0]], pts[t[1]], pts[t[2]]
+		sum += math.Abs((b[0]-a[0])*(c[1]-a[1])-(b[1]-a[1])*(c[0]-a[0])) / 2
+	}
+	return sum
+}
+
+func TestTriangulatePolygon_Holes(t *testing.T) {
+	outer := []vec3d.T{{0, 0, 0}, {100, 0, 0}, {100, 10, 0}, {0, 10, 0}}
+	tests := []struct {
+		name  string
+		holes [][]vec3d.T
+		area  float64
+	}{
+		{"无洞", nil, 1000},
+		// 最近的外环顶点 (0,10) 与洞的最右顶点之间的连线穿过洞本身
+		{"桥边靠近洞", [][]vec3d.T{{{3, 6, 0}, {1, 6, 0}, {1, 8, 0}, {3, 8, 0}}}, 996},
+		{"两个洞", [][]vec3d.T{
+			{{3, 6, 0}, {1, 6, 0}, {1, 8, 0}, {3, 8, 0}},
+			{{50, 2, 0}, {60, 2, 0}, {60, 8, 0}, {50, 8, 0}},
+		}, 936},
+	}
+	for _, tt := range tests {
+		pts := append([]vec3d.T(nil), outer...)
+		for _, h := range tt.holes {
+			pts = append(pts, h...)
+		}
+		tris := triangulatePolygon(outer, tt.holes)
+		if got := triangleAreaSum(pts, tris); math.Abs(got-tt.area) > 1e-9 {
+			t.Errorf("%s: 三角形面积之和为%v，期望%v", tt.name, got, tt.area)
+		}
+	}
+}
+
+func TestTriangulatePolygon_Concave(t *testing.T) {
+	// L 形多边形，顺时针输入时三角形保持原绕序
+	outer := []vec3d.T{{0, 0, 0}, {0, 2, 0}, {1, 2, 0}, {1, 1, 0}, {2, 1, 0}, {2, 0, 0}}
+	tris := triangulatePolygon(outer, nil)
+	if len(tris) != 4 || triangleAreaSum(outer, tris) != 3 {
+		t.Fatalf("三角化错误: %v", tris)
+	}
+	for _, tr := range tris {
+		a, b, c := outer[tr[0]], outer[tr[1]], outer[tr[2]]
+		if (b[0]-a[0])*(c[1]-a[1])-(b[1]-a[1])*(c[0]-a[0]) > 0 {
+			t.Errorf("三角形绕序与输入不一致: %v", tr)
+		}
+	}
+}
@@ -11,6 +11,7 @@ const (
 	FBX        = ".fbx"
 	TBIN       = ".bin"
 	STL        = ".stl"
+	PLY        = ".ply"
 	RVM        = ".rvm"
 	TILES_OBJ  = "tiles_obj"
 	TILES_OSGB = "tiles_osgb"